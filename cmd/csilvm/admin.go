@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/csilvm"
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// adminCommands are the administrative subcommands. Each returns the exit
+// status of the program.
+var adminCommands = map[string]func(args []string) int{
+	"keys":       keysCommand,
+	"orphans":    orphansCommand,
+	"rotate-key": rotateKeyCommand,
+	"shrink":     shrinkCommand,
+	"volumes":    volumesCommand,
+}
+
+// adminFlags are the flags common to the administrative subcommands.
+type adminFlags struct {
+	vgname       *string
+	lockFilePath *string
+	lvmTimeout   *time.Duration
+	verbose      *bool
+}
+
+func addAdminFlags(fs *flag.FlagSet) *adminFlags {
+	return &adminFlags{
+		vgname:       fs.String("volume-group", "", "The name of the volume group to inspect"),
+		lockFilePath: fs.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances"),
+		lvmTimeout:   fs.Duration("lvm-timeout", lvm.DefaultCommandTimeout, "The maximum duration of a single lvm command invocation"),
+		verbose:      fs.Bool("v", false, "Log the lvm commands that are run"),
+	}
+}
+
+// setup configures logging and lvm according to the flags.
+func (f *adminFlags) setup() error {
+	if *f.vgname == "" {
+		return fmt.Errorf("-volume-group is required")
+	}
+	if *f.lvmTimeout <= 0 {
+		return fmt.Errorf("lvm-timeout requires a positive duration instead of %v", *f.lvmTimeout)
+	}
+	logger := log.New(os.Stderr, fmt.Sprintf("[%s]", *f.vgname), log.LstdFlags|log.Lshortfile)
+	if !*f.verbose {
+		logger.SetOutput(ioutil.Discard)
+	}
+	csilvm.SetLogger(logger)
+	lvm.SetLogger(logger)
+	if *f.lockFilePath != "" {
+		lvm.SetLockFilePath(*f.lockFilePath)
+	}
+	lvm.SetCommandTimeout(*f.lvmTimeout)
+	return nil
+}
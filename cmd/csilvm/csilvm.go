@@ -15,6 +15,7 @@ import (
 	"google.golang.org/grpc"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/Seagate/csiclvm/pkg/cleanup"
 	"github.com/Seagate/csiclvm/pkg/csilvm"
 	"github.com/Seagate/csiclvm/pkg/lvm"
 	"github.com/Seagate/csiclvm/pkg/version"
@@ -54,6 +55,13 @@ func defaultLockfilePathOrEnv() string {
 func main() {
 	rand.Seed(time.Now().UnixNano())
 
+	// Administrative subcommands.
+	if len(os.Args) > 1 {
+		if cmd, ok := adminCommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
 	// Configure flags
 	requestLimitF := flag.Int("request-limit", defaultRequestLimit, "Limits backlog of pending requests.")
 	vgnameF := flag.String("volume-group", "", "The name of the volume group to manage")
@@ -65,10 +73,16 @@ func main() {
 	removeF := flag.Bool("remove-volume-group", false, "If set, the volume group will be removed when ProbeNode is called.")
 	var tagsF stringsFlag
 	flag.Var(&tagsF, "tag", "Value to tag the volume group with (can be given multiple times)")
+	var filesystemsF stringsFlag
+	flag.Var(&filesystemsF, "filesystem", "A filesystem profile, e.g., 'name=fast;type=ext4;mkfs-args=-m 0;mount-options=noatime;forbidden-options=dax' (can be given multiple times)")
+	erasePolicyF := flag.String("erase-policy", string(csilvm.EraseNone), "How DeleteVolume erases volumes created without the 'erase' parameter (one of: none, discard, zero, zero-header)")
+	trimIntervalF := flag.Duration("trim-interval", 0, "If positive, the filesystems of the volumes mounted on this node are trimmed about this often")
 	var probeModulesF stringsFlag
 	flag.Var(&probeModulesF, "probe-module", "Probe checks that the kernel module is loaded")
 	nodeIDF := flag.String("node-id", "", "The node ID reported via the CSI Node gRPC service")
 	lockFilePathF := flag.String("lockfile", defaultLockfilePathOrEnv(), "The path to the lock file used to prevent concurrent lvm invocation by multiple csilvm instances")
+	lvmTimeoutF := flag.Duration("lvm-timeout", lvm.DefaultCommandTimeout, "The maximum duration of a single lvm command invocation")
+	lvmShellF := flag.Bool("lvm-shell", false, "If set, lvm commands are run in a long-lived lvm shell process instead of a new process each")
 	// Metrics-related flags
 	statsdUDPHostEnvVarF := flag.String("statsd-udp-host-env-var", "", "The name of the environment variable containing the host where a statsd service is listening for stats over UDP")
 	statsdUDPPortEnvVarF := flag.String("statsd-udp-port-env-var", "", "The name of the environment variable containing the port where a statsd service is listening for stats over UDP")
@@ -82,6 +96,7 @@ func main() {
 	logger := log.New(os.Stderr, logprefix, logflags)
 	csilvm.SetLogger(logger)
 	lvm.SetLogger(logger)
+	cleanup.SetLogger(logger)
 	// Setup LVM operation lock file.
 	// See
 	// - https://jira.mesosphere.com/browse/DCOS_OSS-5434
@@ -89,6 +104,15 @@ func main() {
 	if *lockFilePathF != "" {
 		lvm.SetLockFilePath(*lockFilePathF)
 	}
+	if *lvmTimeoutF <= 0 {
+		logger.Fatalf("lvm-timeout requires a positive duration instead of %v", *lvmTimeoutF)
+	}
+	lvm.SetCommandTimeout(*lvmTimeoutF)
+	if *lvmShellF {
+		shell := lvm.NewShellExecutor()
+		defer shell.Close()
+		lvm.SetExecutor(shell)
+	}
 	// Determine listen address.
 	if *socketFileF != "" && *socketFileEnvF != "" {
 		logger.Fatalf("cannot specify -unix-addr and -unix-addr-env")
@@ -185,7 +209,7 @@ func main() {
 		grpc.UnaryInterceptor(
 			csilvm.ChainUnaryServer(
 				csilvm.RequestLimitInterceptor(*requestLimitF),
-				csilvm.SerializingInterceptor(),
+				csilvm.VolumeLockingInterceptor(),
 				csilvm.LoggingInterceptor(),
 				csilvm.MetricsInterceptor(scope),
 			),
@@ -206,11 +230,26 @@ func main() {
 	for _, tag := range tagsF {
 		opts = append(opts, csilvm.Tag(tag))
 	}
+	for _, fs := range filesystemsF {
+		profile, err := csilvm.ParseFilesystemProfile(fs)
+		if err != nil {
+			logger.Fatalf("invalid -filesystem: %v", err)
+		}
+		opts = append(opts, csilvm.FilesystemProfileOpt(profile))
+	}
+	erasePolicy, err := csilvm.ParseErasePolicy(*erasePolicyF)
+	if err != nil {
+		logger.Fatalf("invalid -erase-policy: %v", err)
+	}
+	opts = append(opts, csilvm.ErasePolicyOpt(erasePolicy))
 	s := csilvm.NewServer(*vgnameF, strings.Split(*pvnamesF, ","), *defaultFsF, opts...)
 	if err := s.Setup(); err != nil {
 		logger.Fatalf("error initializing csilvm plugin: err=%v", err)
 	}
 	defer s.ReportUptime()()
+	if *trimIntervalF > 0 {
+		defer s.TrimPeriodically(*trimIntervalF)()
+	}
 	csi.RegisterIdentityServer(grpcServer, csilvm.IdentityServerValidator(s))
 	csi.RegisterControllerServer(grpcServer, csilvm.ControllerServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
 	csi.RegisterNodeServer(grpcServer, csilvm.NodeServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()))
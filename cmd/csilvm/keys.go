@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/Seagate/csiclvm/pkg/csilvm"
+)
+
+const rotateKeyUsage = `Usage: csilvm rotate-key -volume-group=NAME -volume=ID -key-file=FILE -new-key-file=FILE -generation=N
+
+Replaces the key of the encrypted volume with the contents of the new key file
+and records that the volume is on key generation N. The current key is read
+from the key file. Both files are used as is, including any trailing newline,
+like the encryptionPassphrase secret. N must be greater than the current key
+generation of the volume. The volume may be active on this node or inactive.
+It is locked against the plugin for the duration, so -lockfile must be the
+lock file of the plugin.
+
+`
+
+// rotateKeyCommand implements the `csilvm rotate-key` subcommand and returns
+// the exit status.
+func rotateKeyCommand(args []string) int {
+	fs := flag.NewFlagSet("rotate-key", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), rotateKeyUsage)
+		fs.PrintDefaults()
+	}
+	af := addAdminFlags(fs)
+	volumeF := fs.String("volume", "", "The ID of the encrypted volume")
+	keyF := fs.String("key-file", "", "The file holding the current key")
+	newKeyF := fs.String("new-key-file", "", "The file holding the new key")
+	generationF := fs.Uint64("generation", 0, "The key generation of the new key")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *volumeF == "" || *keyF == "" || *newKeyF == "" || *generationF == 0 || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := af.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	key, err := ioutil.ReadFile(*keyF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read key: %v\n", err)
+		return 1
+	}
+	newKey, err := ioutil.ReadFile(*newKeyF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read new key: %v\n", err)
+		return 1
+	}
+	err = csilvm.RotateVolumeKey(context.Background(), *af.vgname, *volumeF, string(key), string(newKey), *generationF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot rotate the key of volume %s: %v\n", *volumeF, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Volume %s is on key generation %d\n", *volumeF, *generationF)
+	return 0
+}
+
+const keysUsage = `Usage: csilvm keys -volume-group=NAME [-generation=N]
+
+Lists the encrypted volumes in the volume group along with the generation of
+their key. Volumes whose key was never rotated are on generation 0. With
+-generation only the volumes still on that generation are listed.
+
+`
+
+// keysCommand implements the `csilvm keys` subcommand and returns the exit
+// status.
+func keysCommand(args []string) int {
+	fs := flag.NewFlagSet("keys", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), keysUsage)
+		fs.PrintDefaults()
+	}
+	af := addAdminFlags(fs)
+	generationF := fs.Int64("generation", -1, "Only list volumes on this key generation")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := af.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	volumes, err := csilvm.ListEncryptedVolumes(context.Background(), *af.vgname)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot list encrypted volumes: %v\n", err)
+		return 1
+	}
+	if *generationF >= 0 {
+		var filtered []csilvm.EncryptedVolume
+		for _, v := range volumes {
+			if v.KeyGeneration == uint64(*generationF) {
+				filtered = append(filtered, v)
+			}
+		}
+		volumes = filtered
+	}
+	printEncryptedVolumes(os.Stdout, volumes)
+	return 0
+}
+
+func printEncryptedVolumes(w io.Writer, volumes []csilvm.EncryptedVolume) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOLUME ID\tKEY GENERATION")
+	for _, v := range volumes {
+		fmt.Fprintf(tw, "%s\t%d\n", v.ID, v.KeyGeneration)
+	}
+	tw.Flush()
+}
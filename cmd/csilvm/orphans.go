@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/csilvm"
+)
+
+const orphansUsage = `Usage: csilvm orphans -volume-group=NAME -known-volumes=FILE [-delete -yes]
+
+Lists the volumes in the volume group that were created by csilvm but whose
+IDs are not listed in FILE, one per line, along with their age, size and the
+persistent volume claim they were created for. Blank lines and lines starting
+with '#' are ignored. If FILE is '-' the IDs are read from stdin.
+
+With -delete and -yes the listed volumes are removed. With -delete alone the
+volumes that would be removed are listed only. Volumes are erased before they
+are removed according to their 'erase' parameter or, if they were created
+without one, -erase-policy, which should match that of the plugin.
+
+`
+
+// orphansCommand implements the `csilvm orphans` subcommand and returns the
+// exit status.
+func orphansCommand(args []string) int {
+	fs := flag.NewFlagSet("orphans", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), orphansUsage)
+		fs.PrintDefaults()
+	}
+	af := addAdminFlags(fs)
+	knownF := fs.String("known-volumes", "", "The file listing the volume IDs known to the CO, or '-' for stdin")
+	deleteF := fs.Bool("delete", false, "Remove the orphaned volumes (requires -yes)")
+	yesF := fs.Bool("yes", false, "Confirm that the orphaned volumes are to be removed")
+	erasePolicyF := fs.String("erase-policy", string(csilvm.EraseNone), "How volumes created without the 'erase' parameter are erased before they are removed (one of: none, discard, zero, zero-header)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *knownF == "" || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := af.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	erasePolicy, err := csilvm.ParseErasePolicy(*erasePolicyF)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	known, err := readKnownVolumes(*knownF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot read known volumes: %v\n", err)
+		return 1
+	}
+	ctx := context.Background()
+	orphans, err := csilvm.FindOrphanedVolumes(ctx, *af.vgname, known)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot find orphaned volumes: %v\n", err)
+		return 1
+	}
+	printOrphanedVolumes(os.Stdout, orphans, time.Now())
+	if !*deleteF || len(orphans) == 0 {
+		return 0
+	}
+	if !*yesF {
+		fmt.Fprintf(os.Stderr, "Not removing %d orphaned volumes without -yes\n", len(orphans))
+		return 1
+	}
+	status := 0
+	for _, v := range orphans {
+		if err := csilvm.RemoveOrphanedVolume(ctx, *af.vgname, v.ID, erasePolicy); err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot remove volume %s: %v\n", v.ID, err)
+			status = 1
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Removed volume %s\n", v.ID)
+	}
+	return status
+}
+
+// readKnownVolumes reads volume IDs, one per line, from the named file or
+// from stdin if name is "-".
+func readKnownVolumes(name string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var ids []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ids = append(ids, line)
+	}
+	return ids, s.Err()
+}
+
+func printOrphanedVolumes(w io.Writer, orphans []csilvm.OrphanedVolume, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOLUME ID\tAGE\tSIZE\tPVC")
+	for _, v := range orphans {
+		age := "unknown"
+		if !v.Created.IsZero() {
+			age = v.Age(now).Truncate(time.Second).String()
+		}
+		pvc := v.PVCName
+		if v.PVCNamespace != "" {
+			pvc = v.PVCNamespace + "/" + pvc
+		}
+		if pvc == "" {
+			pvc = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", v.ID, age, v.Size, pvc)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Seagate/csiclvm/pkg/csilvm"
+)
+
+const shrinkUsage = `Usage: csilvm shrink -volume-group=NAME -volume=ID -size=BYTES
+
+Shrinks the ext2/3/4 filesystem of the volume and then the volume itself to
+the given size, rounded up to a multiple of the extent size. The volume must
+not be published, i.e., it must be inactive. The filesystem is checked with
+'e2fsck -f' and shrunk with resize2fs before the volume is reduced with
+lvreduce. Any failure aborts the operation before data is lost. Volumes with
+an xfs filesystem cannot be shrunk. The volume is locked against the plugin
+for the duration, so -lockfile must be the lock file of the plugin.
+
+`
+
+// shrinkCommand implements the `csilvm shrink` subcommand and returns the
+// exit status.
+func shrinkCommand(args []string) int {
+	fs := flag.NewFlagSet("shrink", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), shrinkUsage)
+		fs.PrintDefaults()
+	}
+	af := addAdminFlags(fs)
+	volumeF := fs.String("volume", "", "The ID of the volume to shrink")
+	sizeF := fs.Uint64("size", 0, "The new size of the volume in bytes")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *volumeF == "" || *sizeF == 0 || fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := af.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	size, err := csilvm.ShrinkVolume(context.Background(), *af.vgname, *volumeF, *sizeF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot shrink volume %s: %v\n", *volumeF, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Shrunk volume %s to %d bytes\n", *volumeF, size)
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/Seagate/csiclvm/pkg/csilvm"
+)
+
+const volumesUsage = `Usage: csilvm volumes -volume-group=NAME [-tag=TAG ...]
+
+Lists the volumes in the volume group along with their size, tags and the
+persistent volume claim they were created for. If tags are given only the
+volumes that were created with all of them in the 'tags' parameter are listed.
+
+`
+
+// volumesCommand implements the `csilvm volumes` subcommand and returns the
+// exit status.
+func volumesCommand(args []string) int {
+	fs := flag.NewFlagSet("volumes", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), volumesUsage)
+		fs.PrintDefaults()
+	}
+	af := addAdminFlags(fs)
+	var tagsF stringsFlag
+	fs.Var(&tagsF, "tag", "Only list volumes with this tag (can be given multiple times)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if err := af.setup(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	volumes, err := csilvm.ListVolumesWithTags(context.Background(), *af.vgname, tagsF)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot list volumes: %v\n", err)
+		return 1
+	}
+	if err := printVolumes(os.Stdout, volumes); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot list volumes: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printVolumes(w io.Writer, volumes []*csi.Volume) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "VOLUME ID\tSIZE\tPVC\tTAGS")
+	for _, v := range volumes {
+		tags, err := csilvm.VolumeTags(v)
+		if err != nil {
+			return fmt.Errorf("volume %s: %v", v.GetVolumeId(), err)
+		}
+		attr := v.GetVolumeContext()
+		pvc := attr["csi.storage.k8s.io/pvc/name"]
+		if ns := attr["csi.storage.k8s.io/pvc/namespace"]; ns != "" {
+			pvc = ns + "/" + pvc
+		}
+		if pvc == "" {
+			pvc = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", v.GetVolumeId(), v.GetCapacityBytes(), pvc, strings.Join(tags, ","))
+	}
+	return tw.Flush()
+}
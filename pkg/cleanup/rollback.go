@@ -0,0 +1,100 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Rollback undoes the steps of an operation that failed part-way through.
+// Unlike Steps it does not panic when a cleanup function fails. Every
+// function is called, even if an earlier one failed, and all failures are
+// returned to the caller.
+//
+// Steps are named so that the progress of the rollback can be logged.
+type Rollback struct {
+	steps []step
+}
+
+type step struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// Add registers a function that undoes the step with the given name.
+func (r *Rollback) Add(name string, fn func(ctx context.Context) error) {
+	r.steps = append(r.steps, step{name, fn})
+}
+
+// Run calls the cleanup functions in LIFO order. Once ctx is done the
+// remaining functions are not called and fail with the context's error.
+// Run returns nil if every function succeeded and an *Error otherwise.
+//
+// The context of a failed operation is often done already, so callers
+// typically pass a separate context to Run.
+func (r *Rollback) Run(ctx context.Context) error {
+	var failures []*StepError
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		s := r.steps[i]
+		err := ctx.Err()
+		if err == nil {
+			log.Printf("Rolling back %s", s.name)
+			err = s.fn(ctx)
+		}
+		if err != nil {
+			log.Printf("Failed to roll back %s: %v", s.name, err)
+			failures = append(failures, &StepError{s.name, err})
+		}
+	}
+	r.steps = nil
+	if len(failures) > 0 {
+		return &Error{failures}
+	}
+	return nil
+}
+
+// StepError is the failure of a single rollback step.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+func (e *StepError) Error() string { return fmt.Sprintf("%s: %v", e.Step, e.Err) }
+func (e *StepError) Unwrap() error { return e.Err }
+
+// Error is returned by Rollback.Run if any step failed. The failures are
+// in the order the steps were rolled back.
+type Error struct {
+	Failures []*StepError
+}
+
+func (e *Error) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = f.Error()
+	}
+	return "rollback failed: " + strings.Join(msgs, "; ")
+}
+
+// Is reports whether the error of any failed step matches target, so that
+// errors.Is looks into every failure.
+func (e *Error) Is(target error) bool {
+	for _, f := range e.Failures {
+		if errors.Is(f, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failed step whose error matches target, so that
+// errors.As looks into every failure.
+func (e *Error) As(target interface{}) bool {
+	for _, f := range e.Failures {
+		if errors.As(f, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package cleanup
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRollbackLIFO(t *testing.T) {
+	var r Rollback
+	var order []string
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		r.Add(name, func(context.Context) error {
+			order = append(order, name)
+			return nil
+		})
+	}
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"c", "b", "a"}; !reflect.DeepEqual(order, exp) {
+		t.Fatalf("Expected steps to run in order %v but got %v", exp, order)
+	}
+	// The steps are only run once.
+	if err := r.Run(context.Background()); err != nil || len(order) != 3 {
+		t.Fatalf("Expected no more steps to run but got %v, %v", order, err)
+	}
+}
+
+func TestRollbackCollectsErrors(t *testing.T) {
+	errA, errC := errors.New("a failed"), errors.New("c failed")
+	var r Rollback
+	ran := false
+	r.Add("a", func(context.Context) error { return errA })
+	r.Add("b", func(context.Context) error { ran = true; return nil })
+	r.Add("c", func(context.Context) error { return errC })
+	err := r.Run(context.Background())
+	if !ran {
+		t.Fatal("Expected all steps to run despite failures")
+	}
+	var rerr *Error
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected *Error but got %T", err)
+	}
+	if len(rerr.Failures) != 2 || rerr.Failures[0].Step != "c" || rerr.Failures[1].Step != "a" {
+		t.Fatalf("Unexpected failures %v", rerr.Failures)
+	}
+	if !errors.Is(err, errA) || !errors.Is(err, errC) {
+		t.Fatalf("Expected %v to wrap the step errors", err)
+	}
+	var serr *StepError
+	if !errors.As(err, &serr) || serr.Step != "c" {
+		t.Fatalf("Expected the first failed step but got %v", serr)
+	}
+	if errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected %v not to wrap other errors", err)
+	}
+	if exp := "rollback failed: c: c failed; a: a failed"; err.Error() != exp {
+		t.Fatalf("Expected %q but got %q", exp, err.Error())
+	}
+}
+
+func TestRollbackContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var r Rollback
+	ran := false
+	r.Add("a", func(context.Context) error { ran = true; return nil })
+	r.Add("b", func(context.Context) error { cancel(); return nil })
+	err := r.Run(ctx)
+	if ran {
+		t.Fatal("Expected no steps to run once the context is done")
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled but got %v", err)
+	}
+}
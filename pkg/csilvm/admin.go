@@ -0,0 +1,53 @@
+package csilvm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// ListVolumesWithTags is like ListVolumes but only returns the volumes in
+// the volume group that were created with all of the given tags in the
+// 'tags' parameter. The CSI ListVolumes RPC cannot filter volumes so this
+// is meant for administrative tools.
+func ListVolumesWithTags(ctx context.Context, vgname string, tags []string) ([]*csi.Volume, error) {
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return nil, err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lvtags := make([]string, len(tags))
+	for i, tag := range tags {
+		lvtags[i] = userTagToTag(tag)
+	}
+	return listVolumes(report, vgname, lvtags)
+}
+
+// VolumeTags returns the tags in the volume context of v, as returned by
+// CreateVolume or ListVolumes. Tags that were encoded as they are not safe
+// for LVM are decoded.
+func VolumeTags(v *csi.Volume) ([]string, error) {
+	attr, ok := v.GetVolumeContext()[attrTags]
+	if !ok {
+		return nil, nil
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(attr)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	if err := json.Unmarshal(buf, &tags); err != nil {
+		return nil, err
+	}
+	for i, tag := range tags {
+		tags[i] = tagToUserTag(tag)
+	}
+	return tags, nil
+}
@@ -0,0 +1,97 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The BLKROSET and BLKROGET ioctls, _IO(0x12, 93) and _IO(0x12, 94), see
+// linux/fs.h.
+const (
+	blkroset = 0x125d
+	blkroget = 0x125e
+)
+
+// setDeviceReadonly sets or clears the read-only flag of the block device.
+// Unlike a read-only bind mount of the device node, the flag prevents
+// writes to the device.
+func setDeviceReadonly(devicePath string, readonly bool) error {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var flag int32
+	if readonly {
+		flag = 1
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkroset, uintptr(unsafe.Pointer(&flag)))
+	if errno != 0 {
+		return fmt.Errorf("BLKROSET failed: %v", errno)
+	}
+	return nil
+}
+
+// isDeviceReadonly returns true if the read-only flag of the block device
+// is set.
+func isDeviceReadonly(devicePath string) (bool, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	var flag int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), blkroget, uintptr(unsafe.Pointer(&flag)))
+	if errno != 0 {
+		return false, fmt.Errorf("BLKROGET failed: %v", errno)
+	}
+	return flag != 0, nil
+}
+
+// blockPublications returns the bind mounts of the device node, which is
+// the resolved path of the device, e.g., /dev/dm-4.
+func blockPublications(mounts []mountpoint, devicePath string) []mountpoint {
+	var mps []mountpoint
+	for _, mp := range mounts {
+		// The root of a bind mount of a device node is the path
+		// of the node in the filesystem that holds it.
+		if mp.root != "/" && "/dev"+mp.root == devicePath {
+			mps = append(mps, mp)
+		}
+	}
+	return mps
+}
+
+// checkBlockPublications returns an error if the device is published
+// elsewhere with a different readonly status. The read-only flag applies to
+// the device, not to a publication, so a device cannot be published both
+// read-only and read-write.
+func checkBlockPublications(mounts []mountpoint, devicePath string, readonly bool) error {
+	for _, mp := range blockPublications(mounts, devicePath) {
+		if mp.isReadonly() != readonly {
+			return fmt.Errorf("the volume is published at %v with readonly=%v", mp.path, mp.isReadonly())
+		}
+	}
+	return nil
+}
+
+// restoreDeviceReadWrite clears the read-only flag of the device if it is
+// set and the device is no longer published read-only.
+func restoreDeviceReadWrite(devicePath string) error {
+	readonly, err := isDeviceReadonly(devicePath)
+	if err != nil || !readonly {
+		return err
+	}
+	mounts, err := listMounts()
+	if err != nil {
+		return err
+	}
+	if len(blockPublications(mounts, devicePath)) > 0 {
+		log.Printf("Device %v is still published read-only", devicePath)
+		return nil
+	}
+	log.Printf("Clearing the read-only flag of %v", devicePath)
+	return setDeviceReadonly(devicePath, false)
+}
@@ -0,0 +1,26 @@
+package csilvm
+
+import "testing"
+
+func TestCheckBlockPublications(t *testing.T) {
+	mounts := []mountpoint{
+		{root: "/", path: "/dev", mountsource: "devtmpfs"},
+		{root: "/dm-4", path: "/pods/a", mountsource: "devtmpfs", mountopts: []string{"ro"}},
+		{root: "/dm-5", path: "/pods/b", mountsource: "devtmpfs", mountopts: []string{"rw"}},
+	}
+	if n := len(blockPublications(mounts, "/dev/dm-4")); n != 1 {
+		t.Fatalf("Expected a single publication but got %d", n)
+	}
+	if err := checkBlockPublications(mounts, "/dev/dm-4", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBlockPublications(mounts, "/dev/dm-4", false); err == nil {
+		t.Fatal("Expected an error for a read-write publication of a read-only device")
+	}
+	if err := checkBlockPublications(mounts, "/dev/dm-5", true); err == nil {
+		t.Fatal("Expected an error for a read-only publication of a read-write device")
+	}
+	if err := checkBlockPublications(mounts, "/dev/dm-6", true); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -38,8 +38,8 @@ func check(fn func() error) {
 	}
 }
 
-func try(fn func() error) {
-	if err := fn(); err != nil {
+func try(fn func(context.Context) error) {
+	if err := fn(context.Background()); err != nil {
 		log.Printf("try: err=%v", err)
 	}
 }
@@ -52,7 +52,7 @@ func init() {
 	stdlog.SetFlags(stdlog.LstdFlags | stdlog.Lshortfile)
 	// Refresh the LVM metadata held by the lvmetad process to
 	// clear any metadata left over from a previous run.
-	if err := lvm.PVScan(""); err != nil {
+	if err := lvm.PVScan(context.Background(), ""); err != nil {
 		panic(err)
 	}
 }
@@ -224,7 +224,7 @@ func checkVolumeContextIncludeVolumeTag(t *testing.T, info *csi.Volume, name str
 }
 
 func TestCreateVolume_WithTag(t *testing.T) {
-	expected := []string{"some-tag", tagVolumeNamePlainPrefix + "test-volume"}
+	expected := []string{"some-tag", tagVolumeNamePlainPrefix + "test-volume", tagCreated}
 	vgname := testvgname()
 	pvname, pvclean := testpv()
 	defer check(pvclean)
@@ -240,18 +240,18 @@ func TestCreateVolume_WithTag(t *testing.T) {
 		t.Fatalf("Expected required_bytes (%v) to match volume size (%v).", req.GetCapacityRange().GetRequiredBytes(), info.GetCapacityBytes())
 	}
 	checkVolumeContextIncludeVolumeTag(t, info, req.GetName())
-	vgnames, err := lvm.ListVolumeGroupNames()
+	vgnames, err := lvm.ListVolumeGroupNames(context.Background())
 	if err != nil {
 		panic(err)
 	}
 	sort.Strings(expected)
 	found := false
 	for _, vgname := range vgnames {
-		vg, err := lvm.LookupVolumeGroup(vgname)
+		vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 		if err != nil {
 			panic(err)
 		}
-		lv, err := vg.LookupLogicalVolume(info.GetVolumeId())
+		lv, err := vg.LookupLogicalVolume(context.Background(), info.GetVolumeId())
 		if err == lvm.ErrLogicalVolumeNotFound {
 			continue
 		}
@@ -259,10 +259,11 @@ func TestCreateVolume_WithTag(t *testing.T) {
 			t.Fatal(err)
 		}
 		found = true
-		tags, err := lv.Tags()
+		tags, err := lv.Tags(context.Background())
 		if err != nil {
 			t.Fatal(err)
 		}
+		tags = stripCreationTime(tags)
 		sort.Strings(tags)
 		if !reflect.DeepEqual(tags, expected) {
 			t.Fatalf("Expected tags not found %v != %v", expected, tags)
@@ -392,15 +393,15 @@ func TestCreateVolume_AlreadyExists_VolumeCapabilities(t *testing.T) {
 	}
 
 	// Format the newly created volume with xfs.
-	vg, err := lvm.LookupVolumeGroup(vgname)
+	vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	lv, err := vg.LookupLogicalVolume(resp1.GetVolume().GetVolumeId())
+	lv, err := vg.LookupLogicalVolume(context.Background(), resp1.GetVolume().GetVolumeId())
 	if err != nil {
 		t.Fatal(err)
 	}
-	lvpath, err := lv.Path()
+	lvpath, err := lv.Path(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -449,15 +450,15 @@ func TestCreateVolume_Idempotent_UnspecifiedExistingFsType(t *testing.T) {
 	}
 
 	// Format the newly created volume with xfs.
-	vg, err := lvm.LookupVolumeGroup(vgname)
+	vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	lv, err := vg.LookupLogicalVolume(resp1.GetVolume().GetVolumeId())
+	lv, err := vg.LookupLogicalVolume(context.Background(), resp1.GetVolume().GetVolumeId())
 	if err != nil {
 		t.Fatal(err)
 	}
-	lvpath, err := lv.Path()
+	lvpath, err := lv.Path(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -692,15 +693,15 @@ func TestDeleteVolumeAfterDeviceDisappears(t *testing.T) {
 	}
 	volumeId := createResp.GetVolume().GetVolumeId()
 	// Remove the device node.
-	vg, err := lvm.LookupVolumeGroup(vgname)
+	vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 	if err != nil {
 		panic(err)
 	}
-	lv, err := vg.LookupLogicalVolume(volumeId)
+	lv, err := vg.LookupLogicalVolume(context.Background(), volumeId)
 	if err != nil {
 		t.Fatal(err)
 	}
-	path, err := lv.Path()
+	path, err := lv.Path(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2255,19 +2256,19 @@ func TestProbe_MissingPhysicalVolumes(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2299,19 +2300,19 @@ func TestProbe_RemovedPhysicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2375,11 +2376,11 @@ func TestSetup_NewVolumeGroup_NewPhysicalVolumes_WithTag(t *testing.T) {
 	if err := server.Setup(); err != nil {
 		t.Fatal(err)
 	}
-	vg, err := lvm.LookupVolumeGroup(vgname)
+	vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 	if err != nil {
 		t.Fatal(err)
 	}
-	tags, err := vg.Tags()
+	tags, err := vg.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2525,7 +2526,7 @@ func TestSetup_NewVolumeGroup_NewPhysicalVolumes_RemoveVolumeGroup(t *testing.T)
 	if err := server.Setup(); err != nil {
 		t.Fatal(err)
 	}
-	vgs, err := lvm.ListVolumeGroupNames()
+	vgs, err := lvm.ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2548,19 +2549,19 @@ func TestSetup_ExistingVolumeGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2586,19 +2587,19 @@ func TestSetup_ExistingVolumeGroup_MissingPhysicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2626,19 +2627,19 @@ func TestSetup_ExistingVolumeGroup_UnexpectedExtraPhysicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2664,19 +2665,19 @@ func TestSetup_ExistingVolumeGroup_RemoveVolumeGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2684,7 +2685,7 @@ func TestSetup_ExistingVolumeGroup_RemoveVolumeGroup(t *testing.T) {
 	pvnames := []string{loop1.Path(), loop2.Path()}
 	_, server, clean := prepareSetupTest(vgname, pvnames, RemoveVolumeGroup())
 	defer clean()
-	vgnamesBefore, err := lvm.ListVolumeGroupNames()
+	vgnamesBefore, err := lvm.ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2698,7 +2699,7 @@ func TestSetup_ExistingVolumeGroup_RemoveVolumeGroup(t *testing.T) {
 	if err := server.Setup(); err != nil {
 		t.Fatal(err)
 	}
-	vgnamesAfter, err := lvm.ListVolumeGroupNames()
+	vgnamesAfter, err := lvm.ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2719,19 +2720,19 @@ func TestSetup_ExistingVolumeGroup_UnexpectedExtraPhysicalVolume_RemoveVolumeGro
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, nil)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -2757,12 +2758,12 @@ func TestSetup_ExistingVolumeGroup_WithTag(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2770,7 +2771,7 @@ func TestSetup_ExistingVolumeGroup_WithTag(t *testing.T) {
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
 	tags := []string{"blue", "foo"}
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, tags)
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, tags)
 	if err != nil {
 		panic(err)
 	}
@@ -2795,12 +2796,12 @@ func TestSetup_ExistingVolumeGroup_UnexpectedTag(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2808,7 +2809,7 @@ func TestSetup_ExistingVolumeGroup_UnexpectedTag(t *testing.T) {
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
 	tag := "blue"
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, []string{tag})
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, []string{tag})
 	if err != nil {
 		panic(err)
 	}
@@ -2836,19 +2837,19 @@ func TestSetup_ExistingVolumeGroup_MissingTag(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop2.Close()
-	pv1, err := lvm.CreatePhysicalVolume(loop1.Path())
+	pv1, err := lvm.CreatePhysicalVolume(context.Background(), loop1.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv1.Remove)
-	pv2, err := lvm.CreatePhysicalVolume(loop2.Path())
+	pv2, err := lvm.CreatePhysicalVolume(context.Background(), loop2.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer try(pv2.Remove)
 	pvs := []*lvm.PhysicalVolume{pv1, pv2}
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := lvm.CreateVolumeGroup(vgname, pvs, []string{"some-other-tag"})
+	vg, err := lvm.CreateVolumeGroup(context.Background(), vgname, pvs, []string{"some-other-tag"})
 	if err != nil {
 		panic(err)
 	}
@@ -2877,10 +2878,14 @@ func TestNodeGetCapabilities(t *testing.T) {
 	client, clean := startTest(vgname, []string{pvname})
 	defer clean()
 	req := testNodeGetCapabilitiesRequest()
-	_, err := client.NodeGetCapabilities(context.Background(), req)
+	resp, err := client.NodeGetCapabilities(context.Background(), req)
 	if err != nil {
 		t.Fatal(err)
 	}
+	caps := resp.GetCapabilities()
+	if len(caps) != 1 || caps[0].GetRpc().GetType() != csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP {
+		t.Fatalf("Expected the VOLUME_MOUNT_GROUP capability but got %v", caps)
+	}
 }
 
 func TestNodeGetCapabilitiesRemoveVolumeGroup(t *testing.T) {
@@ -2911,21 +2916,21 @@ func prepareSetupTest(vgname string, pvnames []string, serverOpts ...ServerOpt)
 	clean.Add(lis.Close)
 	clean.Add(func() error {
 		for _, pvname := range pvnames {
-			pv, err := lvm.LookupPhysicalVolume(pvname)
+			pv, err := lvm.LookupPhysicalVolume(context.Background(), pvname)
 			if err != nil {
 				if err == lvm.ErrPhysicalVolumeNotFound {
 					continue
 				}
 				panic(err)
 			}
-			if err := pv.Remove(); err != nil {
+			if err := pv.Remove(context.Background()); err != nil {
 				panic(err)
 			}
 		}
 		return nil
 	})
 	clean.Add(func() error {
-		vg, err := lvm.LookupVolumeGroup(vgname)
+		vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 		if err == lvm.ErrVolumeGroupNotFound {
 			// Already removed this volume group in the test.
 			return nil
@@ -2933,10 +2938,10 @@ func prepareSetupTest(vgname string, pvnames []string, serverOpts ...ServerOpt)
 		if err != nil {
 			panic(err)
 		}
-		return vg.Remove()
+		return vg.Remove(context.Background())
 	})
 	clean.Add(func() error {
-		vg, err := lvm.LookupVolumeGroup(vgname)
+		vg, err := lvm.LookupVolumeGroup(context.Background(), vgname)
 		if err == lvm.ErrVolumeGroupNotFound {
 			// Already removed this volume group in the test.
 			return nil
@@ -2944,16 +2949,16 @@ func prepareSetupTest(vgname string, pvnames []string, serverOpts ...ServerOpt)
 		if err != nil {
 			panic(err)
 		}
-		lvnames, err := vg.ListLogicalVolumeNames()
+		lvnames, err := vg.ListLogicalVolumeNames(context.Background())
 		if err != nil {
 			panic(err)
 		}
 		for _, lvname := range lvnames {
-			lv, err := vg.LookupLogicalVolume(lvname)
+			lv, err := vg.LookupLogicalVolume(context.Background(), lvname)
 			if err != nil {
 				panic(err)
 			}
-			if err := lv.Remove(); err != nil {
+			if err := lv.Remove(context.Background()); err != nil {
 				panic(err)
 			}
 		}
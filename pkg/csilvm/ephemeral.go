@@ -0,0 +1,304 @@
+package csilvm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// volumeContextEphemeral is set to "true" in the volume context of
+	// NodePublishVolume by Kubernetes for a CSI ephemeral inline volume,
+	// which is not created with CreateVolume but described by the
+	// remaining volume context.
+	volumeContextEphemeral = paramMetadataPrefix + "ephemeral"
+	// paramSize is the size of an ephemeral volume in bytes, optionally
+	// followed by one of the suffixes Ki, Mi, Gi or Ti.
+	paramSize = "size"
+	// tagEphemeralPrefix marks a volume created by NodePublishVolume. It
+	// is followed by the encoded name of the node that created it, see
+	// ephemeralTag.
+	tagEphemeralPrefix = tagStatePrefix + "ephemeral."
+	// ephemeralNamePrefix is the prefix of the names of ephemeral
+	// volumes, see ephemeralVolumeName.
+	ephemeralNamePrefix = lvPrefix + "e"
+)
+
+// isEphemeralRequest returns true if the volume context of NodePublishVolume
+// describes an ephemeral inline volume.
+func isEphemeralRequest(volumeContext map[string]string) bool {
+	ephemeral, _ := strconv.ParseBool(volumeContext[volumeContextEphemeral])
+	return ephemeral
+}
+
+// ephemeralVolumeName returns the name of the logical volume backing the
+// ephemeral volume with the given ID. The ID is chosen by the CO and need
+// not be a valid logical volume name, so it is hashed.
+func ephemeralVolumeName(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return ephemeralNamePrefix + hex.EncodeToString(sum[:16])
+}
+
+// ephemeralTag returns the tag that marks the ephemeral volumes created by
+// this node.
+func (s *Server) ephemeralTag() string {
+	owner := s.nodeID
+	if owner == "" {
+		owner, _ = os.Hostname()
+	}
+	return tagEphemeralPrefix + base64.RawURLEncoding.EncodeToString([]byte(owner))
+}
+
+// isEphemeral returns true if lv was created by NodePublishVolume.
+func isEphemeral(lv lvm.LogicalVolumeReport) bool {
+	for _, tag := range lv.Tags() {
+		if strings.HasPrefix(tag, tagEphemeralPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVolumeSize parses a size in bytes, optionally followed by one of the
+// binary suffixes used by Kubernetes quantities.
+func parseVolumeSize(value string) (uint64, error) {
+	shift := uint(0)
+	for suffix, s := range map[string]uint{"Ki": 10, "Mi": 20, "Gi": 30, "Ti": 40} {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSuffix(value, suffix)
+			shift = s
+			break
+		}
+	}
+	n, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || n == 0 || n > math.MaxUint64>>shift {
+		return 0, fmt.Errorf("The '%s' parameter must be a positive number of bytes, optionally followed by Ki, Mi, Gi or Ti", paramSize)
+	}
+	return n << shift, nil
+}
+
+// takeSizeFromParameters removes the 'size' parameter from the input and
+// returns the size it specifies, or def.
+func takeSizeFromParameters(params map[string]string, def uint64) (uint64, error) {
+	value, ok := params[paramSize]
+	if !ok {
+		return def, nil
+	}
+	delete(params, paramSize)
+	return parseVolumeSize(value)
+}
+
+// ephemeralVolumeFromContext returns the size, tags and options with which
+// to create the ephemeral volume described by the volume context. It
+// accepts the parameters of CreateVolume that apply to a volume that lives
+// only as long as the pod using it, plus 'size'.
+func (s *Server) ephemeralVolumeFromContext(volumeContext map[string]string, capability *csi.VolumeCapability) (uint64, []string, []lvm.CreateLogicalVolumeOpt, error) {
+	tags := make([]string, len(s.tags), len(s.tags)+2)
+	copy(tags, s.tags)
+	tags = append(tags, s.ephemeralTag(), creationTimeTag(time.Now()))
+	params := dupParams(volumeContext)
+	// Kubernetes describes the pod in the remaining csi.storage.k8s.io/
+	// keys, none of which is recorded.
+	takeMetadataTagsFromParameters(params)
+	size, err := takeSizeFromParameters(params, s.defaultVolumeSize)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	userTags, err := takeUserTagsFromParameters(params)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	tags = append(tags, userTags...)
+	profileTag, err := s.takeFsProfileTagFromParameters(params, []*csi.VolumeCapability{capability})
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if profileTag != "" {
+		tags = append(tags, profileTag)
+	}
+	discardTag, err := takeDiscardTagFromParameters(params)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if discardTag != "" {
+		tags = append(tags, discardTag)
+	}
+	eraseTag, err := takeEraseTagFromParameters(params)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if eraseTag != "" {
+		tags = append(tags, eraseTag)
+	}
+	opts, err := volumeOptsFromParameters(params)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return size, tags, opts, nil
+}
+
+// createEphemeralVolume creates the volume described by the volume context
+// of a NodePublishVolume request for an ephemeral volume. If the volume
+// already exists, e.g., as the request is retried, it is returned instead
+// and isNew is false.
+func (s *Server) createEphemeralVolume(ctx context.Context, request *csi.NodePublishVolumeRequest) (_ lvm.LogicalVolumeReport, isNew bool, _ error) {
+	name := ephemeralVolumeName(request.GetVolumeId())
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		return lvm.LogicalVolumeReport{}, false, status.Errorf(errorCode(err), "Cannot report on volume group: err=%v", err)
+	}
+	if existing, err := report.LogicalVolume(s.vgname, name); err == nil {
+		if !existing.HasTag(s.ephemeralTag()) {
+			return lvm.LogicalVolumeReport{}, false, status.Errorf(
+				codes.FailedPrecondition,
+				"Volume %s is not an ephemeral volume of this node",
+				name)
+		}
+		log.Printf("Ephemeral volume %s already exists", name)
+		return existing, false, nil
+	}
+	size, tags, lvopts, err := s.ephemeralVolumeFromContext(request.GetVolumeContext(), request.GetVolumeCapability())
+	if err != nil {
+		return lvm.LogicalVolumeReport{}, false, status.Errorf(codes.InvalidArgument, "Invalid volume context: %v", err)
+	}
+	vg, err := report.VolumeGroup(s.vgname)
+	if err != nil {
+		return lvm.LogicalVolumeReport{}, false, status.Errorf(errorCode(err), "Error in ExtentSize: err=%v", err)
+	}
+	if size%vg.ExtentSize != 0 {
+		size = (size/vg.ExtentSize + 1) * vg.ExtentSize
+	}
+	log.Printf("Creating ephemeral volume id=%v, size=%v, tags=%v", name, size, tags)
+	// The volume is allocated from the free space like in CreateVolume,
+	// so the volume group is locked exclusively while it is created.
+	var created *lvm.LogicalVolume
+	err = withVolumeGroupLock(ctx, vgExclusive, func() (err error) {
+		created, err = s.volumeGroup.CreateLogicalVolume(ctx, name, size, tags, lvopts...)
+		return err
+	})
+	if err != nil {
+		switch err {
+		case lvm.ErrNoSpace:
+			return lvm.LogicalVolumeReport{}, false, ErrInsufficientCapacity
+		case lvm.ErrTooFewDisks:
+			return lvm.LogicalVolumeReport{}, false, ErrTooFewDisks
+		}
+		return lvm.LogicalVolumeReport{}, false, status.Errorf(
+			errorCode(err),
+			"Error in CreateLogicalVolume: err=%v",
+			err)
+	}
+	defer s.reportStorageMetrics(ctx)
+	lv, err := s.lookupVolume(ctx, name)
+	if err != nil {
+		// Should removing the volume fail, it is removed by the
+		// sweep when the plugin next starts.
+		if err := created.Remove(ctx); err != nil {
+			log.Printf("Cannot remove ephemeral volume %s: err=%v", name, err)
+		}
+		return lvm.LogicalVolumeReport{}, false, status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
+	}
+	return lv, true, nil
+}
+
+// lookupPublishedVolume returns the volume with the given ID or, failing
+// that, the ephemeral volume published with it.
+func (s *Server) lookupPublishedVolume(ctx context.Context, id string) (lvm.LogicalVolumeReport, error) {
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		return lvm.LogicalVolumeReport{}, err
+	}
+	lv, err := report.LogicalVolume(s.vgname, id)
+	if err != nil && !lookupFailed(err) {
+		if ephemeral, err := report.LogicalVolume(s.vgname, ephemeralVolumeName(id)); err == nil && isEphemeral(ephemeral) {
+			return ephemeral, nil
+		}
+	}
+	return lv, err
+}
+
+// removeEphemeralVolume erases the inactive ephemeral volume according to
+// its erase policy and removes it.
+func (s *Server) removeEphemeralVolume(ctx context.Context, lvr lvm.LogicalVolumeReport) error {
+	lv := s.volumeGroup.LogicalVolume(lvr)
+	if policy := volumeErasePolicy(lvr, s.erasePolicy); policy != EraseNone {
+		// The volume was deactivated since lvr was reported.
+		current, err := s.lookupVolume(ctx, lvr.Name)
+		if err != nil {
+			return status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
+		}
+		if err := eraseVolume(ctx, lv, current, policy); err != nil {
+			return err
+		}
+	}
+	log.Printf("Removing ephemeral volume %v", lvr.Name)
+	if err := withVolumeGroupLock(ctx, vgShared, func() error { return lv.Remove(ctx) }); err != nil {
+		return status.Errorf(
+			errorCode(err),
+			"Failed to remove ephemeral volume: err=%v",
+			err)
+	}
+	s.reportStorageMetrics(ctx)
+	return nil
+}
+
+// unusedEphemeralVolumes returns the ephemeral volumes created by this node
+// that are not mounted.
+func (s *Server) unusedEphemeralVolumes(report *lvm.Report, mounts []mountpoint) []lvm.LogicalVolumeReport {
+	mounted := make(map[string]bool)
+	for _, mp := range mounts {
+		if id, ok := s.volumeIDFromDevice(mp.mountsource); ok {
+			mounted[id] = true
+		}
+	}
+	tag := s.ephemeralTag()
+	var unused []lvm.LogicalVolumeReport
+	for _, lv := range report.LogicalVolumesIn(s.vgname) {
+		if lv.HasTag(tag) && !mounted[lv.Name] {
+			unused = append(unused, lv)
+		}
+	}
+	return unused
+}
+
+// removeEphemeralVolumes removes the ephemeral volumes this node created
+// but did not remove, e.g., as it crashed before the pods using them were
+// deleted. Volumes that are still mounted are left to NodeUnpublishVolume.
+// Failures are logged only as they are retried the next time the plugin
+// starts.
+func (s *Server) removeEphemeralVolumes(ctx context.Context) {
+	log.Printf("Looking for unused ephemeral volumes tagged %s", s.ephemeralTag())
+	mounts, err := listMounts()
+	if err != nil {
+		log.Printf("Cannot list mounts: err=%v", err)
+		return
+	}
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		log.Printf("Cannot report on volume group: err=%v", err)
+		return
+	}
+	for _, lv := range s.unusedEphemeralVolumes(report, mounts) {
+		if lv.Active() {
+			if err := s.volumeGroup.LogicalVolume(lv).Deactivate(ctx); err != nil {
+				log.Printf("Cannot deactivate ephemeral volume %s: err=%v", lv.Name, err)
+				continue
+			}
+		}
+		if err := s.removeEphemeralVolume(ctx, lv); err != nil {
+			log.Printf("Cannot remove ephemeral volume %s: err=%v", lv.Name, err)
+		}
+	}
+}
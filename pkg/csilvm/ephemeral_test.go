@@ -0,0 +1,65 @@
+package csilvm
+
+import (
+	"strings"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestParseVolumeSize(t *testing.T) {
+	for value, exp := range map[string]uint64{
+		"1048576": 1 << 20,
+		"512Ki":   512 << 10,
+		"10Mi":    10 << 20,
+		"1Gi":     1 << 30,
+		"2Ti":     2 << 40,
+	} {
+		size, err := parseVolumeSize(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != exp {
+			t.Fatalf("Expected %d for %q but got %d", exp, value, size)
+		}
+	}
+	for _, value := range []string{"", "0", "-1", "1G", "1.5Gi", "Gi", "16777216Ti"} {
+		if _, err := parseVolumeSize(value); err == nil {
+			t.Fatalf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestEphemeralVolumeName(t *testing.T) {
+	id := "csi-5a4d8e5b2fa0f6d8a3a3b7c8c0f4bd1e7f6d1c0b9e9a8b7c6d5e4f3a2b1c0d9e"
+	name := ephemeralVolumeName(id)
+	if name != ephemeralVolumeName(id) {
+		t.Fatal("Expected the name to be stable")
+	}
+	if name == ephemeralVolumeName(id+"x") {
+		t.Fatal("Expected different IDs to have different names")
+	}
+	// The name must be recognised by volumeIDFromDevice.
+	if !strings.HasPrefix(name, lvPrefix) || strings.Contains(name, "-") {
+		t.Fatalf("Unexpected name %q", name)
+	}
+}
+
+func TestValidateNodePublishVolumeRequest_EphemeralBlock(t *testing.T) {
+	request := &csi.NodePublishVolumeRequest{
+		VolumeId:      "csi-ephemeral",
+		TargetPath:    "/target",
+		VolumeContext: map[string]string{volumeContextEphemeral: "true"},
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Block{
+				Block: &csi.VolumeCapability_BlockVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	if err := validateNodePublishVolumeRequest(request, false, nil); err != ErrEphemeralBlock {
+		t.Fatalf("Expected ErrEphemeralBlock but got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package csilvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErasePolicy determines how the data of a volume is erased before it is
+// removed by DeleteVolume.
+type ErasePolicy string
+
+const (
+	// EraseNone leaves the data on the physical volumes.
+	EraseNone ErasePolicy = "none"
+	// EraseDiscard discards the blocks of the volume with BLKDISCARD.
+	// Whether the data can still be read afterwards depends on the
+	// device.
+	EraseDiscard ErasePolicy = "discard"
+	// EraseZero overwrites the whole volume with zeros.
+	EraseZero ErasePolicy = "zero"
+	// EraseZeroHeader overwrites the first eraseHeaderSize bytes of the
+	// volume with zeros. This removes filesystem signatures and, for
+	// encrypted volumes, the LUKS header with all keyslots.
+	EraseZeroHeader ErasePolicy = "zero-header"
+)
+
+// eraseHeaderSize is the default size of a LUKS2 header including its
+// keyslots area.
+const eraseHeaderSize = 16 << 20
+
+const (
+	// paramErase selects the erase policy of a volume.
+	paramErase = "erase"
+	// tagErasePrefix is followed by the erase policy of the volume.
+	tagErasePrefix = tagStatePrefix + "erase."
+	// tagErasedPrefix is followed by the erase policy and the number of
+	// bytes already zeroed by an erase that was interrupted, e.g.,
+	// "csilvm.erased.zero.1073741824", so that a retry resumes it.
+	tagErasedPrefix = tagStatePrefix + "erased."
+)
+
+// ParseErasePolicy returns the erase policy with the given name.
+func ParseErasePolicy(s string) (ErasePolicy, error) {
+	switch p := ErasePolicy(s); p {
+	case EraseNone, EraseDiscard, EraseZero, EraseZeroHeader:
+		return p, nil
+	}
+	return "", fmt.Errorf("csilvm: unknown erase policy %q, expected one of %v, %v, %v or %v", s, EraseNone, EraseDiscard, EraseZero, EraseZeroHeader)
+}
+
+// takeEraseTagFromParameters removes the 'erase' parameter from the input
+// and returns the tag that records the erase policy, or the empty string if
+// the parameter is not present.
+func takeEraseTagFromParameters(params map[string]string) (string, error) {
+	value, ok := params[paramErase]
+	if !ok {
+		return "", nil
+	}
+	delete(params, paramErase)
+	policy, err := ParseErasePolicy(value)
+	if err != nil {
+		return "", fmt.Errorf("The 'erase' parameter must be one of %v, %v, %v or %v", EraseNone, EraseDiscard, EraseZero, EraseZeroHeader)
+	}
+	return tagErasePrefix + string(policy), nil
+}
+
+// volumeErasePolicy returns the erase policy recorded on the volume, or def
+// if there is none.
+func volumeErasePolicy(lv lvm.LogicalVolumeReport, def ErasePolicy) ErasePolicy {
+	for _, tag := range lv.Tags() {
+		if !strings.HasPrefix(tag, tagErasePrefix) {
+			continue
+		}
+		if policy, err := ParseErasePolicy(strings.TrimPrefix(tag, tagErasePrefix)); err == nil {
+			return policy
+		}
+	}
+	return def
+}
+
+// eraseProgress returns the number of bytes of the volume already zeroed
+// with the policy by an interrupted erase and the tag that records it, if
+// any.
+func eraseProgress(lv lvm.LogicalVolumeReport, policy ErasePolicy) (offset int64, tag string) {
+	prefix := tagErasedPrefix + string(policy) + "."
+	for _, t := range lv.Tags() {
+		if !strings.HasPrefix(t, prefix) {
+			continue
+		}
+		if n, err := strconv.ParseInt(strings.TrimPrefix(t, prefix), 10, 64); err == nil && n >= 0 {
+			return n, t
+		}
+	}
+	return 0, ""
+}
+
+// eraseProgressTag returns the tag that records that the first offset bytes
+// of the volume were zeroed with the policy.
+func eraseProgressTag(policy ErasePolicy, offset int64) string {
+	return tagErasedPrefix + string(policy) + "." + strconv.FormatInt(offset, 10)
+}
+
+// eraseDevice erases the data on the device according to the policy,
+// zeroing from offset on. It returns the number of bytes zeroed so far,
+// which is less than the size of the device if zeroing stopped early as the
+// context is done. The device is opened exclusively so that erasing fails
+// with EBUSY if it is in use, e.g., mounted.
+func eraseDevice(ctx context.Context, devicePath string, policy ErasePolicy, offset int64) (int64, error) {
+	if policy == EraseNone {
+		return 0, nil
+	}
+	file, err := os.OpenFile(devicePath, os.O_WRONLY|syscall.O_EXCL, 0)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return offset, err
+	}
+	switch policy {
+	case EraseDiscard:
+		log.Printf("Discarding %d bytes on %v", size, devicePath)
+		return 0, discard(file, size)
+	case EraseZero:
+		return zero(ctx, file, offset, size)
+	case EraseZeroHeader:
+		if size > eraseHeaderSize {
+			size = eraseHeaderSize
+		}
+		return zero(ctx, file, offset, size)
+	}
+	return offset, fmt.Errorf("csilvm: unknown erase policy %q", policy)
+}
+
+// eraseVolume activates the inactive volume exclusively, erases its data
+// according to the policy and deactivates it again. It refuses to erase a
+// volume that is active or open on this node, as it may be in use. Should
+// zeroing stop early because the context is done, the progress is recorded
+// in a tag on the volume and the next attempt resumes from there, so that a
+// large volume is erased over several attempts even if each is cut short by
+// the deadline of the RPC.
+func eraseVolume(ctx context.Context, lv *lvm.LogicalVolume, lvr lvm.LogicalVolumeReport, policy ErasePolicy) error {
+	if lvr.DeviceOpen() {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"Cannot erase volume %v, its device is open on this node",
+			lv.Name())
+	}
+	if lvr.Active() {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"Cannot erase volume %v, it is active on this node and may be in use",
+			lv.Name())
+	}
+	log.Printf("Activating volume %v exclusively to erase it", lv.Name())
+	if err := lv.ActivateExclusive(ctx); err != nil {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"Cannot activate volume exclusively to erase it, it may be in use on another node: err=%v",
+			err)
+	}
+	offset, progressTag := eraseProgress(lvr, policy)
+	log.Printf("Erasing volume %v with policy %v from offset %d", lv.Name(), policy, offset)
+	erased, eraseErr := eraseDevice(ctx, lvr.Path, policy, offset)
+	// The volume is deactivated and the progress recorded even if
+	// erasing it was canceled.
+	if eraseErr != nil && erased > offset {
+		log.Printf("Recording that %d bytes of volume %v are zeroed", erased, lv.Name())
+		var del []string
+		if progressTag != "" {
+			del = append(del, progressTag)
+		}
+		if err := lv.ChangeTags(context.Background(), []string{eraseProgressTag(policy, erased)}, del); err != nil {
+			log.Printf("Cannot record the progress of erasing volume %v: err=%v", lv.Name(), err)
+		}
+	}
+	log.Printf("Deactivating volume %v", lv.Name())
+	if err := lv.Deactivate(context.Background()); err != nil && eraseErr == nil {
+		return status.Errorf(codes.Internal, "Cannot deactivate volume after erasing it: err=%v", err)
+	}
+	if errors.Is(eraseErr, syscall.EBUSY) {
+		return status.Errorf(codes.FailedPrecondition, "Cannot erase volume %v, it is in use: err=%v", lv.Name(), eraseErr)
+	}
+	if eraseErr != nil {
+		return status.Errorf(errorCode(eraseErr), "Cannot erase volume with policy %v: err=%v", policy, eraseErr)
+	}
+	log.Printf("Erased volume %v", lv.Name())
+	return nil
+}
+
+// blkdiscard is the BLKDISCARD ioctl, _IO(0x12, 119), see linux/fs.h.
+const blkdiscard = 0x1277
+
+// discard discards the first size bytes of the block device.
+func discard(file *os.File, size int64) error {
+	rng := [2]uint64{0, uint64(size)}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), blkdiscard, uintptr(unsafe.Pointer(&rng)))
+	if errno != 0 {
+		return fmt.Errorf("BLKDISCARD failed: %v", errno)
+	}
+	return nil
+}
+
+// zero overwrites the bytes of the file from offset up to size with zeros.
+// Progress is logged every 10%. It returns the offset up to which the file
+// is known to be zeroed and, once the context is done, its error.
+func zero(ctx context.Context, file *os.File, offset, size int64) (int64, error) {
+	log.Printf("Zeroing bytes %d to %d of %v", offset, size, file.Name())
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	buf := make([]byte, 1<<20)
+	written := offset
+	reported := 0
+	for written < size {
+		if err := ctx.Err(); err != nil {
+			// Only bytes that reached the device count as zeroed.
+			if serr := file.Sync(); serr != nil {
+				return offset, serr
+			}
+			return written, err
+		}
+		n := int64(len(buf))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := file.Write(buf[:n]); err != nil {
+			return offset, err
+		}
+		written += n
+		if percent := int(written * 100 / size); percent/10 > reported/10 {
+			reported = percent
+			log.Printf("Zeroed %d%% of %v", percent, file.Name())
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return offset, err
+	}
+	return written, nil
+}
@@ -0,0 +1,145 @@
+package csilvm
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+func TestTakeEraseTagFromParameters(t *testing.T) {
+	params := map[string]string{paramErase: "zero-header"}
+	tag, err := takeEraseTagFromParameters(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 0 {
+		t.Fatalf("Expected the parameter to be consumed but got %v", params)
+	}
+	lv := lvm.LogicalVolumeReport{RawTags: tag}
+	if policy := volumeErasePolicy(lv, EraseNone); policy != EraseZeroHeader {
+		t.Fatalf("Expected %v but got %v", EraseZeroHeader, policy)
+	}
+	if policy := volumeErasePolicy(lvm.LogicalVolumeReport{}, EraseZero); policy != EraseZero {
+		t.Fatalf("Expected the default policy but got %v", policy)
+	}
+	if _, err := takeEraseTagFromParameters(map[string]string{paramErase: "shred"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestEraseDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	const size = eraseHeaderSize + 1<<20
+	ones := bytes.Repeat([]byte{1}, size)
+	for _, policy := range []ErasePolicy{EraseNone, EraseZero, EraseZeroHeader} {
+		path := filepath.Join(dir, string(policy))
+		if err := ioutil.WriteFile(path, ones, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := eraseDevice(context.Background(), path, policy, 0); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zeroed := 0
+		switch policy {
+		case EraseZero:
+			zeroed = size
+		case EraseZeroHeader:
+			zeroed = eraseHeaderSize
+		}
+		if len(data) != size {
+			t.Fatalf("%v: expected %d bytes but got %d", policy, size, len(data))
+		}
+		if !bytes.Equal(data[:zeroed], make([]byte, zeroed)) || !bytes.Equal(data[zeroed:], ones[zeroed:]) {
+			t.Fatalf("%v: expected the first %d bytes to be zeroed", policy, zeroed)
+		}
+	}
+}
+
+func TestEraseDeviceCanceled(t *testing.T) {
+	file, err := ioutil.TempFile("", "csilvm-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	ones := bytes.Repeat([]byte{1}, 4<<20)
+	_, err = file.Write(ones)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	erased, err := eraseDevice(ctx, file.Name(), EraseZero, 1<<20)
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled but got %v", err)
+	}
+	if erased != 1<<20 {
+		t.Fatalf("Expected the progress to remain at %d but got %d", 1<<20, erased)
+	}
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, ones) {
+		t.Fatal("Expected nothing to be zeroed")
+	}
+}
+
+func TestEraseDeviceResumes(t *testing.T) {
+	file, err := ioutil.TempFile("", "csilvm-erase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	const size = 4 << 20
+	ones := bytes.Repeat([]byte{1}, size)
+	_, err = file.Write(ones)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first 2MiB were zeroed by an earlier attempt and are not
+	// written again.
+	erased, err := eraseDevice(context.Background(), file.Name(), EraseZero, 2<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if erased != size {
+		t.Fatalf("Expected %d bytes to be zeroed but got %d", size, erased)
+	}
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data[:2<<20], ones[:2<<20]) || !bytes.Equal(data[2<<20:], make([]byte, 2<<20)) {
+		t.Fatal("Expected only the bytes from the offset on to be zeroed")
+	}
+}
+
+func TestEraseProgress(t *testing.T) {
+	tag := eraseProgressTag(EraseZero, 1<<30)
+	if err := lvm.ValidateTag(tag); err != nil {
+		t.Fatal(err)
+	}
+	lv := lvm.LogicalVolumeReport{RawTags: "VN.test," + tag}
+	if offset, got := eraseProgress(lv, EraseZero); offset != 1<<30 || got != tag {
+		t.Fatalf("Expected offset %d recorded in %v but got %d in %v", 1<<30, tag, offset, got)
+	}
+	// Progress made with another policy does not count.
+	if offset, got := eraseProgress(lv, EraseZeroHeader); offset != 0 || got != "" {
+		t.Fatalf("Expected no progress but got %d in %v", offset, got)
+	}
+}
@@ -0,0 +1,986 @@
+package csilvm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// These tests exercise the controller RPCs against lvm.FakeExecutor. Unlike
+// the tests in csilvm_test.go they do not require root privileges, loop
+// devices or lvm2 to be installed.
+
+const fakeDeviceSize = 100 << 20 // 100MiB
+
+type fakeServer struct {
+	csi.IdentityServer
+	csi.ControllerServer
+	server *Server
+	fake   *lvm.FakeExecutor
+}
+
+// startFakeTest configures lvm to use a fresh FakeExecutor and returns a
+// Server that manages a new volume group consisting of ndevs fake devices.
+// The devices are backed by temporary files so that Setup can stat and zero
+// them.
+func startFakeTest(t *testing.T, ndevs int, serverOpts ...ServerOpt) (*fakeServer, func()) {
+	t.Helper()
+	fake := lvm.NewFakeExecutor()
+	lvm.SetExecutor(fake)
+	var pvnames []string
+	for i := 0; i < ndevs; i++ {
+		file, err := ioutil.TempFile("", "csilvm-fake-dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		fake.AddDevice(file.Name(), fakeDeviceSize)
+		pvnames = append(pvnames, file.Name())
+	}
+	cleanup := func() {
+		lvm.SetExecutor(nil)
+		for _, pvname := range pvnames {
+			os.Remove(pvname)
+		}
+	}
+	s := NewServer("test-vg", pvnames, "xfs", serverOpts...)
+	if err := s.Setup(); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return &fakeServer{
+		IdentityServer:   IdentityServerValidator(s),
+		ControllerServer: ControllerServerValidator(s, s.RemovingVolumeGroup(), s.SupportedFilesystems()),
+		server:           s,
+		fake:             fake,
+	}, cleanup
+}
+
+func fakeCreateVolumeRequest(name string, requiredBytes int64) *csi.CreateVolumeRequest {
+	return &csi.CreateVolumeRequest{
+		Name:          name,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: requiredBytes},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Block{
+					Block: &csi.VolumeCapability_BlockVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+}
+
+func checkCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if st, ok := status.FromError(err); !ok || st.Code() != code {
+		t.Fatalf("Expected error with code %v but got %v", code, err)
+	}
+}
+
+func TestFakeCreateVolume(t *testing.T) {
+	fs, clean := startFakeTest(t, 1, Tag("some-tag"))
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 80<<20)
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := resp.GetVolume()
+	if info.GetCapacityBytes() != req.GetCapacityRange().GetRequiredBytes() {
+		t.Fatalf("Expected required_bytes (%v) to match volume size (%v).", req.GetCapacityRange().GetRequiredBytes(), info.GetCapacityBytes())
+	}
+	tags := stripCreationTime(fakeVolumeTags(t, fs)[info.GetVolumeId()])
+	exp := map[string]bool{"some-tag": true, tagVolumeNamePlainPrefix + "test-volume": true, tagCreated: true}
+	if len(tags) != len(exp) {
+		t.Fatalf("Expected tags %v but got %v", exp, tags)
+	}
+	for _, tag := range tags {
+		if !exp[tag] {
+			t.Fatalf("Unexpected tag %v", tag)
+		}
+	}
+}
+
+func TestFakeCreateVolumeDefaultSize(t *testing.T) {
+	const defaultVolumeSize = uint64(20 << 20)
+	fs, clean := startFakeTest(t, 1, DefaultVolumeSize(defaultVolumeSize))
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 0)
+	req.CapacityRange = nil
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uint64(resp.GetVolume().GetCapacityBytes()); got != defaultVolumeSize {
+		t.Fatalf("Expected defaultVolumeSize (%v) to match volume size (%v).", defaultVolumeSize, got)
+	}
+}
+
+func TestFakeCreateVolumeCapacityNotMultipleOfExtentSize(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 25<<20)
+	req.CapacityRange.LimitBytes = 25 << 20
+	_, err := fs.CreateVolume(context.Background(), req)
+	checkCode(t, err, codes.OutOfRange)
+}
+
+func TestFakeCreateVolumeCapacityRangeNotSatisfied(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 1<<30)
+	_, err := fs.CreateVolume(context.Background(), req)
+	if err != ErrInsufficientCapacity {
+		t.Fatalf("Expected ErrInsufficientCapacity but got %v", err)
+	}
+}
+
+func TestFakeCreateVolume_VolumeLayout_RAID1(t *testing.T) {
+	fs, clean := startFakeTest(t, 2)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{"type": "raid1"}
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.GetVolume().GetCapacityBytes(); got != 20<<20 {
+		t.Fatalf("Expected volume size %d but got %d", 20<<20, got)
+	}
+}
+
+func TestFakeCreateVolume_VolumeLayout_TooFewDisks(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{"type": "raid1"}
+	_, err := fs.CreateVolume(context.Background(), req)
+	if err != ErrInsufficientCapacity {
+		t.Fatalf("Expected ErrInsufficientCapacity but got %v", err)
+	}
+}
+
+func TestFakeDeleteVolume(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	resp, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()}
+	if _, err := fs.DeleteVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	// DeleteVolume is idempotent.
+	if _, err := fs.DeleteVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.server.volumeGroup.LookupLogicalVolume(context.Background(), req.GetVolumeId()); err != lvm.ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected volume to be removed but got %v", err)
+	}
+}
+
+func TestFakeListVolumes(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ids := make(map[string]bool)
+	for _, name := range []string{"test-volume-1", "test-volume-2"} {
+		resp, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest(name, 20<<20))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[resp.GetVolume().GetVolumeId()] = true
+	}
+	resp, err := fs.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != len(ids) {
+		t.Fatalf("Expected %d volumes but got %v", len(ids), resp.GetEntries())
+	}
+	for _, entry := range resp.GetEntries() {
+		if !ids[entry.GetVolume().GetVolumeId()] {
+			t.Fatalf("Unexpected volume %v", entry.GetVolume())
+		}
+	}
+}
+
+func TestFakeGetCapacity(t *testing.T) {
+	fs, clean := startFakeTest(t, 4)
+	defer clean()
+	if _, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 40<<20)); err != nil {
+		t.Fatal(err)
+	}
+	req := &csi.GetCapacityRequest{
+		Parameters: map[string]string{
+			"type":    "raid1",
+			"mirrors": "2",
+		},
+	}
+	resp, err := fs.GetCapacity(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const extentSize = lvm.DefaultFakeExtentSize
+	// Four devices each reserve one extent for metadata, the volume
+	// consumes 10 extents and each of the three RAID images requires an
+	// additional metadata extent.
+	linearExtents := uint64(4*fakeDeviceSize/extentSize - 4 - 10)
+	exp := (linearExtents - 3) / 3 * extentSize
+	if got := uint64(resp.GetAvailableCapacity()); got != exp {
+		t.Fatalf("Expected %d bytes free but got %d", exp, got)
+	}
+}
+
+func TestFakeProbe(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	if _, err := fs.Probe(context.Background(), &csi.ProbeRequest{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFakeSetup_ExistingVolumeGroup_UnexpectedTag(t *testing.T) {
+	fs, clean := startFakeTest(t, 1, Tag("some-tag"))
+	defer clean()
+	s := NewServer("test-vg", fs.server.pvnames, "xfs", Tag("other-tag"))
+	if err := s.Setup(); err == nil {
+		t.Fatal("Expected Setup to fail due to mismatched tags")
+	}
+}
+
+func TestFakeCreateVolume_DeadlineExceeded(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	fs.fake.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := fs.CreateVolume(ctx, fakeCreateVolumeRequest("test-volume", 20<<20))
+	checkCode(t, err, codes.DeadlineExceeded)
+}
+
+func TestFakeDeleteVolume_DeadlineExceeded(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	resp, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.fake.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	// A lookup that times out must not be mistaken for a missing volume.
+	_, err = fs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: resp.GetVolume().GetVolumeId()})
+	checkCode(t, err, codes.DeadlineExceeded)
+}
+
+func TestFakeCreateVolume_CommandCount(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	before := len(fs.fake.Commands())
+	if _, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20)); err != nil {
+		t.Fatal(err)
+	}
+	// A report before and after creating the volume plus lvcreate and
+	// lvchange to deactivate the volume and to mark it as created.
+	if n := len(fs.fake.Commands()) - before; n != 5 {
+		t.Fatalf("Expected 5 commands but got %d: %v", n, fs.fake.Commands()[before:])
+	}
+}
+
+// fakeVolumeTags returns the tags of every logical volume in the volume
+// group managed by fs.
+func fakeVolumeTags(t *testing.T, fs *fakeServer) map[string][]string {
+	t.Helper()
+	report, err := lvm.FullReport(context.Background(), "test-vg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := make(map[string][]string)
+	for _, lv := range report.LogicalVolumes {
+		tags[lv.Name] = lv.Tags()
+	}
+	return tags
+}
+
+// stripCreationTime returns tags without the tag that records the creation
+// time of the volume.
+func stripCreationTime(tags []string) []string {
+	var stripped []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagCreationTimePrefix) {
+			stripped = append(stripped, tag)
+		}
+	}
+	return stripped
+}
+
+func TestFakeCreateVolume_MarkedCreated(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	resp, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := stripCreationTime(fakeVolumeTags(t, fs)[resp.GetVolume().GetVolumeId()])
+	exp := []string{"VN.test-volume", tagCreated}
+	if !reflect.DeepEqual(tags, exp) {
+		t.Fatalf("Expected tags %v but got %v", exp, tags)
+	}
+}
+
+func TestFakeCreateVolume_RollbackOnFailure(t *testing.T) {
+	// Deactivating the new volume or marking it as created fails.
+	for _, match := range []string{"lvchange -an", "lvchange --addtag"} {
+		t.Run(match, func(t *testing.T) {
+			fs, clean := startFakeTest(t, 1)
+			defer clean()
+			fs.fake.FailNext(match, "  Device or resource busy")
+			_, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+			if status.Code(err) != codes.FailedPrecondition {
+				t.Fatalf("Expected FailedPrecondition but got %v", err)
+			}
+			if tags := fakeVolumeTags(t, fs); len(tags) != 0 {
+				t.Fatalf("Expected the volume to be removed but found %v", tags)
+			}
+		})
+	}
+}
+
+func TestFakeCreateVolume_RemovesStaleVolume(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	// A volume left behind by an attempt that failed to roll back.
+	if _, err := fs.server.volumeGroup.CreateLogicalVolume(context.Background(), "csilvstale", 20<<20, []string{"VN.test-volume", fs.server.creatingTag()}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := fakeVolumeTags(t, fs)
+	if _, ok := tags["csilvstale"]; ok {
+		t.Fatal("Expected the stale volume to be removed")
+	}
+	if len(tags) != 1 || tags[resp.GetVolume().GetVolumeId()] == nil {
+		t.Fatalf("Unexpected volumes %v", tags)
+	}
+}
+
+func TestFakeCreateVolume_CreatingElsewhere(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	if _, err := fs.server.volumeGroup.CreateLogicalVolume(context.Background(), "csilvother", 20<<20, []string{"VN.test-volume", tagCreatingPrefix + "b3RoZXI"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.CreateVolume(context.Background(), fakeCreateVolumeRequest("test-volume", 20<<20))
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("Expected Aborted but got %v", err)
+	}
+	if _, ok := fakeVolumeTags(t, fs)["csilvother"]; !ok {
+		t.Fatal("Expected the volume being created by another instance to remain")
+	}
+}
+
+func TestFakeSetup_RemovesIncompleteVolumes(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	vg := fs.server.volumeGroup
+	for name, tag := range map[string]string{
+		"csilvcrashed": fs.server.creatingTag(),
+		"csilvother":   tagCreatingPrefix + "b3RoZXI",
+		"csilvdone":    tagCreated,
+	} {
+		if _, err := vg.CreateLogicalVolume(ctx, name, 20<<20, []string{tag}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Restart the plugin.
+	s := NewServer("test-vg", fs.server.pvnames, "xfs")
+	if err := s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	tags := fakeVolumeTags(t, fs)
+	if _, ok := tags["csilvcrashed"]; ok {
+		t.Fatal("Expected the incompletely created volume to be removed")
+	}
+	for _, name := range []string{"csilvother", "csilvdone"} {
+		if _, ok := tags[name]; !ok {
+			t.Fatalf("Expected volume %s to remain", name)
+		}
+	}
+}
+
+func TestFakeSetup_RemovesEphemeralVolumes(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	vg := fs.server.volumeGroup
+	for name, tag := range map[string]string{
+		"csilvecrashed": fs.server.ephemeralTag(),
+		"csilveother":   tagEphemeralPrefix + "b3RoZXI",
+		"csilvdone":     tagCreated,
+	} {
+		if _, err := vg.CreateLogicalVolume(ctx, name, 20<<20, []string{tag}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Restart the plugin.
+	s := NewServer("test-vg", fs.server.pvnames, "xfs")
+	if err := s.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	tags := fakeVolumeTags(t, fs)
+	if _, ok := tags["csilvecrashed"]; ok {
+		t.Fatal("Expected the unused ephemeral volume to be removed")
+	}
+	for _, name := range []string{"csilveother", "csilvdone"} {
+		if _, ok := tags[name]; !ok {
+			t.Fatalf("Expected volume %s to remain", name)
+		}
+	}
+}
+
+func fakeEphemeralPublishRequest(id string, volumeContext map[string]string) *csi.NodePublishVolumeRequest {
+	return &csi.NodePublishVolumeRequest{
+		VolumeId:      id,
+		TargetPath:    "/var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/data/mount",
+		VolumeContext: volumeContext,
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+}
+
+func TestFakeCreateEphemeralVolume(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	request := fakeEphemeralPublishRequest("csi-0123456789abcdef", map[string]string{
+		volumeContextEphemeral:             "true",
+		"csi.storage.k8s.io/pod.name":      "test-pod",
+		"csi.storage.k8s.io/pod.namespace": "default",
+		paramSize:                          "10Mi",
+		paramDiscard:                       discardOnline,
+	})
+	lv, isNew, err := fs.server.createEphemeralVolume(ctx, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isNew {
+		t.Fatal("Expected a new volume")
+	}
+	if lv.Name != ephemeralVolumeName("csi-0123456789abcdef") {
+		t.Fatalf("Unexpected volume name %s", lv.Name)
+	}
+	// The size is rounded up to a multiple of the extent size.
+	if lv.Size != 12<<20 {
+		t.Fatalf("Expected 12MiB but got %d bytes", lv.Size)
+	}
+	if !lv.HasTag(fs.server.ephemeralTag()) || !isDiscardOnline(lv) {
+		t.Fatalf("Unexpected tags %v", lv.Tags())
+	}
+	// A retried request returns the existing volume.
+	if again, isNew, err := fs.server.createEphemeralVolume(ctx, request); err != nil || isNew || again.Name != lv.Name {
+		t.Fatalf("Expected the existing volume but got %v, %v, %v", again.Name, isNew, err)
+	}
+	// NodeUnpublishVolume finds the volume by the ID chosen by the CO.
+	if found, err := fs.server.lookupPublishedVolume(ctx, "csi-0123456789abcdef"); err != nil || found.Name != lv.Name {
+		t.Fatalf("Expected to find %v but got %v, %v", lv.Name, found.Name, err)
+	}
+	// Ephemeral volumes are not listed to the CO.
+	resp, err := fs.ListVolumes(ctx, &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.GetEntries()) != 0 {
+		t.Fatalf("Expected no volumes but got %v", resp.GetEntries())
+	}
+}
+
+func TestFakeCreateEphemeralVolume_InvalidContext(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	for _, volumeContext := range []map[string]string{
+		{volumeContextEphemeral: "true", paramSize: "ten"},
+		{volumeContextEphemeral: "true", "unknown": "value"},
+	} {
+		_, _, err := fs.server.createEphemeralVolume(context.Background(), fakeEphemeralPublishRequest("csi-invalid", volumeContext))
+		checkCode(t, err, codes.InvalidArgument)
+	}
+	if _, ok := fakeVolumeTags(t, fs)[ephemeralVolumeName("csi-invalid")]; ok {
+		t.Fatal("Expected no volume to be created")
+	}
+}
+
+func TestFakeSetup_RollbackOnFailure(t *testing.T) {
+	fake := lvm.NewFakeExecutor()
+	lvm.SetExecutor(fake)
+	defer lvm.SetExecutor(nil)
+	var pvnames []string
+	for i := 0; i < 2; i++ {
+		file, err := ioutil.TempFile("", "csilvm-fake-dev")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+		defer os.Remove(file.Name())
+		fake.AddDevice(file.Name(), fakeDeviceSize)
+		pvnames = append(pvnames, file.Name())
+	}
+	fake.FailNext("vgcreate", "  Device or resource busy")
+	s := NewServer("test-vg", pvnames, "xfs")
+	if err := s.Setup(); err == nil {
+		t.Fatal("Expected Setup to fail")
+	}
+	// The physical volumes created by Setup are removed again.
+	pvs, err := lvm.ListPhysicalVolumes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvs) != 0 {
+		t.Fatalf("Expected no physical volumes but got %v", pvs)
+	}
+}
+
+func TestFakeCreateVolume_RecordsCreationTimeAndMetadata(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{
+		paramPVCName:      "data-db-0",
+		paramPVCNamespace: "has space",
+		paramPVName:       "pvc-3f1a",
+		// Unknown metadata parameters are accepted and ignored.
+		paramMetadataPrefix + "unknown": "value",
+	}
+	before := time.Now().Truncate(time.Second)
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := fs.server.volumeGroup.Report(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := report.LogicalVolume("test-vg", resp.GetVolume().GetVolumeId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, ok := volumeCreationTime(lv)
+	if !ok || created.Before(before) || created.After(time.Now()) {
+		t.Fatalf("Unexpected creation time %v, %v", created, ok)
+	}
+	for _, tag := range []string{
+		tagPVCNamePlainPrefix + "data-db-0",
+		tagPVCNamespaceEncodedPrefix + "aGFzIHNwYWNl",
+		tagPVNamePlainPrefix + "pvc-3f1a",
+	} {
+		if !lv.HasTag(tag) {
+			t.Fatalf("Expected tag %s but got tags %v", tag, lv.Tags())
+		}
+	}
+	exp, err := volumeAttributes([]string{tagVolumeNamePlainPrefix + "test-volume"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp[paramPVCName] = "data-db-0"
+	exp[paramPVCNamespace] = "has space"
+	exp[paramPVName] = "pvc-3f1a"
+	if attr := resp.GetVolume().GetVolumeContext(); !reflect.DeepEqual(attr, exp) {
+		t.Fatalf("Expected volume context %v but got %v", exp, attr)
+	}
+	// ListVolumes reports the same volume context.
+	list, err := fs.ListVolumes(context.Background(), &csi.ListVolumesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr := list.GetEntries()[0].GetVolume().GetVolumeContext(); !reflect.DeepEqual(attr, exp) {
+		t.Fatalf("Expected volume context %v but got %v", exp, attr)
+	}
+}
+
+func TestFakeFindOrphanedVolumes(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	var ids []string
+	for _, name := range []string{"known", "orphan"} {
+		req := fakeCreateVolumeRequest(name, 20<<20)
+		req.Parameters = map[string]string{paramPVCName: name, paramPVCNamespace: "default"}
+		resp, err := fs.CreateVolume(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, resp.GetVolume().GetVolumeId())
+	}
+	// Volumes that were not created by CreateVolume or are still being
+	// created are not orphaned.
+	for name, tags := range map[string][]string{
+		"other":       nil,
+		"csilvcreate": {fs.server.creatingTag()},
+	} {
+		if _, err := fs.server.volumeGroup.CreateLogicalVolume(ctx, name, 4<<20, tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orphans, err := FindOrphanedVolumes(ctx, "test-vg", ids[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orphans) != 1 {
+		t.Fatalf("Expected a single orphaned volume but got %v", orphans)
+	}
+	orphan := orphans[0]
+	if orphan.ID != ids[1] || orphan.Size != 20<<20 || orphan.PVCName != "orphan" || orphan.PVCNamespace != "default" {
+		t.Fatalf("Unexpected orphaned volume %+v", orphan)
+	}
+	if age := orphan.Age(time.Now()); age < 0 || age > time.Minute {
+		t.Fatalf("Unexpected age %v", age)
+	}
+	if err := RemoveOrphanedVolume(ctx, "test-vg", orphan.ID, EraseNone); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fakeVolumeTags(t, fs)[orphan.ID]; ok {
+		t.Fatalf("Expected volume %s to be removed", orphan.ID)
+	}
+	if orphans, err := FindOrphanedVolumes(ctx, "test-vg", ids[:1]); err != nil || len(orphans) != 0 {
+		t.Fatalf("Expected no orphaned volumes but got %v, %v", orphans, err)
+	}
+}
+
+func TestFakeCreateVolume_UserTags(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	for name, tags := range map[string]string{
+		"gold":   "tier=gold, backup.nightly",
+		"silver": "tier=silver,backup.nightly",
+		"bronze": "",
+	} {
+		req := fakeCreateVolumeRequest(name, 20<<20)
+		if tags != "" {
+			req.Parameters = map[string]string{"tags": tags}
+		}
+		if _, err := fs.CreateVolume(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	volumes, err := ListVolumesWithTags(ctx, "test-vg", []string{"backup.nightly", "tier=gold"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 1 {
+		t.Fatalf("Expected a single volume but got %v", volumes)
+	}
+	tags, err := VolumeTags(volumes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"VN.gold", "tier=gold", "backup.nightly"}
+	if !reflect.DeepEqual(tags, exp) {
+		t.Fatalf("Expected tags %v but got %v", exp, tags)
+	}
+	// The unsafe tag is encoded on the logical volume.
+	lvtags := fakeVolumeTags(t, fs)[volumes[0].GetVolumeId()]
+	found := false
+	for _, tag := range lvtags {
+		found = found || tag == tagUserEncodedPrefix+"dGllcj1nb2xk"
+	}
+	if !found {
+		t.Fatalf("Expected an encoded tag but got %v", lvtags)
+	}
+	volumes, err = ListVolumesWithTags(ctx, "test-vg", []string{"backup.nightly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("Expected two volumes but got %v", volumes)
+	}
+	volumes, err = ListVolumesWithTags(ctx, "test-vg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 3 {
+		t.Fatalf("Expected three volumes but got %v", volumes)
+	}
+}
+
+func TestFakeCreateVolume_InvalidUserTags(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	for _, tags := range []string{"a,,b", "a,", strings.Repeat("x", 1025)} {
+		req := fakeCreateVolumeRequest("test-volume", 20<<20)
+		req.Parameters = map[string]string{"tags": tags}
+		_, err := fs.CreateVolume(context.Background(), req)
+		checkCode(t, err, codes.InvalidArgument)
+	}
+	if tags := fakeVolumeTags(t, fs); len(tags) != 0 {
+		t.Fatalf("Expected no volumes but found %v", tags)
+	}
+}
+
+func TestFakeCreateVolume_FsProfile(t *testing.T) {
+	fs, clean := startFakeTest(t, 1, FilesystemProfileOpt(FilesystemProfile{Name: "fast", FsType: "ext4"}))
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{paramFsProfile: "fast"}
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := resp.GetVolume().GetVolumeContext()[paramFsProfile]; name != "fast" {
+		t.Fatalf("Expected filesystem profile %q in the volume context but got %q", "fast", name)
+	}
+	req = fakeCreateVolumeRequest("other-volume", 20<<20)
+	req.Parameters = map[string]string{paramFsProfile: "slow"}
+	_, err = fs.CreateVolume(context.Background(), req)
+	checkCode(t, err, codes.InvalidArgument)
+}
+
+func TestFakeCreateVolume_Encrypted(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{paramEncrypted: "true"}
+	resp, err := fs.CreateVolume(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := resp.GetVolume().GetVolumeContext()[paramEncrypted]; v != "true" {
+		t.Fatalf("Expected the volume context to say the volume is encrypted but got %q", v)
+	}
+	found := false
+	for _, tag := range fakeVolumeTags(t, fs)[resp.GetVolume().GetVolumeId()] {
+		found = found || tag == tagEncrypted
+	}
+	if !found {
+		t.Fatal("Expected the volume to be tagged as encrypted")
+	}
+}
+
+func TestFakeListEncryptedVolumes(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	for name, params := range map[string]map[string]string{
+		"plain":     nil,
+		"encrypted": {paramEncrypted: "true"},
+	} {
+		req := fakeCreateVolumeRequest(name, 20<<20)
+		req.Parameters = params
+		if _, err := fs.CreateVolume(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An encrypted volume whose key was rotated to generation 2.
+	tags := []string{tagEncrypted, keyGenerationTag(2)}
+	if _, err := fs.server.volumeGroup.CreateLogicalVolume(ctx, "csilvrotated", 4<<20, tags); err != nil {
+		t.Fatal(err)
+	}
+	volumes, err := ListEncryptedVolumes(ctx, "test-vg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(volumes) != 2 {
+		t.Fatalf("Expected two encrypted volumes but got %v", volumes)
+	}
+	generations := make(map[string]uint64)
+	for _, v := range volumes {
+		generations[v.ID] = v.KeyGeneration
+	}
+	if g, ok := generations["csilvrotated"]; !ok || g != 2 {
+		t.Fatalf("Expected the rotated volume on generation 2 but got %v", volumes)
+	}
+	for id, g := range generations {
+		if id != "csilvrotated" && g != 0 {
+			t.Fatalf("Expected volume %s on generation 0 but got %d", id, g)
+		}
+	}
+	if err := RotateVolumeKey(ctx, "test-vg", "csilvrotated", "", "new", 3); err == nil {
+		t.Fatal("Expected an error without the current key")
+	}
+	// The key generation cannot go backwards.
+	before := len(fs.fake.Commands())
+	if err := RotateVolumeKey(ctx, "test-vg", "csilvrotated", "old", "new", 2); err == nil {
+		t.Fatal("Expected an error for a generation that is not greater than the current one")
+	}
+	for _, cmd := range fs.fake.Commands()[before:] {
+		if cmd[0] == "lvchange" {
+			t.Fatalf("Expected the volume not to be activated but ran %v", cmd)
+		}
+	}
+}
+
+func TestFakeDeleteVolume_EraseActivationFails(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{paramErase: string(EraseZero)}
+	resp, err := fs.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.GetVolume().GetVolumeId()
+	fs.fake.FailNext("lvchange -aey", "  LV locked by other host")
+	_, err = fs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition but got %v", err)
+	}
+	if _, ok := fakeVolumeTags(t, fs)[id]; !ok {
+		t.Fatal("Expected the volume not to be removed")
+	}
+}
+
+func TestFakeDeleteVolume_EraseActiveVolume(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.Parameters = map[string]string{paramErase: string(EraseZero)}
+	resp, err := fs.CreateVolume(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.GetVolume().GetVolumeId()
+	// The volume is in use on this node, e.g., still published.
+	lv, err := fs.server.volumeGroup.LookupLogicalVolume(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.Activate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	before := len(fs.fake.Commands())
+	_, err = fs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: id})
+	checkCode(t, err, codes.FailedPrecondition)
+	for _, cmd := range fs.fake.Commands()[before:] {
+		if cmd[0] == "lvchange" || cmd[0] == "lvremove" {
+			t.Fatalf("Expected the volume to be left alone but ran %v", cmd)
+		}
+	}
+}
+
+func TestFakeRemoveOrphanedVolume_Erases(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	resp, err := fs.CreateVolume(ctx, fakeCreateVolumeRequest("test-volume", 20<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := resp.GetVolume().GetVolumeId()
+	// The volume is activated exclusively to erase it with the default
+	// policy before it is removed.
+	fs.fake.FailNext("lvchange -aey", "  LV locked by other host")
+	if err := RemoveOrphanedVolume(ctx, "test-vg", id, EraseZero); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("Expected FailedPrecondition but got %v", err)
+	}
+	if _, ok := fakeVolumeTags(t, fs)[id]; !ok {
+		t.Fatal("Expected the volume not to be removed")
+	}
+}
+
+func TestFakeCreateVolume_WipeSignaturesAndZero(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	for name, tc := range map[string]struct {
+		params map[string]string
+		exp    []string
+	}{
+		"default": {nil, []string{"--wipesignatures=y", "--yes", "--zero=y"}},
+		"keep":    {map[string]string{paramWipeSignatures: "false", paramZero: "false"}, []string{"--wipesignatures=n", "--zero=n"}},
+	} {
+		req := fakeCreateVolumeRequest(name, 20<<20)
+		req.Parameters = tc.params
+		before := len(fs.fake.Commands())
+		if _, err := fs.CreateVolume(ctx, req); err != nil {
+			t.Fatal(err)
+		}
+		var lvcreate []string
+		for _, cmd := range fs.fake.Commands()[before:] {
+			if cmd[0] == "lvcreate" {
+				lvcreate = cmd
+			}
+		}
+		for _, flag := range tc.exp {
+			found := false
+			for _, arg := range lvcreate {
+				found = found || arg == flag
+			}
+			if !found {
+				t.Fatalf("%s: expected %s in %v", name, flag, lvcreate)
+			}
+		}
+	}
+	req := fakeCreateVolumeRequest("invalid", 20<<20)
+	req.Parameters = map[string]string{paramZero: "maybe"}
+	if _, err := fs.CreateVolume(ctx, req); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument but got %v", err)
+	}
+}
+
+func TestFakeShrinkVolume_Refused(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	ctx := context.Background()
+	create := func(name string, params map[string]string) string {
+		req := fakeCreateVolumeRequest(name, 40<<20)
+		req.Parameters = params
+		resp, err := fs.CreateVolume(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.GetVolume().GetVolumeId()
+	}
+	plain := create("plain", nil)
+	encrypted := create("encrypted", map[string]string{paramEncrypted: "true"})
+	if _, err := ShrinkVolume(ctx, "test-vg", encrypted, 20<<20); err == nil {
+		t.Fatal("Expected shrinking an encrypted volume to fail")
+	}
+	if _, err := ShrinkVolume(ctx, "test-vg", plain, 40<<20); err == nil {
+		t.Fatal("Expected growing a volume to fail")
+	}
+	// The fake volume has no device, so the filesystem cannot be
+	// determined. The volume is deactivated and left unchanged.
+	if _, err := ShrinkVolume(ctx, "test-vg", plain, 20<<20); err == nil {
+		t.Fatal("Expected an error")
+	}
+	lv, err := fs.server.lookupVolume(ctx, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.Active() || lv.Size != 40<<20 {
+		t.Fatalf("Expected the volume to be inactive and unchanged but got %+v", lv)
+	}
+}
+
+func TestFakeCreateVolume_ReadonlyBlock(t *testing.T) {
+	fs, clean := startFakeTest(t, 1)
+	defer clean()
+	req := fakeCreateVolumeRequest("test-volume", 20<<20)
+	req.VolumeCapabilities[0].AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
+	if _, err := fs.CreateVolume(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+}
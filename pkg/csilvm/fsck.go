@@ -0,0 +1,217 @@
+package csilvm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// FsckPolicy determines whether the filesystem of a volume is checked
+// before it is mounted.
+type FsckPolicy string
+
+const (
+	// FsckNever mounts the filesystem without checking it.
+	FsckNever FsckPolicy = "never"
+	// FsckCheck checks the filesystem without modifying it and refuses
+	// to mount it if it has errors.
+	FsckCheck FsckPolicy = "check"
+	// FsckRepair repairs the filesystem, if necessary, and refuses to
+	// mount it if it cannot be repaired. Volumes published read-only
+	// are only checked.
+	FsckRepair FsckPolicy = "repair"
+)
+
+// ParseFsckPolicy returns the fsck policy with the given name.
+func ParseFsckPolicy(s string) (FsckPolicy, error) {
+	switch p := FsckPolicy(s); p {
+	case FsckNever, FsckCheck, FsckRepair:
+		return p, nil
+	}
+	return "", fmt.Errorf("csilvm: unknown fsck policy %q, expected one of %v, %v or %v", s, FsckNever, FsckCheck, FsckRepair)
+}
+
+// The results of checking a filesystem.
+const (
+	fsckClean    = "clean"
+	fsckRepaired = "repaired"
+	fsckErrors   = "errors"
+	// fsckDirtyLog means that the xfs log or the ext3/4 journal must be
+	// replayed by mounting the filesystem before it can be checked.
+	fsckDirtyLog = "dirty-log"
+)
+
+// tagFsckPrefix is followed by the result of the last check of the
+// filesystem and the time of the check, e.g., "csilvm.fsck.clean.1570000000".
+const tagFsckPrefix = tagStatePrefix + "fsck."
+
+// fsckTag returns the tag that records the result of a check at time t.
+func fsckTag(result string, t time.Time) string {
+	return tagFsckPrefix + result + "." + strconv.FormatInt(t.Unix(), 10)
+}
+
+// fsckTagChanges returns the tags to add to and delete from the volume to
+// replace its fsck tag with one for result.
+func fsckTagChanges(lv lvm.LogicalVolumeReport, result string) (add, del []string) {
+	for _, tag := range lv.Tags() {
+		if strings.HasPrefix(tag, tagFsckPrefix) {
+			del = append(del, tag)
+		}
+	}
+	return []string{fsckTag(result, time.Now())}, del
+}
+
+// errFsckUnsupported is returned by checkFilesystem for filesystems it does
+// not know how to check.
+var errFsckUnsupported = errors.New("csilvm: checking this filesystem type is not supported")
+
+// xfsDirtyLogMessage is printed by xfs_repair if the log of the filesystem
+// must be replayed before it can be checked reliably.
+const xfsDirtyLogMessage = "valuable metadata changes in a log"
+
+// e2fsckNeedsRecoveryMessage is printed by e2fsck -n if the journal of the
+// filesystem needs recovery, e.g., after a crash, which it does not do in a
+// read-only check.
+const e2fsckNeedsRecoveryMessage = "skipping journal recovery"
+
+// checkFilesystem checks, or with repair also repairs, the filesystem on the
+// device. It returns the result and the output of the check. An error is
+// returned only if the check could not be run.
+func checkFilesystem(devicePath, fstype string, repair bool) (result string, output []byte, err error) {
+	switch fstype {
+	case "xfs":
+		// xfs_repair exits with 0 whether or not it changed the
+		// filesystem so it is only run if a check finds errors.
+		result, output, err = runFsck(fstype, false, "xfs_repair", "-n", devicePath)
+		if err != nil || !repair || result != fsckErrors {
+			return result, output, err
+		}
+		return runFsck(fstype, true, "xfs_repair", devicePath)
+	case "ext2", "ext3", "ext4":
+		if repair {
+			return runFsck(fstype, true, "e2fsck", "-p", devicePath)
+		}
+		return runFsck(fstype, false, "e2fsck", "-n", devicePath)
+	}
+	return "", nil, errFsckUnsupported
+}
+
+// runFsck runs the command that checks or repairs a filesystem of the given
+// type and returns the result according to its exit code.
+func runFsck(fstype string, repair bool, name string, args ...string) (string, []byte, error) {
+	output, err := exec.Command(name, args...).CombinedOutput()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return "", output, err
+	}
+	code := 0
+	if exitErr != nil {
+		code = exitErr.ExitCode()
+	}
+	result, err := fsckResult(fstype, repair, code, output)
+	return result, output, err
+}
+
+// fsckResult interprets the exit code and output of xfs_repair or e2fsck.
+func fsckResult(fstype string, repair bool, code int, output []byte) (string, error) {
+	if fstype == "xfs" {
+		// xfs_repair -n exits with 1 if the filesystem is corrupt or
+		// its log is dirty. xfs_repair exits with 0 once it repaired
+		// the filesystem, with 1 if it could not and with 2 if the
+		// log is dirty.
+		switch {
+		case code == 0 && repair:
+			return fsckRepaired, nil
+		case code == 0:
+			return fsckClean, nil
+		case code == 2 && repair:
+			return fsckDirtyLog, nil
+		case code == 1 && !repair && bytes.Contains(output, []byte(xfsDirtyLogMessage)):
+			return fsckDirtyLog, nil
+		case code == 1:
+			return fsckErrors, nil
+		}
+		return "", fmt.Errorf("xfs_repair failed: exit code %d", code)
+	}
+	// e2fsck exits with 1 if errors were corrected, 2 if they were and the
+	// system should be rebooted, and 4 if errors were left uncorrected.
+	// e2fsck -n reports errors that replaying the journal may resolve.
+	switch {
+	case code&4 != 0 && !repair && bytes.Contains(output, []byte(e2fsckNeedsRecoveryMessage)):
+		return fsckDirtyLog, nil
+	case code == 0:
+		return fsckClean, nil
+	case code&^(1|2) == 0:
+		return fsckRepaired, nil
+	case code&4 != 0 && code&^(1|2|4) == 0:
+		return fsckErrors, nil
+	}
+	return "", fmt.Errorf("e2fsck failed: exit code %d", code)
+}
+
+// isDeviceMounted returns true if the device at devicePath, which may be a
+// symlink such as /dev/<vg>/<lv>, is the source of any of the mounts.
+func isDeviceMounted(devicePath string, mounts []mountpoint) bool {
+	dev, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		dev = devicePath
+	}
+	for _, mp := range mounts {
+		if mp.mountsource == devicePath || mp.mountsource == dev {
+			return true
+		}
+		if source, err := filepath.EvalSymlinks(mp.mountsource); err == nil && source == dev {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelMessages returns the last few kernel log messages about the device,
+// such as those logged by the filesystem when it fails to mount. It returns
+// nil if the kernel log cannot be read.
+func kernelMessages(devicePath string) []string {
+	dev, err := filepath.EvalSymlinks(devicePath)
+	if err != nil {
+		return nil
+	}
+	// Filesystems log messages like "XFS (dm-4): ...".
+	needle := "(" + filepath.Base(dev) + ")"
+	output, err := exec.Command("dmesg").Output()
+	if err != nil {
+		log.Printf("Cannot read kernel messages: err=%v", err)
+		return nil
+	}
+	return filterKernelMessages(output, needle, 10)
+}
+
+// filterKernelMessages returns up to max of the last lines of output that
+// contain needle.
+func filterKernelMessages(output []byte, needle string, max int) []string {
+	var lines []string
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if bytes.Contains(line, []byte(needle)) {
+			lines = append(lines, string(bytes.TrimSpace(line)))
+		}
+	}
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return lines
+}
+
+// lastLines returns up to max of the last non-empty lines of output.
+func lastLines(output []byte, max int) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > max {
+		lines = lines[len(lines)-max:]
+	}
+	return strings.Join(lines, "\n")
+}
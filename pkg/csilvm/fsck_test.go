@@ -0,0 +1,121 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+func TestParseFilesystemProfileFsck(t *testing.T) {
+	p, err := ParseFilesystemProfile("type=ext4;fsck=repair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Fsck != FsckRepair {
+		t.Fatalf("Expected %v but got %v", FsckRepair, p.Fsck)
+	}
+	if _, err := ParseFilesystemProfile("type=ext4;fsck=sometimes"); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestFsckTagChanges(t *testing.T) {
+	lv := lvm.LogicalVolumeReport{RawTags: "VN.test," + fsckTag(fsckErrors, time.Unix(1, 0))}
+	add, del := fsckTagChanges(lv, fsckRepaired)
+	if len(add) != 1 || !strings.HasPrefix(add[0], tagFsckPrefix+fsckRepaired+".") {
+		t.Fatalf("Unexpected tags to add %v", add)
+	}
+	if err := lvm.ValidateTag(add[0]); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"csilvm.fsck.errors.1"}; !reflect.DeepEqual(del, exp) {
+		t.Fatalf("Expected %v but got %v", exp, del)
+	}
+}
+
+func TestFilterKernelMessages(t *testing.T) {
+	output := []byte(`[ 1.0] XFS (dm-3): Mounting V5 Filesystem
+[ 2.0] XFS (dm-4): Mounting V5 Filesystem
+[ 3.0] XFS (dm-4): Corruption detected. Unmount and run xfs_repair
+[ 4.0] XFS (dm-4): log mount failed
+`)
+	lines := filterKernelMessages(output, "(dm-4)", 2)
+	exp := []string{
+		"[ 3.0] XFS (dm-4): Corruption detected. Unmount and run xfs_repair",
+		"[ 4.0] XFS (dm-4): log mount failed",
+	}
+	if !reflect.DeepEqual(lines, exp) {
+		t.Fatalf("Expected %v but got %v", exp, lines)
+	}
+}
+
+func TestFsckResult(t *testing.T) {
+	dirtyLog := []byte("ERROR: The filesystem has valuable metadata changes in a log which is being\nignored because the -n option was used.")
+	needsRecovery := []byte("Warning: skipping journal recovery because doing a read-only filesystem check.\n/dev/vg/lv: ********** WARNING: Filesystem still has errors **********")
+	for _, tc := range []struct {
+		fstype string
+		repair bool
+		code   int
+		output []byte
+		exp    string
+	}{
+		{"xfs", false, 0, nil, fsckClean},
+		{"xfs", false, 1, nil, fsckErrors},
+		{"xfs", false, 1, dirtyLog, fsckDirtyLog},
+		{"xfs", true, 0, nil, fsckRepaired},
+		{"xfs", true, 1, nil, fsckErrors},
+		{"xfs", true, 2, nil, fsckDirtyLog},
+		{"ext4", false, 0, nil, fsckClean},
+		{"ext4", false, 4, nil, fsckErrors},
+		{"ext4", false, 4, needsRecovery, fsckDirtyLog},
+		{"ext4", true, 0, nil, fsckClean},
+		{"ext4", true, 1, nil, fsckRepaired},
+		{"ext4", true, 4, nil, fsckErrors},
+	} {
+		result, err := fsckResult(tc.fstype, tc.repair, tc.code, tc.output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != tc.exp {
+			t.Fatalf("Expected %v for %v repair=%v exit code %d but got %v", tc.exp, tc.fstype, tc.repair, tc.code, result)
+		}
+	}
+	if _, err := fsckResult("xfs", false, 8, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+	if _, err := fsckResult("ext4", true, 8, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestIsDeviceMounted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-fsck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dev := filepath.Join(dir, "dm-4")
+	if err := ioutil.WriteFile(dev, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "lv")
+	if err := os.Symlink(dev, link); err != nil {
+		t.Fatal(err)
+	}
+	mounts := []mountpoint{{path: "/mnt/other", mountsource: "/dev/sda1"}}
+	if isDeviceMounted(link, mounts) {
+		t.Fatal("Expected the device not to be mounted")
+	}
+	for _, source := range []string{link, dev} {
+		mounts := append(mounts, mountpoint{path: "/mnt/target", mountsource: source})
+		if !isDeviceMounted(link, mounts) {
+			t.Fatalf("Expected the device mounted from %v to be mounted", source)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package csilvm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// growThresholdDivisor determines when a filesystem is grown: when the
+// device is larger than the filesystem by more than a tenth of the device.
+// Filesystems report less than the size of their device as some of it holds
+// metadata, such as the xfs log or the ext4 inode tables, so a smaller gap
+// does not mean that the device was extended.
+const growThresholdDivisor = 10
+
+// filesystemSize returns the size of the filesystem mounted at path as
+// reported by statfs.
+func filesystemSize(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), nil
+}
+
+// deviceSize returns the size of the block device.
+func deviceSize(devicePath string) (uint64, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(size), nil
+}
+
+// needsGrow returns true if a filesystem of fsSize bytes on a device of
+// devSize bytes should be grown.
+func needsGrow(fsSize, devSize uint64) bool {
+	return devSize > fsSize && devSize-fsSize > devSize/growThresholdDivisor
+}
+
+// growFilesystem grows the filesystem of the given type on the device,
+// mounted at mountPath, to the size of the device.
+func growFilesystem(fstype, devicePath, mountPath string) error {
+	var cmd *exec.Cmd
+	switch fstype {
+	case "xfs":
+		cmd = exec.Command("xfs_growfs", mountPath)
+	case "ext2", "ext3", "ext4":
+		cmd = exec.Command("resize2fs", devicePath)
+	default:
+		return errors.New("csilvm: growing " + fstype + " filesystems is not supported")
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New(cmd.Args[0] + " failed: err=" + err.Error() + ": " + string(output))
+	}
+	return nil
+}
+
+// growMountedFilesystem grows the filesystem on the device, mounted at
+// mountPath, if the device is larger than the filesystem. Failures are
+// logged but do not fail the publication of the volume.
+func (s *Server) growMountedFilesystem(fstype, devicePath, mountPath string) {
+	fsSize, err := filesystemSize(mountPath)
+	if err != nil {
+		log.Printf("Cannot determine the size of the filesystem at %v: err=%v", mountPath, err)
+		return
+	}
+	devSize, err := deviceSize(devicePath)
+	if err != nil {
+		log.Printf("Cannot determine the size of %v: err=%v", devicePath, err)
+		return
+	}
+	if !needsGrow(fsSize, devSize) {
+		return
+	}
+	log.Printf("Growing the %v filesystem at %v from %d bytes to fill %v of %d bytes", fstype, mountPath, fsSize, devicePath, devSize)
+	if err := growFilesystem(fstype, devicePath, mountPath); err != nil {
+		log.Printf("Cannot grow the filesystem at %v: err=%v", mountPath, err)
+		s.metrics.Counter("filesystem-grow-errors").Inc(1)
+		return
+	}
+	grown, err := filesystemSize(mountPath)
+	if err != nil {
+		grown = devSize
+	}
+	log.Printf("Grew the filesystem at %v from %d to %d bytes", mountPath, fsSize, grown)
+	s.metrics.Counter("filesystem-grows").Inc(1)
+}
@@ -0,0 +1,22 @@
+package csilvm
+
+import "testing"
+
+func TestNeedsGrow(t *testing.T) {
+	for _, tc := range []struct {
+		fsSize, devSize uint64
+		exp             bool
+	}{
+		// The metadata of a filesystem that fills its device.
+		{1014 << 20, 1 << 30, false},
+		{960 << 20, 1 << 30, false},
+		// The device was extended.
+		{1014 << 20, 2 << 30, true},
+		{1 << 30, 1 << 30, false},
+		{2 << 30, 1 << 30, false},
+	} {
+		if got := needsGrow(tc.fsSize, tc.devSize); got != tc.exp {
+			t.Fatalf("needsGrow(%d, %d): expected %v but got %v", tc.fsSize, tc.devSize, tc.exp, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package csilvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Seagate/csiclvm/pkg/cleanup"
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// tagKeyGenerationPrefix is followed by the generation of the key of an
+// encrypted volume. Volumes without such a tag are on generation 0.
+const tagKeyGenerationPrefix = tagStatePrefix + "key-gen."
+
+// keyGenerationTag returns the tag that records the key generation.
+func keyGenerationTag(generation uint64) string {
+	return tagKeyGenerationPrefix + strconv.FormatUint(generation, 10)
+}
+
+// volumeKeyGeneration returns the generation of the key of the encrypted
+// volume lv.
+func volumeKeyGeneration(lv lvm.LogicalVolumeReport) uint64 {
+	for _, tag := range lv.Tags() {
+		if !strings.HasPrefix(tag, tagKeyGenerationPrefix) {
+			continue
+		}
+		generation, err := strconv.ParseUint(strings.TrimPrefix(tag, tagKeyGenerationPrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		return generation
+	}
+	return 0
+}
+
+// EncryptedVolume is a volume created with the 'encrypted' parameter.
+type EncryptedVolume struct {
+	ID            string
+	KeyGeneration uint64
+}
+
+// ListEncryptedVolumes returns the encrypted volumes in the volume group,
+// ordered by ID.
+func ListEncryptedVolumes(ctx context.Context, vgname string) ([]EncryptedVolume, error) {
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return nil, err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []EncryptedVolume
+	for _, lv := range report.LogicalVolumesIn(vgname) {
+		if !isEncrypted(lv) {
+			continue
+		}
+		volumes = append(volumes, EncryptedVolume{ID: lv.Name, KeyGeneration: volumeKeyGeneration(lv)})
+	}
+	sort.Slice(volumes, func(i, j int) bool { return volumes[i].ID < volumes[j].ID })
+	return volumes, nil
+}
+
+// RotateVolumeKey replaces the current key of the encrypted volume with
+// newKey and records that the volume is on the given key generation. A
+// LUKS keyslot is added for the new key before the keyslot of the current
+// key is removed. The generation must be greater than the current one.
+//
+// The volume is activated exclusively, so that the rotation fails if it is
+// active on another node, and locked like an RPC for it would be, see
+// ShrinkVolume. An inactive volume is deactivated again afterwards.
+func RotateVolumeKey(ctx context.Context, vgname, volumeID, currentKey, newKey string, generation uint64) (err error) {
+	if currentKey == "" || newKey == "" {
+		return errors.New("csilvm: both the current and the new key are required")
+	}
+	unlock, err := lockVolume(volumeID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return err
+	}
+	lvr, err := report.LogicalVolume(vgname, volumeID)
+	if err != nil {
+		return err
+	}
+	if !isEncrypted(lvr) {
+		return fmt.Errorf("csilvm: volume %s is not encrypted", volumeID)
+	}
+	if current := volumeKeyGeneration(lvr); generation <= current {
+		return fmt.Errorf("csilvm: the key generation %d of volume %s must be greater than its current generation %d", generation, volumeID, current)
+	}
+	lv := vg.LogicalVolume(lvr)
+	log.Printf("Activating volume %s exclusively", volumeID)
+	if err := lv.ActivateExclusive(ctx); err != nil {
+		return fmt.Errorf("csilvm: cannot activate volume %s exclusively, it may be in use on another node: %v", volumeID, err)
+	}
+	if !lvr.Active() {
+		var deactivate cleanup.Rollback
+		deactivate.Add("activate volume "+volumeID, lv.Deactivate)
+		defer func() {
+			if derr := deactivate.Run(ctx); derr != nil && err == nil {
+				err = derr
+			}
+		}()
+	}
+	f, err := os.Open(lvr.Path)
+	if err != nil {
+		return err
+	}
+	fstype, err := probeFilesystemType(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if fstype != "crypto_LUKS" {
+		return fmt.Errorf("csilvm: volume %s has not been formatted with LUKS yet", volumeID)
+	}
+	log.Printf("Adding a keyslot for key generation %d to volume %s", generation, volumeID)
+	if err := luksAddKey(lvr.Path, currentKey, newKey); err != nil {
+		return err
+	}
+	log.Printf("Removing the keyslot of the previous key from volume %s", volumeID)
+	if err := cryptsetup(currentKey, "luksRemoveKey", "--batch-mode", "--key-file=-", lvr.Path); err != nil {
+		return err
+	}
+	var del []string
+	for _, tag := range lvr.Tags() {
+		if strings.HasPrefix(tag, tagKeyGenerationPrefix) {
+			del = append(del, tag)
+		}
+	}
+	return lv.ChangeTags(ctx, []string{keyGenerationTag(generation)}, del)
+}
+
+// luksAddKey adds a keyslot for newKey to the LUKS device, authorized by
+// key. The new key is passed through a pipe so that it is never written to
+// disk.
+func luksAddKey(devicePath, key, newKey string) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	// Keys are much smaller than the pipe buffer so the write does not
+	// block.
+	_, err = w.Write([]byte(newKey))
+	w.Close()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command("cryptsetup", "luksAddKey", "--batch-mode", "--key-file=-", devicePath, "/dev/fd/3")
+	cmd.Stdin = strings.NewReader(key)
+	cmd.ExtraFiles = []*os.File{r}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New("cryptsetup luksAddKey failed: err=" + err.Error() + ": " + string(output))
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package csilvm
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gofrs/flock"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// vgLockMode is how an RPC locks the volume group.
+type vgLockMode int
+
+const (
+	// vgUnlocked RPCs do not depend on the free space in the volume
+	// group.
+	vgUnlocked vgLockMode = iota
+	// vgShared RPCs observe or release free space and may run
+	// concurrently with each other.
+	vgShared
+	// vgExclusive RPCs allocate free space based on what they observed
+	// and must not run concurrently with any other capacity-sensitive RPC.
+	vgExclusive
+)
+
+// vgExclusiveWeight is the weight of the volume group semaphore. An
+// exclusive lock acquires all of it.
+const vgExclusiveWeight = 1 << 20
+
+// lockingPolicy returns the key of the volume the request operates on, if
+// any, and how it locks the volume group. CreateVolume is keyed by the
+// volume name chosen by the CO as the volume ID is not yet known.
+func lockingPolicy(req interface{}) (key string, mode vgLockMode) {
+	switch r := req.(type) {
+	case *csi.CreateVolumeRequest:
+		return "name:" + r.GetName(), vgExclusive
+	case *csi.ControllerExpandVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgExclusive
+	case *csi.DeleteVolumeRequest:
+		// Erasing a volume can take hours so DeleteVolume only
+		// shares the volume group lock while it runs lvremove, see
+		// withVolumeGroupLock.
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.GetCapacityRequest, *csi.ListVolumesRequest:
+		return "", vgShared
+	case *csi.ControllerPublishVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.ControllerUnpublishVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.NodeStageVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.NodeUnstageVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.NodePublishVolumeRequest:
+		// An ephemeral volume is created, and removed by
+		// NodeUnpublishVolume, under the volume group lock, see
+		// createEphemeralVolume and removeEphemeralVolume. The rest of
+		// the RPC, e.g., mkfs, does not block capacity-sensitive RPCs.
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.NodeUnpublishVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	case *csi.NodeExpandVolumeRequest:
+		return "id:" + r.GetVolumeId(), vgUnlocked
+	}
+	// Everything else, such as Probe or ValidateVolumeCapabilities, is
+	// read-only and runs concurrently with any other RPC.
+	return "", vgUnlocked
+}
+
+// volumeLocks tracks the volumes that have an operation in flight. The
+// volume of an operation keyed by its ID is also locked across processes
+// with a lock file next to the lvm lock file, so that the administrative
+// subcommands, such as shrink, and the plugin exclude each other.
+type volumeLocks struct {
+	mu       sync.Mutex
+	inflight map[string]*flock.Flock
+}
+
+// inflightVolumes is shared by the RPCs and the background tasks of the
+// plugin as well as the administrative functions.
+var inflightVolumes = &volumeLocks{inflight: make(map[string]*flock.Flock)}
+
+var errVolumeBusy = errors.New("csilvm: an operation on the volume is already in progress")
+
+// volumeLockPath returns the path of the lock file of the volume with the
+// given ID or the empty string if lvm locking is disabled.
+func volumeLockPath(id string) string {
+	path := lvm.LockFilePath()
+	if path == "" {
+		return ""
+	}
+	return path + "." + base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+// tryLock locks the key. It fails with errVolumeBusy if the key is already
+// locked, in this or another process.
+func (l *volumeLocks) tryLock(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.inflight[key]; ok {
+		return errVolumeBusy
+	}
+	var fl *flock.Flock
+	if id := strings.TrimPrefix(key, "id:"); id != key {
+		if path := volumeLockPath(id); path != "" {
+			fl = flock.New(path)
+			locked, err := fl.TryLock()
+			if err != nil {
+				return err
+			}
+			if !locked {
+				return errVolumeBusy
+			}
+		}
+	}
+	l.inflight[key] = fl
+	return nil
+}
+
+func (l *volumeLocks) unlock(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if fl := l.inflight[key]; fl != nil {
+		if err := fl.Unlock(); err != nil {
+			log.Printf("Cannot unlock %v: err=%v", fl.Path(), err)
+		}
+	}
+	delete(l.inflight, key)
+}
+
+// lockVolume locks the volume with the given ID as an RPC for it would. It
+// returns a function that unlocks it again.
+func lockVolume(id string) (unlock func(), err error) {
+	key := "id:" + id
+	if err := inflightVolumes.tryLock(key); err != nil {
+		return nil, fmt.Errorf("csilvm: cannot lock volume %s: %v", id, err)
+	}
+	return func() { inflightVolumes.unlock(key) }, nil
+}
+
+// VolumeLockingInterceptor allows RPCs to run concurrently unless they
+// operate on the same volume or compete for free space in the volume group.
+//
+// A request for a volume that already has an operation in flight fails
+// immediately with codes.Aborted, as recommended by the CSI spec, and the CO
+// is expected to retry it. Requests that depend on the free space in the
+// volume group wait for a reader/writer lock on it: CreateVolume holds it
+// exclusively while GetCapacity and ListVolumes share it. NodePublishVolume
+// holds it exclusively only while it creates an ephemeral volume, and
+// DeleteVolume and NodeUnpublishVolume share it only while they remove a
+// volume.
+//
+// It replaces SerializingInterceptor so that, for example, a slow mkfs in
+// NodePublishVolume no longer blocks Probe.
+func VolumeLockingInterceptor() grpc.UnaryServerInterceptor {
+	vglock := semaphore.NewWeighted(vgExclusiveWeight)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		key, mode := lockingPolicy(req)
+		if key != "" {
+			if err := inflightVolumes.tryLock(key); err == errVolumeBusy {
+				return nil, status.Errorf(codes.Aborted, "An operation on volume %s is already in progress", key)
+			} else if err != nil {
+				return nil, status.Errorf(codes.Internal, "Cannot lock volume %s: err=%v", key, err)
+			}
+			defer inflightVolumes.unlock(key)
+		}
+		if weight := mode.weight(); weight > 0 {
+			if err := vglock.Acquire(ctx, weight); err != nil {
+				return nil, status.FromContextError(err).Err()
+			}
+			defer vglock.Release(weight)
+		}
+		// Acquire can still succeed if the context is canceled, double-check it.
+		if err := ctx.Err(); err != nil {
+			return nil, status.FromContextError(err).Err()
+		}
+		return handler(context.WithValue(ctx, vgLockKey{}, vglock), req)
+	}
+}
+
+// weight returns how much of the volume group semaphore the mode acquires.
+func (mode vgLockMode) weight() int64 {
+	switch mode {
+	case vgShared:
+		return 1
+	case vgExclusive:
+		return vgExclusiveWeight
+	}
+	return 0
+}
+
+// vgLockKey is the context key of the volume group semaphore of
+// VolumeLockingInterceptor.
+type vgLockKey struct{}
+
+// withVolumeGroupLock calls fn while holding the volume group lock in the
+// given mode. It lets an RPC that runs unlocked, such as DeleteVolume, lock
+// the volume group just for the step that changes the free space. If the
+// RPC did not pass through VolumeLockingInterceptor fn is called directly.
+// If the context is done before the lock is acquired it fails with a status
+// error with the code of the context error.
+func withVolumeGroupLock(ctx context.Context, mode vgLockMode, fn func() error) error {
+	vglock, ok := ctx.Value(vgLockKey{}).(*semaphore.Weighted)
+	if !ok {
+		return fn()
+	}
+	weight := mode.weight()
+	if err := vglock.Acquire(ctx, weight); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	defer vglock.Release(weight)
+	if err := ctx.Err(); err != nil {
+		return status.FromContextError(err).Err()
+	}
+	return fn()
+}
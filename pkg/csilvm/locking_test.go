@@ -0,0 +1,240 @@
+package csilvm
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/gofrs/flock"
+	"golang.org/x/sync/semaphore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// blockInHandler calls the interceptor with a handler that blocks until
+// the returned release func is called. It returns once the handler has
+// been entered. release returns the error returned by the interceptor.
+func blockInHandler(li grpc.UnaryServerInterceptor, req interface{}) (release func() error) {
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := li(context.Background(), req, nil,
+			func(ctx context.Context, req interface{}) (interface{}, error) {
+				close(entered)
+				<-unblock
+				return nil, nil
+			})
+		done <- err
+	}()
+	<-entered
+	return func() error {
+		close(unblock)
+		return <-done
+	}
+}
+
+func noopHandler(ctx context.Context, req interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+func TestVolumeLockingInterceptor(t *testing.T) {
+	li := VolumeLockingInterceptor()
+	// A slow NodePublishVolume, e.g., one running mkfs.
+	release := blockInHandler(li, &csi.NodePublishVolumeRequest{VolumeId: "vol1"})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Operations on the same volume are rejected.
+	for _, req := range []interface{}{
+		&csi.NodePublishVolumeRequest{VolumeId: "vol1"},
+		&csi.NodeUnpublishVolumeRequest{VolumeId: "vol1"},
+		&csi.DeleteVolumeRequest{VolumeId: "vol1"},
+	} {
+		_, err := li(ctx, req, nil, noopHandler)
+		if status.Code(err) != codes.Aborted {
+			t.Fatalf("Expected Aborted for %T but got %v", req, err)
+		}
+	}
+	// Everything else proceeds.
+	for _, req := range []interface{}{
+		&csi.ProbeRequest{},
+		&csi.GetCapacityRequest{},
+		&csi.ValidateVolumeCapabilitiesRequest{VolumeId: "vol1"},
+		&csi.NodePublishVolumeRequest{VolumeId: "vol2"},
+		&csi.DeleteVolumeRequest{VolumeId: "vol2"},
+		&csi.CreateVolumeRequest{Name: "vol1"},
+	} {
+		if _, err := li(ctx, req, nil, noopHandler); err != nil {
+			t.Fatalf("Expected %T to proceed but got %v", req, err)
+		}
+	}
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	// The volume is unlocked once the operation completes.
+	if _, err := li(ctx, &csi.NodeUnpublishVolumeRequest{VolumeId: "vol1"}, nil, noopHandler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVolumeLockingInterceptorCapacity(t *testing.T) {
+	li := VolumeLockingInterceptor()
+	release := blockInHandler(li, &csi.CreateVolumeRequest{Name: "vol1"})
+	// Capacity-sensitive requests wait for CreateVolume to complete.
+	for _, req := range []interface{}{
+		&csi.CreateVolumeRequest{Name: "vol2"},
+		&csi.GetCapacityRequest{},
+		&csi.ListVolumesRequest{},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := li(ctx, req, nil, noopHandler)
+		cancel()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("Expected %T to wait but got %v", req, err)
+		}
+	}
+	// NodePublishVolume only waits when it creates an ephemeral volume,
+	// and DeleteVolume and NodeUnpublishVolume when they remove a volume.
+	ephemeral := &csi.NodePublishVolumeRequest{
+		VolumeId:      "vol4",
+		VolumeContext: map[string]string{volumeContextEphemeral: "true"},
+	}
+	for req, mode := range map[interface{}]vgLockMode{
+		ephemeral: vgExclusive,
+		&csi.DeleteVolumeRequest{VolumeId: "vol3"}:        vgShared,
+		&csi.NodeUnpublishVolumeRequest{VolumeId: "vol4"}: vgShared,
+	} {
+		mode := mode
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, withVolumeGroupLock(ctx, mode, func() error { return nil })
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := li(ctx, req, nil, handler)
+		cancel()
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Fatalf("Expected %T to wait but got %v", req, err)
+		}
+	}
+	// Others do not.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, req := range []interface{}{
+		&csi.NodePublishVolumeRequest{VolumeId: "vol3"},
+		ephemeral,
+		&csi.DeleteVolumeRequest{VolumeId: "vol3"},
+		&csi.NodeUnpublishVolumeRequest{VolumeId: "vol4"},
+	} {
+		if _, err := li(ctx, req, nil, noopHandler); err != nil {
+			t.Fatalf("Expected %T to proceed but got %v", req, err)
+		}
+	}
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := li(ctx, &csi.GetCapacityRequest{}, nil, noopHandler); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVolumeLockingInterceptorSharedCapacity(t *testing.T) {
+	li := VolumeLockingInterceptor()
+	release := blockInHandler(li, &csi.GetCapacityRequest{})
+	defer func() {
+		if err := release(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := li(ctx, &csi.ListVolumesRequest{}, nil, noopHandler); err != nil {
+		t.Fatalf("Expected ListVolumes to share the volume group lock but got %v", err)
+	}
+	short, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := li(short, &csi.CreateVolumeRequest{Name: "vol1"}, nil, noopHandler); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("Expected CreateVolume to wait but got %v", err)
+	}
+}
+
+func TestVolumeLockingInterceptorCanceled(t *testing.T) {
+	li := VolumeLockingInterceptor()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		calls++
+		return nil, nil
+	}
+	for _, req := range []interface{}{
+		&csi.CreateVolumeRequest{Name: "vol1"},
+		&csi.GetCapacityRequest{},
+	} {
+		if _, err := li(ctx, req, nil, handler); status.Code(err) != codes.Canceled {
+			t.Fatalf("Expected Canceled for %T but got %v", req, err)
+		}
+	}
+	if calls != 0 {
+		t.Fatalf("expected %d calls instead of %d", 0, calls)
+	}
+	// An RPC that locks the volume group itself, e.g., DeleteVolume, fails
+	// with the code of the context error too.
+	vgctx := context.WithValue(ctx, vgLockKey{}, semaphore.NewWeighted(vgExclusiveWeight))
+	err := withVolumeGroupLock(vgctx, vgShared, func() error {
+		calls++
+		return nil
+	})
+	if status.Code(err) != codes.Canceled {
+		t.Fatalf("Expected Canceled but got %v", err)
+	}
+	if calls != 0 {
+		t.Fatalf("expected %d calls instead of %d", 0, calls)
+	}
+}
+
+func TestVolumeLockingInterceptorLockFile(t *testing.T) {
+	file, err := ioutil.TempFile("", "csilvm-test-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	lvm.SetLockFilePath(file.Name())
+	li := VolumeLockingInterceptor()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Another process, such as `csilvm shrink`, holds the lock file of
+	// the volume.
+	other := flock.New(volumeLockPath("vol1"))
+	defer os.Remove(other.Path())
+	if locked, err := other.TryLock(); err != nil || !locked {
+		t.Fatalf("Cannot lock %v: %v", other.Path(), err)
+	}
+	_, err = li(ctx, &csi.NodePublishVolumeRequest{VolumeId: "vol1"}, nil, noopHandler)
+	if status.Code(err) != codes.Aborted {
+		t.Fatalf("Expected Aborted but got %v", err)
+	}
+	if err := other.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	// The plugin holds the lock file while the RPC is in flight.
+	release := blockInHandler(li, &csi.NodePublishVolumeRequest{VolumeId: "vol1"})
+	if locked, err := other.TryLock(); err != nil || locked {
+		t.Fatalf("Expected the lock file to be held but got %v, %v", locked, err)
+	}
+	if _, err := lockVolume("vol1"); err == nil {
+		t.Fatal("Expected lockVolume to fail")
+	}
+	if err := release(); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockVolume("vol1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+}
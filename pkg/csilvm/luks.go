@@ -0,0 +1,165 @@
+package csilvm
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+const (
+	// paramEncrypted requests an encrypted volume when "true". It is
+	// returned as a volume attribute of the same name.
+	paramEncrypted = "encrypted"
+	// secretEncryptionPassphrase is the node publish secret that holds
+	// the passphrase of an encrypted volume.
+	secretEncryptionPassphrase = "encryptionPassphrase"
+	// tagEncrypted marks a volume that is formatted with LUKS on first
+	// use.
+	tagEncrypted = tagStatePrefix + "encrypted"
+)
+
+// mapperDir is where device-mapper creates the devices of open LUKS
+// volumes.
+var mapperDir = "/dev/mapper"
+
+// takeEncryptedFromParameters removes the 'encrypted' parameter from the
+// input and returns whether an encrypted volume was requested.
+func takeEncryptedFromParameters(params map[string]string) (bool, error) {
+	value, ok := params[paramEncrypted]
+	if !ok {
+		return false, nil
+	}
+	delete(params, paramEncrypted)
+	encrypted, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("The 'encrypted' parameter must be 'true' or 'false'")
+	}
+	return encrypted, nil
+}
+
+// isEncrypted returns true if the volume is encrypted.
+func isEncrypted(lv lvm.LogicalVolumeReport) bool {
+	return lv.HasTag(tagEncrypted)
+}
+
+// luksMapping returns the device-mapper name of the open LUKS volume with
+// the given ID.
+func luksMapping(volumeID string) string {
+	return "csilvm-" + volumeID
+}
+
+// luksMapperPath returns the path of the device of the open LUKS volume
+// with the given ID. The device exists only while the volume is open.
+func luksMapperPath(volumeID string) string {
+	return filepath.Join(mapperDir, luksMapping(volumeID))
+}
+
+// isLUKSOpen returns true if the LUKS volume with the given ID is open.
+func isLUKSOpen(volumeID string) bool {
+	_, err := os.Stat(luksMapperPath(volumeID))
+	return err == nil
+}
+
+// openLUKSVolume opens the encrypted volume lv and returns the path of the
+// device that holds its contents. A volume without a LUKS header is
+// formatted with LUKS first. It fails if the volume contains anything
+// else, so that an unencrypted filesystem is never overwritten, or if it is
+// already open in the other of read-only and read-write mode.
+func openLUKSVolume(lv lvm.LogicalVolumeReport, passphrase string, readonly bool) (string, error) {
+	mapperPath := luksMapperPath(lv.Name)
+	if isLUKSOpen(lv.Name) {
+		log.Printf("LUKS volume %v is already open at %v", lv.Name, mapperPath)
+		// The mapping is opened read-only or read-write for all of
+		// the publications of the volume.
+		open, err := isDeviceReadonly(mapperPath)
+		if err != nil {
+			return "", status.Errorf(codes.Internal, "Cannot determine whether the LUKS volume is read-only: err=%v", err)
+		}
+		if open != readonly {
+			return "", status.Errorf(
+				codes.FailedPrecondition,
+				"The LUKS volume is already open with readonly=%v",
+				open)
+		}
+		return mapperPath, nil
+	}
+	if passphrase == "" {
+		return "", status.Errorf(
+			codes.InvalidArgument,
+			"The %s secret is required to publish an encrypted volume",
+			secretEncryptionPassphrase)
+	}
+	// Signatures that probeFilesystemType does not know, such as a
+	// partition table, are looked up with blkid so that they are not
+	// formatted over.
+	fstype, err := determineFilesystemType(lv.Path)
+	if err != nil {
+		return "", status.Errorf(codes.Internal, "Cannot determine filesystem type: err=%v", err)
+	}
+	switch fstype {
+	case "crypto_LUKS":
+	case "":
+		log.Printf("Formatting %v with LUKS", lv.Path)
+		if err := cryptsetup(passphrase, "luksFormat", "--batch-mode", "--key-file=-", lv.Path); err != nil {
+			return "", status.Errorf(codes.Internal, "Cannot format volume with LUKS: err=%v", err)
+		}
+	default:
+		return "", status.Errorf(
+			codes.FailedPrecondition,
+			"The encrypted volume contains an unencrypted %v filesystem",
+			fstype)
+	}
+	args := []string{"luksOpen", "--key-file=-"}
+	if readonly {
+		args = append(args, "--readonly")
+	}
+	args = append(args, lv.Path, luksMapping(lv.Name))
+	log.Printf("Opening LUKS volume %v at %v", lv.Path, mapperPath)
+	if err := cryptsetup(passphrase, args...); err != nil {
+		return "", status.Errorf(codes.FailedPrecondition, "Cannot open LUKS volume: err=%v", err)
+	}
+	return mapperPath, nil
+}
+
+// closeLUKSVolume closes the LUKS volume with the given ID, if it is open.
+func closeLUKSVolume(volumeID string) error {
+	if !isLUKSOpen(volumeID) {
+		return nil
+	}
+	log.Printf("Closing LUKS volume %v", volumeID)
+	return cryptsetup("", "luksClose", luksMapping(volumeID))
+}
+
+// cryptsetup runs cryptsetup with the given arguments and key on stdin.
+func cryptsetup(key string, args ...string) error {
+	cmd := exec.Command("cryptsetup", args...)
+	cmd.Stdin = bytes.NewBufferString(key)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.New("cryptsetup " + args[0] + " failed: err=" + err.Error() + ": " + string(output))
+	}
+	return nil
+}
+
+// volumeFilesystemType returns the type of the filesystem on the volume or
+// the empty string if it has none. The filesystem of an encrypted volume
+// can only be determined while it is open. Otherwise the empty string is
+// returned as well.
+func volumeFilesystemType(lv lvm.LogicalVolumeReport) (string, error) {
+	if !isEncrypted(lv) {
+		return determineFilesystemType(lv.Path)
+	}
+	if !isLUKSOpen(lv.Name) {
+		log.Printf("Encrypted volume %v is not open, its filesystem type is unknown", lv.Name)
+		return "", nil
+	}
+	return determineFilesystemType(luksMapperPath(lv.Name))
+}
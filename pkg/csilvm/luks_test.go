@@ -0,0 +1,101 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+	"google.golang.org/grpc/codes"
+)
+
+func TestTakeEncryptedFromParameters(t *testing.T) {
+	for value, exp := range map[string]bool{"true": true, "false": false, "1": true} {
+		params := map[string]string{paramEncrypted: value}
+		encrypted, err := takeEncryptedFromParameters(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted != exp {
+			t.Fatalf("Expected %v for %q", exp, value)
+		}
+		if len(params) != 0 {
+			t.Fatalf("Expected the parameter to be consumed but got %v", params)
+		}
+	}
+	if _, err := takeEncryptedFromParameters(map[string]string{paramEncrypted: "yes please"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestVolumeFilesystemTypeEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { mapperDir = old }(mapperDir)
+	mapperDir = dir
+	// The volume itself has a LUKS header.
+	lvpath := filepath.Join(dir, "lv")
+	if err := ioutil.WriteFile(lvpath, image(1<<20, 0, "LUKS\xba\xbe"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lv := lvm.LogicalVolumeReport{Name: "csilvtest", Path: lvpath, RawTags: tagEncrypted}
+	fstype, err := volumeFilesystemType(lv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fstype != "" {
+		t.Fatalf("Expected an unknown filesystem while the volume is closed but got %q", fstype)
+	}
+	// Once open the filesystem inside is detected.
+	if err := ioutil.WriteFile(luksMapperPath(lv.Name), image(1<<20, 0, "XFSB"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fstype, err = volumeFilesystemType(lv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fstype != "xfs" {
+		t.Fatalf("Expected xfs but got %q", fstype)
+	}
+}
+
+func TestOpenLUKSVolumeUnknownSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-luks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(old string) { mapperDir = old }(mapperDir)
+	mapperDir = dir
+	// blkid knows a signature that probeFilesystemType does not and
+	// cryptsetup records that it ran.
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ran := filepath.Join(dir, "cryptsetup-ran")
+	for name, script := range map[string]string{
+		"blkid":      "#!/bin/sh\necho TYPE=vfat\n",
+		"cryptsetup": "#!/bin/sh\ntouch " + ran + "\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(bin, name), []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	lvpath := filepath.Join(dir, "lv")
+	if err := ioutil.WriteFile(lvpath, image(1<<20, 0x36, "FAT16   "), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lv := lvm.LogicalVolumeReport{Name: "csilvtest", Path: lvpath, RawTags: tagEncrypted}
+	_, err = openLUKSVolume(lv, "secret", false)
+	checkCode(t, err, codes.FailedPrecondition)
+	if _, err := os.Stat(ran); err == nil {
+		t.Fatal("Expected the volume not to be formatted")
+	}
+}
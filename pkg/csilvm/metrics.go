@@ -65,23 +65,29 @@ func (s *Server) ReportUptime() context.CancelFunc {
 // operations (specifically lvs concurrent with lvcreate) triggering latent
 // issues we've run into this should probably not be called concurrently with
 // other RPCs.
-func (s *Server) reportStorageMetrics() {
-	// Report the number of volumes
-	volNames, err := s.volumeGroup.ListLogicalVolumeNames()
+func (s *Server) reportStorageMetrics(ctx context.Context) {
+	report, err := s.volumeGroup.Report(ctx)
 	if err != nil {
-		log.Printf("failed to report metrics: cannot load lv names: err=%v", err)
+		log.Printf("failed to report metrics: cannot report on volume group: err=%v", err)
 		return
 	}
-	s.metrics.Gauge("volumes").Update(float64(len(volNames)))
-	// Report the total bytes free for the volume group.
-	bytesTotal, err := s.volumeGroup.BytesTotal()
+	s.reportStorageMetricsFrom(report)
+}
+
+// reportStorageMetricsFrom sets the same gauges as reportStorageMetrics
+// using an existing report of the volume group.
+func (s *Server) reportStorageMetricsFrom(report *lvm.Report) {
+	vg, err := report.VolumeGroup(s.vgname)
 	if err != nil {
-		log.Printf("failed to report metrics: cannot read total bytes: err=%v", err)
+		log.Printf("failed to report metrics: err=%v", err)
 		return
 	}
-	s.metrics.Gauge("bytes-total").Update(float64(bytesTotal))
+	// Report the number of volumes
+	s.metrics.Gauge("volumes").Update(float64(len(report.LogicalVolumesIn(s.vgname))))
+	// Report the total bytes free for the volume group.
+	s.metrics.Gauge("bytes-total").Update(float64(vg.Size))
 	// Report the number of bytes free for the volume group.
-	bytesFree, err := s.volumeGroup.BytesFree(lvm.VolumeLayout{
+	bytesFree, err := report.BytesFree(s.vgname, lvm.VolumeLayout{
 		Type: lvm.VolumeTypeLinear,
 	})
 	if err != nil {
@@ -90,5 +96,5 @@ func (s *Server) reportStorageMetrics() {
 	}
 	s.metrics.Gauge("bytes-free").Update(float64(bytesFree))
 	// Report the number of bytes used.
-	s.metrics.Gauge("bytes-used").Update(float64(bytesTotal - bytesFree))
+	s.metrics.Gauge("bytes-used").Update(float64(vg.Size - bytesFree))
 }
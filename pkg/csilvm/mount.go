@@ -36,6 +36,7 @@ type mountpoint struct {
 	fstype      string
 	mountopts   []string
 	mountsource string
+	superopts   []string
 }
 
 func (m *mountpoint) isReadonly() bool {
@@ -47,6 +48,17 @@ func (m *mountpoint) isReadonly() bool {
 	return false
 }
 
+// hasSuperOption returns true if the filesystem was mounted with the given
+// option, e.g., "discard".
+func (m *mountpoint) hasSuperOption(opt string) bool {
+	for _, o := range m.superopts {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
 func listMounts() (mounts []mountpoint, err error) {
 	buf, err := ioutil.ReadFile("/proc/self/mountinfo")
 	if err != nil {
@@ -81,6 +93,9 @@ func parseMountinfo(buf []byte) (mounts []mountpoint, err error) {
 			mountopts:   strings.Split(fields[5], ","),
 			mountsource: fields[sepoffset+2],
 		}
+		if len(fields) > sepoffset+3 {
+			mount.superopts = strings.Split(fields[sepoffset+3], ",")
+		}
 		mounts = append(mounts, mount)
 	}
 	return mounts, nil
@@ -18,6 +18,7 @@ func TestParseMountinfoOptionalFields(t *testing.T) {
 			fstype:      "ext3",
 			mountopts:   []string{"rw", "noatime"},
 			mountsource: "/dev/root",
+			superopts:   []string{"rw", "errors=continue"},
 		},
 	}
 	if !reflect.DeepEqual(mounts, exp) {
@@ -38,6 +39,7 @@ func TestParseMountinfoNoOptionalFields(t *testing.T) {
 			fstype:      "xfs",
 			mountopts:   []string{"rw", "relatime"},
 			mountsource: "/mnt/volume-1",
+			superopts:   []string{"rw", "seclabel", "attr2", "inode64", "noquota"},
 		},
 	}
 	if !reflect.DeepEqual(mounts, exp) {
@@ -0,0 +1,73 @@
+package csilvm
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// gidMountOptionFilesystems are the filesystems that have no ownership of
+// their own and are given the mount group with the gid= mount option.
+var gidMountOptionFilesystems = map[string]bool{
+	"vfat":  true,
+	"msdos": true,
+	"exfat": true,
+	"ntfs":  true,
+	"udf":   true,
+}
+
+// volumeMountGroup returns the group that the CO asked to be given access
+// to the filesystem in the volume_mount_group field, or -1 if none. The
+// group must be a numeric ID.
+func volumeMountGroup(capability *csi.VolumeCapability) (int, error) {
+	value := capability.GetMount().GetVolumeMountGroup()
+	if value == "" {
+		return -1, nil
+	}
+	gid, err := strconv.ParseUint(value, 10, 31)
+	if err != nil {
+		return -1, fmt.Errorf("The volume_mount_group field must be a numeric group ID but got %q", value)
+	}
+	return int(gid), nil
+}
+
+// mountGroupOptions returns the mount options that give the group access
+// to a filesystem of the given type, if it supports them.
+func mountGroupOptions(fstype string, gid int) []string {
+	if !gidMountOptionFilesystems[fstype] {
+		return nil
+	}
+	return []string{"gid=" + strconv.Itoa(gid)}
+}
+
+// mountGroupMode are the permission bits added to the root of the
+// filesystem: the setgid bit, so that new files belong to the group, and
+// read, write and search permission for the group.
+const mountGroupMode = os.ModeSetgid | 0070
+
+// applyMountGroup makes the group own the root of the filesystem mounted at
+// path and sets mountGroupMode on it. Only the root is changed, which is
+// enough for a fresh filesystem. It does nothing if the root is already set
+// up this way.
+func applyMountGroup(path string, gid int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("csilvm: cannot determine the owner of %v", path)
+	}
+	if int(st.Gid) == gid && info.Mode()&mountGroupMode == mountGroupMode {
+		log.Printf("The root of the filesystem at %v already belongs to group %d", path, gid)
+		return nil
+	}
+	log.Printf("Changing the group of the root of the filesystem at %v to %d", path, gid)
+	if err := os.Lchown(path, -1, gid); err != nil {
+		return err
+	}
+	return os.Chmod(path, info.Mode()|mountGroupMode)
+}
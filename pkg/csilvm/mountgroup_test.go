@@ -0,0 +1,68 @@
+package csilvm
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+func TestVolumeMountGroup(t *testing.T) {
+	capability := func(group string) *csi.VolumeCapability {
+		return &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: group},
+			},
+		}
+	}
+	for group, exp := range map[string]int{"": -1, "2000": 2000} {
+		gid, err := volumeMountGroup(capability(group))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gid != exp {
+			t.Fatalf("Expected %d for %q but got %d", exp, group, gid)
+		}
+	}
+	for _, group := range []string{"wheel", "-1", "4294967296"} {
+		if _, err := volumeMountGroup(capability(group)); err == nil {
+			t.Fatalf("Expected an error for %q", group)
+		}
+	}
+}
+
+func TestMountGroupOptions(t *testing.T) {
+	if opts := mountGroupOptions("xfs", 2000); opts != nil {
+		t.Fatalf("Expected no options for xfs but got %v", opts)
+	}
+	if opts, exp := mountGroupOptions("vfat", 2000), []string{"gid=2000"}; !reflect.DeepEqual(opts, exp) {
+		t.Fatalf("Expected %v but got %v", exp, opts)
+	}
+}
+
+func TestApplyMountGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "csilvm-mount-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// Any user may give their own group to their files.
+	if err := applyMountGroup(dir, os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode(); mode&mountGroupMode != mountGroupMode || mode.Perm() != 0770 {
+		t.Fatalf("Unexpected mode %v", mode)
+	}
+	if err := applyMountGroup(dir, os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+}
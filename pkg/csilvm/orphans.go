@@ -0,0 +1,109 @@
+package csilvm
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// OrphanedVolume is a volume created by CreateVolume that the CO does not
+// know about, e.g., as the CO gave up on the CreateVolume RPC or lost
+// track of the volume.
+type OrphanedVolume struct {
+	ID   string
+	Size uint64
+	// Created is the zero time for volumes created before creation
+	// times were recorded.
+	Created time.Time
+	// PVCName and PVCNamespace identify the persistent volume claim
+	// the volume was created for, if the CO passed them to
+	// CreateVolume.
+	PVCName      string
+	PVCNamespace string
+}
+
+// Age returns how long ago the volume was created or zero if that is
+// unknown.
+func (v OrphanedVolume) Age(now time.Time) time.Duration {
+	if v.Created.IsZero() {
+		return 0
+	}
+	return now.Sub(v.Created)
+}
+
+// FindOrphanedVolumes returns the volumes in the volume group that were
+// created by CreateVolume and whose IDs are not in known, ordered by ID.
+//
+// Volumes that are still being created are not orphaned: the instance
+// creating them removes them when it restarts should it fail. Neither are
+// ephemeral volumes, which are removed by the node that created them.
+func FindOrphanedVolumes(ctx context.Context, vgname string, known []string) ([]OrphanedVolume, error) {
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return nil, err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return nil, err
+	}
+	isKnown := make(map[string]bool, len(known))
+	for _, id := range known {
+		isKnown[id] = true
+	}
+	var orphans []OrphanedVolume
+	for _, lv := range report.LogicalVolumesIn(vgname) {
+		if !strings.HasPrefix(lv.Name, lvPrefix) {
+			continue
+		}
+		if isKnown[lv.Name] || isCreating(lv) || isEphemeral(lv) {
+			continue
+		}
+		created, _ := volumeCreationTime(lv)
+		metadata := volumeMetadata(lv.Tags())
+		orphans = append(orphans, OrphanedVolume{
+			ID:           lv.Name,
+			Size:         lv.Size,
+			Created:      created,
+			PVCName:      metadata[paramPVCName],
+			PVCNamespace: metadata[paramPVCNamespace],
+		})
+	}
+	sort.Slice(orphans, func(i, j int) bool { return orphans[i].ID < orphans[j].ID })
+	return orphans, nil
+}
+
+// RemoveOrphanedVolume removes the volume with the given ID, as returned by
+// FindOrphanedVolumes, from the volume group. Its data is first erased
+// according to its erase policy or, if it was created without one, def,
+// like DeleteVolume does. The volume is locked like an RPC for it would be,
+// see ShrinkVolume.
+func RemoveOrphanedVolume(ctx context.Context, vgname, id string, def ErasePolicy) error {
+	unlock, err := lockVolume(id)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return err
+	}
+	lvr, err := report.LogicalVolume(vgname, id)
+	if err != nil {
+		return err
+	}
+	lv := vg.LogicalVolume(lvr)
+	if policy := volumeErasePolicy(lvr, def); policy != EraseNone {
+		if err := eraseVolume(ctx, lv, lvr, policy); err != nil {
+			return err
+		}
+	}
+	log.Printf("Removing orphaned volume %s", id)
+	return lv.Remove(ctx)
+}
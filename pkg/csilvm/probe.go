@@ -0,0 +1,161 @@
+package csilvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// probeSize is how much of the device probeFilesystemType reads. It covers
+// the btrfs superblock at 64KiB and swap signatures for pages of up to
+// 64KiB.
+const probeSize = 128 << 10
+
+// ext2/3/4 superblock fields and feature flags, see linux/fs/ext4/ext4.h.
+const (
+	extSuperblockOffset   = 1024
+	extMagicOffset        = extSuperblockOffset + 0x38
+	extFeatureCompat      = extSuperblockOffset + 0x5c
+	extFeatureIncompat    = extSuperblockOffset + 0x60
+	extFeatureROCompat    = extSuperblockOffset + 0x64
+	extMagic              = 0xef53
+	extCompatHasJournal   = 0x4
+	extIncompatJournalDev = 0x8
+	// The features supported by ext3. A filesystem with any other
+	// feature is ext4.
+	ext3IncompatSupported = 0x2 | 0x4 | 0x10
+	ext3ROCompatSupported = 0x1 | 0x2 | 0x4
+)
+
+// signature is a magic string at a fixed offset that identifies a
+// filesystem or other content of a device. The types are named as by
+// blkid.
+type signature struct {
+	fstype string
+	offset int
+	magic  string
+}
+
+var signatures = []signature{
+	{"crypto_LUKS", 0, "LUKS\xba\xbe"},
+	{"xfs", 0, "XFSB"},
+	{"btrfs", 64<<10 + 0x40, "_BHRfS_M"},
+	// LVM2 labels can be in any of the first four sectors.
+	{"LVM2_member", 0, "LABELONE"},
+	{"LVM2_member", 512, "LABELONE"},
+	{"LVM2_member", 1024, "LABELONE"},
+	{"LVM2_member", 1536, "LABELONE"},
+	// The swap signature is at the end of the first page.
+	{"swap", 4096 - 10, "SWAPSPACE2"},
+	{"swap", 4096 - 10, "SWAP-SPACE"},
+	{"swap", 8192 - 10, "SWAPSPACE2"},
+	{"swap", 16384 - 10, "SWAPSPACE2"},
+	{"swap", 65536 - 10, "SWAPSPACE2"},
+}
+
+// probeFilesystemType returns the type of the filesystem, or of the other
+// known content such as a LUKS or LVM2 header, at the start of the device.
+// It returns the empty string if none is found.
+func probeFilesystemType(r io.ReaderAt) (string, error) {
+	buf := make([]byte, probeSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = buf[:n]
+	for _, sig := range signatures {
+		end := sig.offset + len(sig.magic)
+		if end <= len(buf) && string(buf[sig.offset:end]) == sig.magic {
+			return sig.fstype, nil
+		}
+	}
+	if len(buf) >= extFeatureROCompat+4 && binary.LittleEndian.Uint16(buf[extMagicOffset:]) == extMagic {
+		compat := binary.LittleEndian.Uint32(buf[extFeatureCompat:])
+		incompat := binary.LittleEndian.Uint32(buf[extFeatureIncompat:])
+		rocompat := binary.LittleEndian.Uint32(buf[extFeatureROCompat:])
+		switch {
+		case incompat&extIncompatJournalDev != 0:
+			return "jbd", nil
+		case incompat&^ext3IncompatSupported != 0, rocompat&^ext3ROCompatSupported != 0:
+			return "ext4", nil
+		case compat&extCompatHasJournal != 0:
+			return "ext3", nil
+		}
+		return "ext2", nil
+	}
+	return "", nil
+}
+
+// determineFilesystemType returns the type of the filesystem on the device
+// or the empty string if there is none. Signatures that are not known to
+// probeFilesystemType are looked up with blkid. Without blkid the device is
+// only considered empty if the probed region is zeroed.
+func determineFilesystemType(devicePath string) (string, error) {
+	f, err := os.Open(devicePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fstype, err := probeFilesystemType(f)
+	if err != nil || fstype != "" {
+		return fstype, err
+	}
+	// We do *not* use `lsblk` as that requires udev to be up-to-date which
+	// is often not the case when a device is erased using `dd`.
+	output, err := exec.Command("blkid", "-p", "-o", "export", devicePath).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 2 {
+			// blkid found nothing either.
+			return "", nil
+		}
+		if errors.Is(err, exec.ErrNotFound) {
+			// Without blkid the device is only known to be
+			// empty if nothing at all was written to it.
+			zeroed, zerr := isZeroed(f)
+			if zerr != nil {
+				return "", zerr
+			}
+			if !zeroed {
+				return "", errors.New("csilvm: the device has unknown content and blkid, which could identify it, is not installed")
+			}
+			log.Printf("Cannot run blkid, %v is zeroed so it has no filesystem: err=%v", devicePath, err)
+			return "", nil
+		}
+		return "", err
+	}
+	return parseBlkidType(output)
+}
+
+// isZeroed returns true if the region of the device read by
+// probeFilesystemType contains only zeros.
+func isZeroed(r io.ReaderAt) (bool, error) {
+	buf := make([]byte, probeSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	for _, b := range buf[:n] {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// parseBlkidType returns the value of TYPE in the output of `blkid -o
+// export`. It returns an error if blkid found something other than a
+// filesystem, such as a partition table, so that it is not overwritten.
+func parseBlkidType(output []byte) (string, error) {
+	for _, line := range strings.Split(string(output), "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(kv) == 2 && kv[0] == "TYPE" {
+			return kv[1], nil
+		}
+	}
+	return "", errors.New("blkid found no filesystem type: " + string(bytes.TrimSpace(output)))
+}
@@ -0,0 +1,95 @@
+package csilvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// image returns a zeroed device image with magic written at offset.
+func image(size int, offset int, magic string) []byte {
+	buf := make([]byte, size)
+	copy(buf[offset:], magic)
+	return buf
+}
+
+// extImage returns a device image with an ext superblock with the given
+// feature flags.
+func extImage(compat, incompat, rocompat uint32) []byte {
+	buf := make([]byte, 1<<20)
+	binary.LittleEndian.PutUint16(buf[extMagicOffset:], extMagic)
+	binary.LittleEndian.PutUint32(buf[extFeatureCompat:], compat)
+	binary.LittleEndian.PutUint32(buf[extFeatureIncompat:], incompat)
+	binary.LittleEndian.PutUint32(buf[extFeatureROCompat:], rocompat)
+	return buf
+}
+
+func TestProbeFilesystemType(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  []byte
+		exp  string
+	}{
+		{"empty", make([]byte, 1<<20), ""},
+		{"tiny", make([]byte, 100), ""},
+		{"xfs", image(1<<20, 0, "XFSB"), "xfs"},
+		{"btrfs", image(1<<20, 0x10040, "_BHRfS_M"), "btrfs"},
+		{"luks", image(1<<20, 0, "LUKS\xba\xbe"), "crypto_LUKS"},
+		{"lvm2", image(1<<20, 512, "LABELONE"), "LVM2_member"},
+		{"swap", image(1<<20, 4086, "SWAPSPACE2"), "swap"},
+		{"swap 64k pages", image(1<<20, 65526, "SWAPSPACE2"), "swap"},
+		{"ext2", extImage(0x38, 0x2, 0x3), "ext2"},
+		{"ext3", extImage(0x3c, 0x6, 0x3), "ext3"},
+		// extents, 64bit and flex_bg
+		{"ext4", extImage(0x3c, 0x2c2, 0x3), "ext4"},
+		// huge_file and metadata_csum
+		{"ext4 ro_compat", extImage(0x3c, 0x2, 0x40b), "ext4"},
+		{"jbd", extImage(0x0, 0x8, 0x0), "jbd"},
+	}
+	for _, tt := range tests {
+		got, err := probeFilesystemType(bytes.NewReader(tt.dev))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.exp {
+			t.Errorf("%s: expected %q but got %q", tt.name, tt.exp, got)
+		}
+	}
+}
+
+func TestIsZeroed(t *testing.T) {
+	tests := []struct {
+		name string
+		dev  []byte
+		exp  bool
+	}{
+		{"empty", make([]byte, 1<<20), true},
+		{"tiny", make([]byte, 100), true},
+		{"unknown", image(1<<20, 4096, "\x01"), false},
+		// Data beyond the probed region is not read.
+		{"beyond", image(1<<20, probeSize, "\x01"), true},
+	}
+	for _, tt := range tests {
+		got, err := isZeroed(bytes.NewReader(tt.dev))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.exp {
+			t.Errorf("%s: expected %v but got %v", tt.name, tt.exp, got)
+		}
+	}
+}
+
+func TestParseBlkidType(t *testing.T) {
+	output := "DEVNAME=/dev/vg/lv\nUUID=0f4e3f5e-3c6a-4c4e-9d0a-2b1e4a5b6c7d\nBLOCK_SIZE=512\nTYPE=vfat\nLABEL=a=b\n\n"
+	fstype, err := parseBlkidType([]byte(output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fstype != "vfat" {
+		t.Fatalf("Expected vfat but got %q", fstype)
+	}
+	if _, err := parseBlkidType([]byte("DEVNAME=/dev/vg/lv\nPTTYPE=gpt\n")); err == nil {
+		t.Fatal("Expected an error for a partition table")
+	}
+}
@@ -0,0 +1,112 @@
+package csilvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FilesystemProfile describes how volumes with a filesystem are formatted
+// and mounted.
+type FilesystemProfile struct {
+	// Name identifies the profile in the fsProfile volume parameter. A
+	// profile named after its filesystem type applies to all volumes of
+	// that type that do not select a profile.
+	Name string
+	// FsType is the filesystem type passed to mkfs and mount.
+	FsType string
+	// MkfsArgs are passed to mkfs before the device, e.g., ["-m", "0"].
+	MkfsArgs []string
+	// MountOptions are applied in addition to the mount flags requested
+	// by the CO, e.g., ["noatime"].
+	MountOptions []string
+	// ForbiddenMountOptions may not be requested by the CO.
+	ForbiddenMountOptions []string
+	// Fsck determines whether an existing filesystem is checked before
+	// it is mounted. The empty policy is FsckNever.
+	Fsck FsckPolicy
+}
+
+// ParseFilesystemProfile parses a profile given as semicolon-separated
+// key=value pairs, for example:
+//
+//	name=fast;type=ext4;mkfs-args=-m 0;mount-options=noatime,nodiratime;forbidden-options=dax;fsck=repair
+//
+// The mkfs arguments are separated by whitespace and the mount options by
+// commas. Only type is required; name defaults to the filesystem type and
+// fsck to never.
+func ParseFilesystemProfile(s string) (FilesystemProfile, error) {
+	var p FilesystemProfile
+	for _, field := range strings.Split(s, ";") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("csilvm: filesystem profile: expected key=value instead of %q", field)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "name":
+			p.Name = value
+		case "type":
+			p.FsType = value
+		case "mkfs-args":
+			p.MkfsArgs = strings.Fields(value)
+		case "mount-options":
+			p.MountOptions = splitMountOptions(value)
+		case "forbidden-options":
+			p.ForbiddenMountOptions = splitMountOptions(value)
+		case "fsck":
+			policy, err := ParseFsckPolicy(value)
+			if err != nil {
+				return p, fmt.Errorf("csilvm: filesystem profile: %v", err)
+			}
+			p.Fsck = policy
+		default:
+			return p, fmt.Errorf("csilvm: filesystem profile: unknown key %q", key)
+		}
+	}
+	if p.FsType == "" {
+		return p, fmt.Errorf("csilvm: filesystem profile: type is required in %q", s)
+	}
+	if p.Name == "" {
+		p.Name = p.FsType
+	}
+	return p, nil
+}
+
+func splitMountOptions(s string) (opts []string) {
+	for _, opt := range strings.Split(s, ",") {
+		if opt = strings.TrimSpace(opt); opt != "" {
+			opts = append(opts, opt)
+		}
+	}
+	return opts
+}
+
+// mountOptions returns the profile's mount options followed by those
+// requested that the profile does not already include. It returns an error
+// if any of the requested options is forbidden.
+func (p FilesystemProfile) mountOptions(requested []string) ([]string, error) {
+	for _, opt := range requested {
+		// Options with a value, such as "uid=1000", are forbidden by
+		// their name.
+		name := strings.SplitN(opt, "=", 2)[0]
+		for _, forbidden := range p.ForbiddenMountOptions {
+			if opt == forbidden || name == forbidden {
+				return nil, fmt.Errorf("mount option %q is not allowed by filesystem profile %q", opt, p.Name)
+			}
+		}
+	}
+	opts := append([]string(nil), p.MountOptions...)
+next:
+	for _, opt := range requested {
+		for _, o := range opts {
+			if o == opt {
+				continue next
+			}
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
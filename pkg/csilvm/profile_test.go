@@ -0,0 +1,125 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestParseFilesystemProfile(t *testing.T) {
+	p, err := ParseFilesystemProfile("name=fast; type=ext4;mkfs-args=-m 0  -E lazy_itable_init=0;mount-options=noatime, nodiratime;forbidden-options=dax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := FilesystemProfile{
+		Name:                  "fast",
+		FsType:                "ext4",
+		MkfsArgs:              []string{"-m", "0", "-E", "lazy_itable_init=0"},
+		MountOptions:          []string{"noatime", "nodiratime"},
+		ForbiddenMountOptions: []string{"dax"},
+	}
+	if !reflect.DeepEqual(p, exp) {
+		t.Fatalf("Expected %+v but got %+v", exp, p)
+	}
+	p, err = ParseFilesystemProfile("type=xfs;mkfs-args=-n ftype=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "xfs" {
+		t.Fatalf("Expected the name to default to the filesystem type but got %q", p.Name)
+	}
+	for _, s := range []string{"", "name=fast", "type=xfs;mkfs", "type=xfs;size=1"} {
+		if _, err := ParseFilesystemProfile(s); err == nil {
+			t.Fatalf("Expected an error for %q", s)
+		}
+	}
+}
+
+func TestFilesystemProfileMountOptions(t *testing.T) {
+	p := FilesystemProfile{
+		Name:                  "fast",
+		MountOptions:          []string{"noatime", "discard"},
+		ForbiddenMountOptions: []string{"dax", "uid"},
+	}
+	opts, err := p.mountOptions([]string{"discard", "nodiratime"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"noatime", "discard", "nodiratime"}; !reflect.DeepEqual(opts, exp) {
+		t.Fatalf("Expected %v but got %v", exp, opts)
+	}
+	for _, opt := range []string{"dax", "uid=1000"} {
+		if _, err := p.mountOptions([]string{"ro", opt}); err == nil {
+			t.Fatalf("Expected mount option %q to be forbidden", opt)
+		}
+	}
+}
+
+func TestServerFilesystemProfile(t *testing.T) {
+	fast := FilesystemProfile{Name: "fast", FsType: "ext4", MkfsArgs: []string{"-m", "0"}}
+	xfs := FilesystemProfile{Name: "xfs", FsType: "xfs", MkfsArgs: []string{"-n", "ftype=1"}}
+	s := NewServer("test-vg", nil, "xfs", FilesystemProfileOpt(fast), FilesystemProfileOpt(xfs))
+	if _, ok := s.SupportedFilesystems()["ext4"]; !ok {
+		t.Fatal("Expected the profile's filesystem to be supported")
+	}
+	fastTags := []string{tagFsProfilePlainPrefix + "fast"}
+	tests := []struct {
+		tags   []string
+		fstype string
+		exp    FilesystemProfile
+		code   codes.Code
+	}{
+		{nil, "", xfs, codes.OK},
+		{nil, "xfs", xfs, codes.OK},
+		{nil, "ext4", FilesystemProfile{Name: "ext4", FsType: "ext4"}, codes.OK},
+		{fastTags, "", fast, codes.OK},
+		{fastTags, "ext4", fast, codes.OK},
+		{fastTags, "xfs", fast, codes.InvalidArgument},
+		{[]string{tagFsProfilePlainPrefix + "gone"}, "", FilesystemProfile{}, codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		p, err := s.filesystemProfile(tt.tags, tt.fstype)
+		if status.Code(err) != tt.code {
+			t.Fatalf("Expected %v for %v, %q but got %v", tt.code, tt.tags, tt.fstype, err)
+		}
+		if err == nil && !reflect.DeepEqual(p, tt.exp) {
+			t.Fatalf("Expected profile %+v for %v, %q but got %+v", tt.exp, tt.tags, tt.fstype, p)
+		}
+	}
+}
+
+func TestTakeFsProfileTagFromParameters(t *testing.T) {
+	s := NewServer("test-vg", nil, "xfs", FilesystemProfileOpt(FilesystemProfile{Name: "fast", FsType: "ext4"}))
+	mount := func(fstype string) []*csi.VolumeCapability {
+		return []*csi.VolumeCapability{{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{FsType: fstype},
+			},
+		}}
+	}
+	params := map[string]string{paramFsProfile: "fast", "type": "linear"}
+	tag, err := s.takeFsProfileTagFromParameters(params, mount("ext4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != tagFsProfilePlainPrefix+"fast" {
+		t.Fatalf("Unexpected tag %q", tag)
+	}
+	if _, ok := params[paramFsProfile]; ok || len(params) != 1 {
+		t.Fatalf("Expected the parameter to be consumed but got %v", params)
+	}
+	for _, tt := range []struct {
+		name string
+		caps []*csi.VolumeCapability
+	}{
+		{"slow", mount("")},
+		{"fast", mount("xfs")},
+	} {
+		if _, err := s.takeFsProfileTagFromParameters(map[string]string{paramFsProfile: tt.name}, tt.caps); err == nil {
+			t.Fatalf("Expected an error for profile %q", tt.name)
+		}
+	}
+}
@@ -6,16 +6,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"math/rand"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/Seagate/csiclvm/pkg/cleanup"
 	"github.com/Seagate/csiclvm/pkg/lvm"
 	"github.com/Seagate/csiclvm/pkg/version"
 	"github.com/uber-go/tally"
@@ -36,6 +38,8 @@ type Server struct {
 	volumeGroup          *lvm.VolumeGroup
 	defaultVolumeSize    uint64
 	supportedFilesystems map[string]string
+	filesystemProfiles   map[string]FilesystemProfile
+	erasePolicy          ErasePolicy
 	removingVolumeGroup  bool
 	tags                 []string
 	probeModules         map[string]struct{}
@@ -63,7 +67,9 @@ func NewServer(vgname string, pvnames []string, defaultFs string, opts ...Server
 			"":        defaultFs,
 			defaultFs: defaultFs,
 		},
-		metrics: tally.NoopScope,
+		filesystemProfiles: make(map[string]FilesystemProfile),
+		erasePolicy:        EraseNone,
+		metrics:            tally.NoopScope,
 	}
 	for _, opt := range opts {
 		if opt == nil {
@@ -120,6 +126,26 @@ func SupportedFilesystem(fstype string) ServerOpt {
 	}
 }
 
+// FilesystemProfileOpt adds a filesystem profile and supports its filesystem
+// type. A profile with the same name replaces an earlier one.
+func FilesystemProfileOpt(p FilesystemProfile) ServerOpt {
+	if p.Name == "" || p.FsType == "" {
+		panic("csilvm: FilesystemProfileOpt: profile name or filesystem type not provided")
+	}
+	return func(s *Server) {
+		s.supportedFilesystems[p.FsType] = p.FsType
+		s.filesystemProfiles[p.Name] = p
+	}
+}
+
+// ErasePolicyOpt sets how DeleteVolume erases volumes that were not created
+// with the 'erase' parameter. The default is EraseNone.
+func ErasePolicyOpt(p ErasePolicy) ServerOpt {
+	return func(s *Server) {
+		s.erasePolicy = p
+	}
+}
+
 // RemoveVolumeGroup configures the Server to operate in "remove" mode. The
 // volume group will be removed when the server starts. Most RPCs will return
 // an error if the plugin is started in this mode.
@@ -168,7 +194,18 @@ func ProbeModules(required []string) ServerOpt {
 // Setup checks that the specified volume group exists, creating it if it does
 // not. If the RemoveVolumeGroup option is set this method removes the volume
 // group.
-func (s *Server) Setup() error {
+func (s *Server) Setup() (err error) {
+	ctx := context.Background()
+	// Physical volumes and the volume group created below are removed
+	// again if Setup fails.
+	var rollback cleanup.Rollback
+	defer func() {
+		if err != nil {
+			if rerr := rollback.Run(ctx); rerr != nil {
+				err = fmt.Errorf("%v (%v)", err, rerr)
+			}
+		}
+	}()
 	log.Printf("Validating tags: %v", s.tags)
 	for _, tag := range s.tags {
 		if err := lvm.ValidateTag(tag); err != nil {
@@ -179,7 +216,7 @@ func (s *Server) Setup() error {
 		}
 	}
 	log.Printf("Looking up volume group %v", s.vgname)
-	volumeGroup, err := lvm.LookupVolumeGroup(s.vgname)
+	volumeGroup, err := lvm.LookupVolumeGroup(ctx, s.vgname)
 	if err == lvm.ErrVolumeGroupNotFound {
 		if s.removingVolumeGroup {
 			// We've been instructed to remove the volume
@@ -196,7 +233,7 @@ func (s *Server) Setup() error {
 		for _, pvname := range s.pvnames {
 			log.Printf("Looking up LVM2 physical volume %v", pvname)
 			var pv *lvm.PhysicalVolume
-			pv, err = lvm.LookupPhysicalVolume(pvname)
+			pv, err = lvm.LookupPhysicalVolume(ctx, pvname)
 			if err == nil {
 				log.Printf("Found LVM2 physical volume %v", pvname)
 				pvs = append(pvs, pv)
@@ -220,13 +257,14 @@ func (s *Server) Setup() error {
 						pvname, err)
 				}
 				log.Printf("Creating LVM2 physical volume %v", pvname)
-				pv, err = lvm.CreatePhysicalVolume(pvname)
+				pv, err = lvm.CreatePhysicalVolume(ctx, pvname)
 				if err != nil {
 					return fmt.Errorf(
 						"Cannot create LVM2 physical volume %v: err=%v",
 						pvname, err)
 				}
 				log.Printf("Created LVM2 physical volume %v", pvname)
+				rollback.Add("create physical volume "+pvname, pv.Remove)
 				pvs = append(pvs, pv)
 				continue
 			}
@@ -235,13 +273,14 @@ func (s *Server) Setup() error {
 				pvname, err)
 		}
 		log.Printf("Creating volume group %v with physical volumes %v and tags %v", s.vgname, s.pvnames, s.tags)
-		volumeGroup, err = lvm.CreateVolumeGroup(s.vgname, pvs, s.tags)
+		volumeGroup, err = lvm.CreateVolumeGroup(ctx, s.vgname, pvs, s.tags)
 		if err != nil {
 			return fmt.Errorf(
 				"Cannot create volume group %v: err=%v",
 				s.vgname, err)
 		}
 		log.Printf("Created volume group %v", s.vgname)
+		rollback.Add("create volume group "+s.vgname, volumeGroup.Remove)
 	} else if err != nil {
 		return fmt.Errorf(
 			"Cannot lookup volume group %v: err=%v",
@@ -260,7 +299,7 @@ func (s *Server) Setup() error {
 		// distinguish between DEGRADED and FAILED, we have no choice
 		// but to log an error but proceed without returning one.
 		log.Printf("Looking up LVM2 physical volume %v", pvname)
-		_, pverr := lvm.LookupPhysicalVolume(pvname)
+		_, pverr := lvm.LookupPhysicalVolume(ctx, pvname)
 		if pverr != nil {
 			log.Printf("Cannot lookup physical volume %v: err=%v",
 				pvname, pverr)
@@ -268,7 +307,7 @@ func (s *Server) Setup() error {
 		}
 	}
 	s.metrics.Gauge("lookup-pv-errs").Update(float64(len(pverrs)))
-	existing, err := volumeGroup.ListPhysicalVolumeNames()
+	existing, err := volumeGroup.ListPhysicalVolumeNames(ctx)
 	if err != nil {
 		return fmt.Errorf(
 			"Cannot list physical volumes: err=%v",
@@ -284,7 +323,7 @@ func (s *Server) Setup() error {
 	s.metrics.Gauge("missing-pvs").Update(float64(len(missing)))
 	// We check that the volume group tags match those we expect.
 	log.Printf("Looking up volume group tags")
-	tags, err := volumeGroup.Tags()
+	tags, err := volumeGroup.Tags(ctx)
 	if err != nil {
 		return fmt.Errorf(
 			"Cannot lookup tags: err=%v",
@@ -303,7 +342,7 @@ func (s *Server) Setup() error {
 		// The volume group matches our config. We remove it
 		// as requested in the startup flags.
 		log.Printf("Removing volume group %v", s.vgname)
-		if err := volumeGroup.Remove(); err != nil {
+		if err := volumeGroup.Remove(ctx); err != nil {
 			return fmt.Errorf(
 				"Failed to remove volume group: err=%v",
 				err)
@@ -312,7 +351,9 @@ func (s *Server) Setup() error {
 		return nil
 	}
 	s.volumeGroup = volumeGroup
-	s.reportStorageMetrics()
+	s.removeIncompleteVolumes(ctx)
+	s.removeEphemeralVolumes(ctx)
+	s.reportStorageMetrics(ctx)
 	return nil
 }
 
@@ -398,7 +439,10 @@ func (s *Server) Probe(
 		return response, nil
 	}
 	log.Printf("Looking up volume group %v", s.vgname)
-	volumeGroup, err := lvm.LookupVolumeGroup(s.vgname)
+	volumeGroup, err := lvm.LookupVolumeGroup(ctx, s.vgname)
+	if lookupFailed(err) {
+		return nil, status.Errorf(errorCode(err), "Cannot lookup volume group %v: err=%v", s.vgname, err)
+	}
 	if err != nil {
 		return nil, status.Errorf(
 			codes.FailedPrecondition,
@@ -415,7 +459,7 @@ func (s *Server) Probe(
 		// distinguish between DEGRADED and FAILED, we have no choice
 		// but to log an error but proceed without returning one.
 		log.Printf("Looking up LVM2 physical volume %v", pvname)
-		_, pverr := lvm.LookupPhysicalVolume(pvname)
+		_, pverr := lvm.LookupPhysicalVolume(ctx, pvname)
 		if pverr != nil {
 			log.Printf("Cannot lookup physical volume %v: err=%v",
 				pvname, pverr)
@@ -424,7 +468,7 @@ func (s *Server) Probe(
 	}
 	s.metrics.Gauge("lookup-pv-errs").Update(float64(len(pverrs)))
 	log.Printf("Comparing expected PVs with actual PVs")
-	existing, err := volumeGroup.ListPhysicalVolumeNames()
+	existing, err := volumeGroup.ListPhysicalVolumeNames(ctx)
 	if err != nil {
 		return nil, fmt.Errorf(
 			"Cannot list physical volumes: err=%v",
@@ -454,52 +498,150 @@ var ErrTooFewDisks = status.Error(codes.OutOfRange, "The volume group does not h
 
 const attrTags = "tags"
 
-func (s *Server) volumeAttributes(lv *lvm.LogicalVolume) (map[string]string, error) {
-	t, err := lv.Tags()
-	if err != nil {
-		return nil, err
+// volumeAttributes returns the volume context of the volume with the given
+// tags. The metadata parameters passed to CreateVolume are included under
+// their parameter names.
+func volumeAttributes(tags []string) (map[string]string, error) {
+	attr := volumeMetadata(tags)
+	if name, ok := volumeFsProfile(tags); ok {
+		if attr == nil {
+			attr = make(map[string]string)
+		}
+		attr[paramFsProfile] = name
+	}
+	for _, tag := range tags {
+		if tag == tagEncrypted {
+			if attr == nil {
+				attr = make(map[string]string)
+			}
+			attr[paramEncrypted] = "true"
+		}
+	}
+	// Tags that record the state of the volume are not attributes.
+	var t []string
+	for _, tag := range tags {
+		if !strings.HasPrefix(tag, tagStatePrefix) {
+			t = append(t, tag)
+		}
 	}
 	if len(t) == 0 {
-		return nil, nil
+		return attr, nil
 	}
 	buf, err := json.Marshal(t)
 	if err != nil {
 		return nil, err
 	}
-	return map[string]string{
-		attrTags: base64.RawURLEncoding.EncodeToString(buf),
-	}, nil
+	if attr == nil {
+		attr = make(map[string]string)
+	}
+	attr[attrTags] = base64.RawURLEncoding.EncodeToString(buf)
+	return attr, nil
 }
 
 func (s *Server) CreateVolume(
 	ctx context.Context,
-	request *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	request *csi.CreateVolumeRequest) (_ *csi.CreateVolumeResponse, err error) {
 
-	// Record the original volume name as a tag.
+	// Record the original volume name as a tag. The volume is also
+	// tagged as being created until it is complete.
 	encodedName := s.volumeNameToTag(request.GetName())
-	tags := make([]string, len(s.tags), len(s.tags)+1)
+	creatingTag := s.creatingTag()
+	tags := make([]string, len(s.tags), len(s.tags)+2)
 	copy(tags, s.tags)
-	tags = append(tags, encodedName)
+	tags = append(tags, encodedName, creatingTag)
+	// Record when the volume was created and what it was created for
+	// so that orphaned volumes can be identified later.
+	params := dupParams(request.GetParameters())
+	tags = append(tags, creationTimeTag(time.Now()))
+	tags = append(tags, takeMetadataTagsFromParameters(params)...)
+	userTags, err := takeUserTagsFromParameters(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+	}
+	tags = append(tags, userTags...)
+	profileTag, err := s.takeFsProfileTagFromParameters(params, request.GetVolumeCapabilities())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+	}
+	if profileTag != "" {
+		tags = append(tags, profileTag)
+	}
+	encrypted, err := takeEncryptedFromParameters(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+	}
+	if encrypted {
+		tags = append(tags, tagEncrypted)
+	}
+	discardTag, err := takeDiscardTagFromParameters(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+	}
+	if discardTag != "" {
+		tags = append(tags, discardTag)
+	}
+	eraseTag, err := takeEraseTagFromParameters(params)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
+	}
+	if eraseTag != "" {
+		tags = append(tags, eraseTag)
+	}
+
+	// Anything created below is removed again should the RPC fail.
+	var rollback cleanup.Rollback
+	defer func() {
+		if err != nil {
+			err = runRollback(&rollback, err)
+		}
+	}()
 
+	// All lookups below are answered from a single report of the
+	// volume group.
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Cannot report on volume group: err=%v", err)
+	}
 	// Check whether a logical volume with the given name already
 	// exists in this volume group.
 	log.Printf("Determining whether volume %q with encoded name %v already exists", request.GetName(), encodedName)
-	if lv, err := s.volumeGroup.FindLogicalVolume(lvm.LVMatchTag(encodedName)); err == nil {
-		log.Printf("Volume %s already exists.", encodedName)
+	existing, err := report.FindLogicalVolume(s.vgname, func(lv lvm.LogicalVolumeReport) bool { return lv.HasTag(encodedName) })
+	if err == nil && isCreating(existing) {
+		if !existing.HasTag(creatingTag) {
+			return nil, status.Errorf(
+				codes.Aborted,
+				"Volume %s is being created by another instance",
+				existing.Name)
+		}
+		// An earlier attempt failed and could not remove the volume.
+		// Remove it now and start over.
+		log.Printf("Removing incompletely created volume %s", existing.Name)
+		if err := s.volumeGroup.LogicalVolume(existing).Remove(ctx); err != nil {
+			return nil, status.Errorf(
+				errorCode(err),
+				"Cannot remove incompletely created volume %s: err=%v",
+				existing.Name, err)
+		}
+		// The removed volume no longer occupies any space.
+		if report, err = s.volumeGroup.Report(ctx); err != nil {
+			return nil, status.Errorf(errorCode(err), "Cannot report on volume group: err=%v", err)
+		}
+	} else if err == nil {
+		log.Printf("Volume %s already exists as %s %v", encodedName, existing.Name, volumeMetadata(existing.Tags()))
 		// The volume already exists. Determine whether or not the
 		// existing volume satisfies the request. If so, return a
 		// successful response. If not, return ErrVolumeAlreadyExists.
-		if err := s.validateExistingVolume(lv, request); err != nil {
+		if err := s.validateExistingVolume(existing, request); err != nil {
 			return nil, err
 		}
-		attr, err := s.volumeAttributes(lv)
+		attr, err := volumeAttributes(existing.Tags())
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
 		}
 		response := &csi.CreateVolumeResponse{
 			Volume: &csi.Volume{
-				CapacityBytes: int64(lv.SizeInBytes()),
-				VolumeId:      lv.Name(),
+				CapacityBytes: int64(existing.Size),
+				VolumeId:      existing.Name,
 				VolumeContext: attr,
 			},
 		}
@@ -507,12 +649,11 @@ func (s *Server) CreateVolume(
 	}
 	// Generate a random volume name and ensure that it doesn't already exist.
 	var volumeID string
-	const lvPrefix = "csilv"
 	for i := 0; i < 10 && volumeID == ""; i++ {
 		// prefix a random number to avoid stomping on reserved names.
 		tryID := lvPrefix + strconv.FormatUint(rand.Uint64(), 36)
 		log.Printf("Attempting to allocate id=%v for requested volume %q", tryID, request.GetName())
-		if _, err := s.volumeGroup.LookupLogicalVolume(tryID); err == nil {
+		if _, err := report.LogicalVolume(s.vgname, tryID); err == nil {
 			log.Printf("Volume id %s already exists, trying again..", tryID)
 			continue
 		}
@@ -532,13 +673,14 @@ func (s *Server) CreateVolume(
 		// Set the volume size to the minimum requested size.
 		size = uint64(capacityRange.GetRequiredBytes())
 		// Get the extentSize for this volume group. The LV size must be a multiple of the extent size.
-		extentSize, err := s.volumeGroup.ExtentSize()
+		vg, err := report.VolumeGroup(s.vgname)
 		if err != nil {
 			return nil, status.Errorf(
-				codes.Internal,
+				errorCode(err),
 				"Error in ExtentSize: err=%v",
 				err)
 		}
+		extentSize := vg.ExtentSize
 		// If size is not already a multiple of extentSize, round it up to the
 		// nearest extentSize.
 		if size%extentSize != 0 {
@@ -547,10 +689,10 @@ func (s *Server) CreateVolume(
 			log.Printf("Rounding size up from required_bytes (about %dMiB) to nearest extent size (%dMiB) to get (%dMiB)", sizeBefore>>20, extentSize>>20, size>>20)
 		}
 		// Get bytesFree, it is a multiple of extentSize.
-		bytesFree, err := s.volumeGroup.BytesFree(layout)
+		bytesFree, err := report.BytesFree(s.vgname, layout)
 		if err != nil {
 			return nil, status.Errorf(
-				codes.Internal,
+				errorCode(err),
 				"Error in BytesFree: err=%v",
 				err)
 		}
@@ -568,13 +710,13 @@ func (s *Server) CreateVolume(
 			return nil, ErrNotMultipleOfExtentSize(extentSize)
 		}
 	}
-	lvopts, err := volumeOptsFromParameters(request.GetParameters())
+	lvopts, err := volumeOptsFromParameters(params)
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "Invalid parameters: %v", err)
 	}
 
-	log.Printf("Creating logical volume id=%v, size=%v, tags=%v, params=%v", volumeID, size, tags, request.GetParameters())
-	lv, err := s.volumeGroup.CreateLogicalVolume(volumeID, size, tags, lvopts...)
+	log.Printf("Creating logical volume id=%v, size=%v, tags=%v, params=%v, metadata=%v", volumeID, size, tags, params, volumeMetadata(tags))
+	lv, err := s.volumeGroup.CreateLogicalVolume(ctx, volumeID, size, tags, lvopts...)
 	if err != nil {
 		if err == lvm.ErrInvalidLVName {
 			return nil, status.Errorf(
@@ -591,15 +733,35 @@ func (s *Server) CreateVolume(
 			return nil, ErrTooFewDisks
 		}
 		return nil, status.Errorf(
-			codes.Internal,
+			errorCode(err),
 			"Error in CreateLogicalVolume: err=%v",
 			err)
 	}
-	attr, err := s.volumeAttributes(lv)
+	// Should removing the volume fail, it is still tagged as being
+	// created so it is removed when this instance next starts.
+	rollback.Add("create volume "+volumeID, lv.Remove)
+	log.Printf("Marking volume %s as created", volumeID)
+	if err := lv.ChangeTags(ctx, []string{tagCreated}, []string{creatingTag}); err != nil {
+		return nil, status.Errorf(
+			errorCode(err),
+			"Cannot tag volume %s: err=%v",
+			volumeID, err)
+	}
+	// The new volume is looked up in a fresh report which is also used
+	// to update the storage metrics.
+	report, err = s.volumeGroup.Report(ctx)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Cannot report on volume group: err=%v", err)
+	}
+	created, err := report.LogicalVolume(s.vgname, volumeID)
+	if err != nil {
+		return nil, status.Errorf(errorCode(err), "Cannot lookup new volume: err=%v", err)
+	}
+	attr, err := volumeAttributes(created.Tags())
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
 	}
-	defer s.reportStorageMetrics()
+	defer s.reportStorageMetricsFrom(report)
 	response := &csi.CreateVolumeResponse{
 		Volume: &csi.Volume{
 			CapacityBytes: int64(lv.SizeInBytes()),
@@ -610,22 +772,39 @@ func (s *Server) CreateVolume(
 	return response, nil
 }
 
-func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.CreateVolumeRequest) error {
+// rollbackTimeout bounds the rollback of a failed RPC.
+const rollbackTimeout = time.Minute
+
+// runRollback undoes the steps of an RPC that failed with err. The RPC's
+// context may be done already so the rollback gets a context of its own.
+// Should the rollback fail as well, the returned error says so.
+func runRollback(rollback *cleanup.Rollback, err error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	rerr := rollback.Run(ctx)
+	if rerr == nil {
+		return err
+	}
+	st, _ := status.FromError(err)
+	return status.Errorf(st.Code(), "%s (%v)", st.Message(), rerr)
+}
+
+func (s *Server) validateExistingVolume(lv lvm.LogicalVolumeReport, request *csi.CreateVolumeRequest) error {
 	// Determine whether the existing volume satisfies the capacity_range
 	// of the current request.
 	if capacityRange := request.GetCapacityRange(); capacityRange != nil {
 		// If required_bytes is specified, is that requirement
 		// satisfied by the existing volume?
 		if requiredBytes := capacityRange.GetRequiredBytes(); requiredBytes != 0 {
-			if requiredBytes > int64(lv.SizeInBytes()) {
-				log.Printf("Existing volume does not satisfy request: required_bytes > volume size (%d > %d)", requiredBytes, lv.SizeInBytes())
+			if requiredBytes > int64(lv.Size) {
+				log.Printf("Existing volume does not satisfy request: required_bytes > volume size (%d > %d)", requiredBytes, lv.Size)
 				// The existing volume is not big enough.
 				return ErrVolumeAlreadyExists
 			}
 		}
 		if limitBytes := capacityRange.GetLimitBytes(); limitBytes != 0 {
-			if limitBytes < int64(lv.SizeInBytes()) {
-				log.Printf("Existing volume does not satisfy request: limit_bytes < volume size (%d < %d)", limitBytes, lv.SizeInBytes())
+			if limitBytes < int64(lv.Size) {
+				log.Printf("Existing volume does not satisfy request: limit_bytes < volume size (%d < %d)", limitBytes, lv.Size)
 				// The existing volume is too big.
 				return ErrVolumeAlreadyExists
 			}
@@ -637,15 +816,8 @@ func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.Crea
 	// The existing volume matches the requested capacity_range.  We
 	// determine whether the existing volume satisfies all requested
 	// volume_capabilities.
-	sourcePath, err := lv.Path()
-	if err != nil {
-		return status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
-	}
-	log.Printf("Volume path is %v", sourcePath)
-	existingFsType, err := determineFilesystemType(sourcePath)
+	log.Printf("Volume path is %v", lv.Path)
+	existingFsType, err := volumeFilesystemType(lv)
 	if err != nil {
 		return status.Errorf(
 			codes.Internal,
@@ -694,75 +866,48 @@ func (s *Server) validateExistingVolume(lv *lvm.LogicalVolume, request *csi.Crea
 
 var ErrVolumeNotFound = status.Error(codes.NotFound, "The volume does not exist.")
 
+// lookupVolume returns the logical volume with the given id, including its
+// path and attributes, using a single report of the volume group.
+func (s *Server) lookupVolume(ctx context.Context, id string) (lvm.LogicalVolumeReport, error) {
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		return lvm.LogicalVolumeReport{}, err
+	}
+	return report.LogicalVolume(s.vgname, id)
+}
+
 func (s *Server) DeleteVolume(
 	ctx context.Context,
 	request *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
 	id := request.GetVolumeId()
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
+	lvr, err := s.lookupVolume(ctx, id)
+	if lookupFailed(err) {
+		return nil, status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
+	}
 	if err != nil {
 		// It is idempotent to succeed if a volume is not found.
 		response := &csi.DeleteVolumeResponse{}
 		return response, nil
 	}
-	// LVs most likely not mounted on this host.  Skipping stat'ing path
-	//log.Printf("Determining volume path")
-	//path, err := lv.Path()
-	//if err != nil {
-	//	return nil, status.Errorf(
-	//		codes.Internal,
-	//		"Error in Path(): err=%v",
-	//		err)
-	//}
-	//if _, err := os.Stat(path); err != nil && os.IsNotExist(err) {
-	//	return nil, status.Errorf(
-	//		codes.Internal,
-	//		"The device path does not exist, cannot zero volume contents. To bypass the zeroing of the volume contents, ensure the file exists, or create it by hand, and reissue the DeleteVolume operation. path=%s",
-	//		path)
-	//}
-	// Removing feature to overwrite data since not mounted. This should be done with a K8s PV claim wiper.
-	//log.Printf("Deleting data on device %v", path)
-	//if err := deleteDataOnDevice(path); err != nil {
-	//	return nil, status.Errorf(
-	//		codes.Internal,
-	//		"Cannot delete data from device: err=%v",
-	//		err)
-	//}
+	lv := s.volumeGroup.LogicalVolume(lvr)
+	if policy := volumeErasePolicy(lvr, s.erasePolicy); policy != EraseNone {
+		if err := eraseVolume(ctx, lv, lvr, policy); err != nil {
+			return nil, err
+		}
+	}
 	log.Printf("Removing volume")
-	if err := lv.Remove(); err != nil {
+	if err := withVolumeGroupLock(ctx, vgShared, func() error { return lv.Remove(ctx) }); err != nil {
 		return nil, status.Errorf(
-			codes.Internal,
+			errorCode(err),
 			"Failed to remove volume: err=%v",
 			err)
 	}
-	defer s.reportStorageMetrics()
+	defer s.reportStorageMetrics(ctx)
 	response := &csi.DeleteVolumeResponse{}
 	return response, nil
 }
 
-func deleteDataOnDevice(devicePath string) error {
-	// This method is the go equivalent of
-	// `dd if=/dev/zero of=PhysicalVolume`.
-	file, err := os.OpenFile(devicePath, os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-	devzero, err := os.Open("/dev/zero")
-	if err != nil {
-		return err
-	}
-	defer devzero.Close()
-	if _, err := io.Copy(file, devzero); err != nil {
-		// We expect to stop when we get ENOSPC.
-		if perr, ok := err.(*os.PathError); ok && perr.Err == syscall.ENOSPC {
-			return nil
-		}
-		return err
-	}
-	panic("csilvm: expected ENOSPC when erasing data")
-}
-
 var ErrCallNotImplemented = status.Error(codes.Unimplemented, "That RPC is not implemented.")
 
 func (s *Server) ControllerPublishVolume(
@@ -788,20 +933,15 @@ func (s *Server) ValidateVolumeCapabilities(
 	request *csi.ValidateVolumeCapabilitiesRequest) (*csi.ValidateVolumeCapabilitiesResponse, error) {
 	id := request.GetVolumeId()
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
-	if err != nil {
-		return nil, ErrVolumeNotFound
+	lv, err := s.lookupVolume(ctx, id)
+	if lookupFailed(err) {
+		return nil, status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
 	}
-	log.Printf("Determining volume path")
-	sourcePath, err := lv.Path()
 	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
+		return nil, ErrVolumeNotFound
 	}
-	log.Printf("Determining filesystem type at %v", sourcePath)
-	existingFstype, err := determineFilesystemType(sourcePath)
+	log.Printf("Determining filesystem type at %v", lv.Path)
+	existingFstype, err := volumeFilesystemType(lv)
 	if err != nil {
 		return nil, status.Errorf(
 			codes.Internal,
@@ -830,8 +970,167 @@ func (s *Server) ValidateVolumeCapabilities(
 const (
 	tagVolumeNameEncodedPrefix = "VN+" // used when volume name is not tag-safe
 	tagVolumeNamePlainPrefix   = "VN." // used when volume name is tag-safe
+	tagUserEncodedPrefix       = "UT+" // used when a user tag is not tag-safe
+)
+
+// lvPrefix is the prefix of the names of the volumes created by
+// CreateVolume.
+const lvPrefix = "csilv"
+
+const (
+	tagStatePrefix = "csilvm."
+	// tagCreatingPrefix marks a volume that CreateVolume has not
+	// completed. It is followed by the encoded name of the instance
+	// creating the volume, see creatingTag.
+	tagCreatingPrefix = tagStatePrefix + "creating."
+	// tagCreated replaces the creating tag once CreateVolume completes.
+	tagCreated = tagStatePrefix + "created"
+	// tagCreationTimePrefix is followed by the time, in seconds since
+	// the epoch, at which CreateVolume created the volume.
+	tagCreationTimePrefix = tagStatePrefix + "ctime."
+	// The persistent volume claim and persistent volume a volume was
+	// created for, as passed by the CO in the volume parameters.
+	tagPVCNamePlainPrefix        = tagStatePrefix + "pvc-name."
+	tagPVCNameEncodedPrefix      = tagStatePrefix + "pvc-name+"
+	tagPVCNamespacePlainPrefix   = tagStatePrefix + "pvc-namespace."
+	tagPVCNamespaceEncodedPrefix = tagStatePrefix + "pvc-namespace+"
+	tagPVNamePlainPrefix         = tagStatePrefix + "pv-name."
+	tagPVNameEncodedPrefix       = tagStatePrefix + "pv-name+"
+	// The filesystem profile selected by the fsProfile parameter.
+	tagFsProfilePlainPrefix   = tagStatePrefix + "fs-profile."
+	tagFsProfileEncodedPrefix = tagStatePrefix + "fs-profile+"
 )
 
+// paramFsProfile selects the filesystem profile of a new volume. It is
+// returned as a volume attribute of the same name.
+const paramFsProfile = "fsProfile"
+
+const (
+	// paramMetadataPrefix is the prefix of the parameters that
+	// describe the volume to be created rather than configure it, such
+	// as those passed by the Kubernetes external-provisioner when it
+	// runs with --extra-create-metadata.
+	paramMetadataPrefix = "csi.storage.k8s.io/"
+	paramPVCName        = paramMetadataPrefix + "pvc/name"
+	paramPVCNamespace   = paramMetadataPrefix + "pvc/namespace"
+	paramPVName         = paramMetadataPrefix + "pv/name"
+)
+
+// metadataTagPrefixes are the plain and encoded prefixes of the tags that
+// record the metadata parameters, keyed by parameter.
+var metadataTagPrefixes = map[string][2]string{
+	paramPVCName:      {tagPVCNamePlainPrefix, tagPVCNameEncodedPrefix},
+	paramPVCNamespace: {tagPVCNamespacePlainPrefix, tagPVCNamespaceEncodedPrefix},
+	paramPVName:       {tagPVNamePlainPrefix, tagPVNameEncodedPrefix},
+}
+
+// creationTimeTag returns the tag that records that a volume was created
+// at t.
+func creationTimeTag(t time.Time) string {
+	return tagCreationTimePrefix + strconv.FormatInt(t.Unix(), 10)
+}
+
+// volumeCreationTime returns the time at which lv was created or false if
+// it was created before creation times were recorded.
+func volumeCreationTime(lv lvm.LogicalVolumeReport) (time.Time, bool) {
+	for _, tag := range lv.Tags() {
+		if !strings.HasPrefix(tag, tagCreationTimePrefix) {
+			continue
+		}
+		sec, err := strconv.ParseInt(strings.TrimPrefix(tag, tagCreationTimePrefix), 10, 64)
+		if err != nil {
+			continue
+		}
+		return time.Unix(sec, 0), true
+	}
+	return time.Time{}, false
+}
+
+// takeMetadataTagsFromParameters removes the metadata parameters from
+// params and returns the tags that record them. Metadata parameters that
+// the plugin does not know are ignored.
+func takeMetadataTagsFromParameters(params map[string]string) (tags []string) {
+	var keys []string
+	for k := range params {
+		if strings.HasPrefix(k, paramMetadataPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := params[k]
+		delete(params, k)
+		prefixes, ok := metadataTagPrefixes[k]
+		if !ok {
+			log.Printf("Ignoring parameter %s=%s", k, v)
+			continue
+		}
+		tags = append(tags, encodeTag(prefixes[0], prefixes[1], v))
+	}
+	return tags
+}
+
+// volumeMetadata returns the metadata parameters recorded in tags, keyed
+// by parameter.
+func volumeMetadata(tags []string) map[string]string {
+	var metadata map[string]string
+	for _, tag := range tags {
+		for k, prefixes := range metadataTagPrefixes {
+			if v, ok := decodeTag(prefixes[0], prefixes[1], tag); ok {
+				if metadata == nil {
+					metadata = make(map[string]string)
+				}
+				metadata[k] = v
+				break
+			}
+		}
+	}
+	return metadata
+}
+
+// creatingTag returns the tag that marks volumes this instance has not
+// finished creating. The volume group may be shared by several nodes so
+// the tag identifies this instance by its node ID or hostname.
+func (s *Server) creatingTag() string {
+	owner := s.nodeID
+	if owner == "" {
+		owner, _ = os.Hostname()
+	}
+	return tagCreatingPrefix + base64.RawURLEncoding.EncodeToString([]byte(owner))
+}
+
+// isCreating returns true if CreateVolume has not completed for lv.
+func isCreating(lv lvm.LogicalVolumeReport) bool {
+	for _, tag := range lv.Tags() {
+		if strings.HasPrefix(tag, tagCreatingPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeIncompleteVolumes removes the volumes this instance started but
+// did not finish creating, e.g., as it crashed. Failures are logged only
+// as they are retried the next time the plugin starts.
+func (s *Server) removeIncompleteVolumes(ctx context.Context) {
+	tag := s.creatingTag()
+	log.Printf("Looking for incompletely created volumes tagged %s", tag)
+	report, err := s.volumeGroup.Report(ctx)
+	if err != nil {
+		log.Printf("Cannot report on volume group: err=%v", err)
+		return
+	}
+	for _, lv := range report.LogicalVolumesIn(s.vgname) {
+		if !lv.HasTag(tag) {
+			continue
+		}
+		log.Printf("Removing incompletely created volume %s", lv.Name)
+		if err := s.volumeGroup.LogicalVolume(lv).Remove(ctx); err != nil {
+			log.Printf("Cannot remove volume %s: err=%v", lv.Name, err)
+		}
+	}
+}
+
 var tagSafeChars map[rune]struct{} = func() map[rune]struct{} {
 	const safe = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz_+.-1234567890"
 	m := make(map[rune]struct{})
@@ -844,14 +1143,37 @@ var tagSafeChars map[rune]struct{} = func() map[rune]struct{} {
 // volumeNameToTag attempts to preserve the suggested volume name as a suffix of the
 // returned string, unless it contains unsafe chars in which case it is encoded.
 func (s *Server) volumeNameToTag(volname string) string {
-	for _, r := range volname {
+	return encodeTag(tagVolumeNamePlainPrefix, tagVolumeNameEncodedPrefix, volname)
+}
+
+// encodeTag returns value prefixed with plainPrefix if it only contains
+// characters that are safe in a tag. Otherwise value is encoded and
+// prefixed with encodedPrefix.
+func encodeTag(plainPrefix, encodedPrefix, value string) string {
+	for _, r := range value {
 		if _, ok := tagSafeChars[r]; ok {
 			continue
 		}
-		return tagVolumeNameEncodedPrefix +
-			base64.RawURLEncoding.EncodeToString([]byte(volname))
+		return encodedPrefix +
+			base64.RawURLEncoding.EncodeToString([]byte(value))
+	}
+	return plainPrefix + value
+}
+
+// decodeTag returns the value encoded in tag by encodeTag or false if tag
+// does not have either prefix.
+func decodeTag(plainPrefix, encodedPrefix, tag string) (string, bool) {
+	if strings.HasPrefix(tag, plainPrefix) {
+		return strings.TrimPrefix(tag, plainPrefix), true
+	}
+	if strings.HasPrefix(tag, encodedPrefix) {
+		buf, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tag, encodedPrefix))
+		if err != nil {
+			return "", false
+		}
+		return string(buf), true
 	}
-	return tagVolumeNamePlainPrefix + volname
+	return "", false
 }
 
 func (s *Server) ListVolumes(
@@ -866,34 +1188,23 @@ func (s *Server) ListVolumes(
 	if  request.GetStartingToken() != "" {
 		return nil, status.Errorf(codes.Aborted, "Starting_Token field not implemented.")
 	}
-	volnames, err := s.volumeGroup.ListLogicalVolumeNames()
+	report, err := s.volumeGroup.Report(ctx)
 	if err != nil {
 		return nil, status.Errorf(
-			codes.Internal,
+			errorCode(err),
 			"Cannot list volume names: err=%v",
 			err)
 	}
+	volumes, err := listVolumes(report, s.vgname, nil)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
+	}
 	var entries []*csi.ListVolumesResponse_Entry
-	for _, volname := range volnames {
-		log.Printf("Looking up volume '%v'", volname)
-		lv, err := s.volumeGroup.LookupLogicalVolume(volname)
-		if err != nil {
-			return nil, ErrVolumeNotFound
-		}
-		attr, err := s.volumeAttributes(lv)
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "failed to get volume attributes: err=%v", err)
-		}
-		info := &csi.Volume{
-			CapacityBytes: int64(lv.SizeInBytes()),
-			VolumeId:      lv.Name(),
-			VolumeContext: attr,
-		}
-		log.Printf("Found volume %v (%v bytes)", volname, lv.SizeInBytes())
+	for _, info := range volumes {
 		entry := &csi.ListVolumesResponse_Entry{Volume: info}
 		entries = append(entries, entry)
 	}
-	defer s.reportStorageMetrics()
+	defer s.reportStorageMetricsFrom(report)
 	response := &csi.ListVolumesResponse{
 		Entries:   entries,
 		NextToken: "",
@@ -901,6 +1212,36 @@ func (s *Server) ListVolumes(
 	return response, nil
 }
 
+// listVolumes returns the volumes in the named volume group that are
+// tagged with all of the given LV tags.
+func listVolumes(report *lvm.Report, vgname string, tags []string) ([]*csi.Volume, error) {
+	var volumes []*csi.Volume
+next:
+	for _, lv := range report.LogicalVolumesIn(vgname) {
+		// Ephemeral volumes are not known to the CO as volumes.
+		if isEphemeral(lv) {
+			continue
+		}
+		for _, tag := range tags {
+			if !lv.HasTag(tag) {
+				continue next
+			}
+		}
+		attr, err := volumeAttributes(lv.Tags())
+		if err != nil {
+			return nil, err
+		}
+		info := &csi.Volume{
+			CapacityBytes: int64(lv.Size),
+			VolumeId:      lv.Name,
+			VolumeContext: attr,
+		}
+		log.Printf("Found volume %v (%v bytes) %v", lv.Name, lv.Size, volumeMetadata(lv.Tags()))
+		volumes = append(volumes, info)
+	}
+	return volumes, nil
+}
+
 func (s *Server) GetCapacity(
 	ctx context.Context,
 	request *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
@@ -927,15 +1268,22 @@ func (s *Server) GetCapacity(
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "Invalid volume layout: err=%v", err)
 	}
-	bytesFree, err := s.volumeGroup.BytesFree(layout)
+	report, err := s.volumeGroup.Report(ctx)
 	if err != nil {
 		return nil, status.Errorf(
-			codes.Internal,
+			errorCode(err),
+			"Error in BytesFree: err=%v",
+			err)
+	}
+	bytesFree, err := report.BytesFree(s.vgname, layout)
+	if err != nil {
+		return nil, status.Errorf(
+			errorCode(err),
 			"Error in BytesFree: err=%v",
 			err)
 	}
 	log.Printf("BytesFree: %v", bytesFree)
-	defer s.reportStorageMetrics()
+	defer s.reportStorageMetricsFrom(report)
 	response := &csi.GetCapacityResponse{AvailableCapacity: int64(bytesFree)}
 	return response, nil
 }
@@ -1008,6 +1356,13 @@ func (s *Server) ControllerExpandVolume(
 	return nil, ErrCallNotImplemented
 }
 
+func (s *Server) ControllerGetVolume(
+	ctx context.Context,
+	request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	log.Printf("ControllerGetVolume not supported")
+	return nil, ErrCallNotImplemented
+}
+
 // NodeService RPCs
 
 func (s *Server) NodeStageVolume(
@@ -1053,26 +1408,47 @@ var ErrTargetPathRW = status.Error(
 
 func (s *Server) NodePublishVolume(
 	ctx context.Context,
-	request *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	request *csi.NodePublishVolumeRequest) (_ *csi.NodePublishVolumeResponse, err error) {
+	// An encrypted volume opened below is closed again should the RPC
+	// fail.
+	var rollback cleanup.Rollback
+	defer func() {
+		if err != nil {
+			err = runRollback(&rollback, err)
+		}
+	}()
 	id := request.GetVolumeId()
-	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
-	if err != nil {
-		return nil, ErrVolumeNotFound
-	}
-	log.Printf("Determining volume path")
-	sourcePath, err := lv.Path()
-	if err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Error in Path(): err=%v",
-			err)
+	var lv lvm.LogicalVolumeReport
+	if isEphemeralRequest(request.GetVolumeContext()) {
+		// The volume is created here and removed again by
+		// NodeUnpublishVolume.
+		log.Printf("Creating ephemeral volume for id=%v", id)
+		var isNew bool
+		lv, isNew, err = s.createEphemeralVolume(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		if isNew {
+			rollback.Add("create ephemeral volume "+lv.Name, s.volumeGroup.LogicalVolume(lv).Remove)
+		}
+	} else {
+		log.Printf("Looking up volume with id=%v", id)
+		lv, err = s.lookupVolume(ctx, id)
+		if lookupFailed(err) {
+			return nil, status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
+		}
+		if err != nil {
+			return nil, ErrVolumeNotFound
+		}
 	}
-	if err := lv.Activate(); err != nil {
-		return nil, status.Errorf(
-			codes.Internal,
-			"Failed to activate volume: err=%v",
-			err)
+	sourcePath := lv.Path
+	if !lv.Active() {
+		if err := s.volumeGroup.LogicalVolume(lv).Activate(ctx); err != nil {
+			return nil, status.Errorf(
+				errorCode(err),
+				"Failed to activate volume: err=%v",
+				err)
+		}
 	}
 	log.Printf("Volume path is %v", sourcePath)
 	targetPath := request.GetTargetPath()
@@ -1080,6 +1456,16 @@ func (s *Server) NodePublishVolume(
 	readonly := request.GetVolumeCapability().GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
 	readonly = readonly || request.GetReadonly()
 	log.Printf("Mounting readonly: %v", readonly)
+	if isEncrypted(lv) {
+		if !isLUKSOpen(id) {
+			rollback.Add("open LUKS volume "+id, func(context.Context) error { return closeLUKSVolume(id) })
+		}
+		sourcePath, err = openLUKSVolume(lv, request.GetSecrets()[secretEncryptionPassphrase], readonly)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Encrypted volume contents are at %v", sourcePath)
+	}
 	switch accessType := request.GetVolumeCapability().GetAccessType().(type) {
 	case *csi.VolumeCapability_Block:
 		if err := s.nodePublishVolume_Block(sourcePath, targetPath, readonly); err != nil {
@@ -1087,8 +1473,27 @@ func (s *Server) NodePublishVolume(
 		}
 	case *csi.VolumeCapability_Mount:
 		fstype := request.GetVolumeCapability().GetMount().GetFsType()
-		mountOptions := request.GetVolumeCapability().GetMount().GetMountFlags()
-		if err := s.nodePublishVolume_Mount(sourcePath, targetPath, readonly, fstype, mountOptions); err != nil {
+		profile, err := s.filesystemProfile(lv.Tags(), fstype)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Using filesystem profile %+v", profile)
+		mountFlags := request.GetVolumeCapability().GetMount().GetMountFlags()
+		if isDiscardOnline(lv) {
+			mountFlags = append(mountFlags[:len(mountFlags):len(mountFlags)], mountOptionDiscard)
+		}
+		mountGroup, err := volumeMountGroup(request.GetVolumeCapability())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if mountGroup >= 0 {
+			mountFlags = append(mountFlags[:len(mountFlags):len(mountFlags)], mountGroupOptions(profile.FsType, mountGroup)...)
+		}
+		mountOptions, err := profile.mountOptions(mountFlags)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if err := s.nodePublishVolume_Mount(ctx, lv, sourcePath, targetPath, readonly, profile, mountOptions, mountGroup); err != nil {
 			return nil, err
 		}
 	default:
@@ -1110,30 +1515,36 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 			targetPath, err)
 	}
 	log.Printf("Mount info at %v: %+v", targetPath, mp)
+	// With lvm2, the sourcePath is typically a symlink to a
+	// devicemapper device, for example:
+	//   /dev/some-volume-group/some-logical-volume -> /dev/dm-4
+	//
+	// However, the mountpoint root shows the actual device, not
+	// the symlink. As such, to determine whether or not the
+	// device mounted at targetPath is the expected one, we need
+	// to resolve the symlink and compare the targets.
+	log.Printf("Following symlinks at %v", sourcePath)
+	sourceDevicePath, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			"Failed to follow symlinks at %v: err=%v",
+			sourcePath, err)
+	}
+	log.Printf("Determined that %v -> %v", sourcePath, sourceDevicePath)
 	if mp != nil {
-		// With lvm2, the sourcePath is typically a symlink to a
-		// devicemapper device, for example:
-		//   /dev/some-volume-group/some-logical-volume -> /dev/dm-4
-		//
-		// However, the mountpoint root shows the actual device, not
-		// the symlink. As such, to determine whether or not the
-		// device mounted at targetPath is the expected one, we need
-		// to resolve the symlink and compare the targets.
-		log.Printf("Following symlinks at %v", sourcePath)
-		sourceDevicePath, err := filepath.EvalSymlinks(sourcePath)
-		if err != nil {
-			return status.Errorf(
-				codes.Internal,
-				"Failed to follow symlinks at %v: err=%v",
-				sourcePath, err)
-		}
-		log.Printf("Determined that %v -> %v", sourcePath, sourceDevicePath)
 		// For bindmounts, we use the mountpoint root
 		// in the current filesystem.
 		mpdev := "/dev" + mp.root
 		if mpdev != sourceDevicePath {
 			return ErrTargetPathNotEmpty
 		}
+		if mp.isReadonly() != readonly {
+			if mp.isReadonly() {
+				return ErrTargetPathRO
+			}
+			return ErrTargetPathRW
+		}
 		log.Printf("The volume %v is already bind mounted to %v", sourcePath, targetPath)
 		// For bind mounts, the filesystemtype and mount options are
 		// ignored. As this RPC is idempotent, we respond with success.
@@ -1149,12 +1560,53 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 		}
 	}
 	log.Printf("Nothing mounted at targetPath %v yet", targetPath)
+	mounts, err := listMounts()
+	if err != nil {
+		return status.Errorf(
+			codes.Internal,
+			"Cannot list mounts: err=%v",
+			err)
+	}
+	if err := checkBlockPublications(mounts, sourceDevicePath, readonly); err != nil {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"Cannot publish block volume with readonly=%v: %v",
+			readonly, err)
+	}
+	if readonly {
+		// A bind mount cannot prevent writes to a device node so the
+		// device itself is made read-only.
+		log.Printf("Setting the read-only flag of %v", sourceDevicePath)
+		if err := setDeviceReadonly(sourceDevicePath, true); err != nil {
+			return status.Errorf(
+				codes.Internal,
+				"Cannot make the device read-only: err=%v",
+				err)
+		}
+	}
 	// Perform a bind mount of the raw block device. The
 	// `filesystemtype` and `data` parameters to the
 	// mount(2) system call are ignored in this case.
 	flags := uintptr(syscall.MS_BIND)
 	log.Printf("Performing bind mount of %s -> %s", sourcePath, targetPath)
-	if err := syscall.Mount(sourcePath, targetPath, "", flags, ""); err != nil {
+	err = syscall.Mount(sourcePath, targetPath, "", flags, "")
+	if err == nil && readonly {
+		// The readonly status of the publication is recorded on the
+		// bind mount.
+		log.Printf("Remounting %s read-only", targetPath)
+		err = syscall.Mount("", targetPath, "", flags|syscall.MS_REMOUNT|syscall.MS_RDONLY, "")
+		if err != nil {
+			if uerr := syscall.Unmount(targetPath, 0); uerr != nil {
+				log.Printf("Cannot unmount %v: err=%v", targetPath, uerr)
+			}
+		}
+	}
+	if err != nil {
+		if readonly {
+			if rerr := restoreDeviceReadWrite(sourceDevicePath); rerr != nil {
+				log.Printf("Cannot clear the read-only flag of %v: err=%v", sourceDevicePath, rerr)
+			}
+		}
 		_, ok := err.(syscall.Errno)
 		if !ok {
 			return status.Errorf(
@@ -1170,20 +1622,17 @@ func (s *Server) nodePublishVolume_Block(sourcePath, targetPath string, readonly
 	return nil
 }
 
-func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly bool, fstype string, mountOptions []string) error {
+func (s *Server) nodePublishVolume_Mount(ctx context.Context, lv lvm.LogicalVolumeReport, sourcePath, targetPath string, readonly bool, profile FilesystemProfile, mountOptions []string, mountGroup int) error {
 	log.Printf("Attempting to publish volume %v as MOUNT_DEVICE to %v", sourcePath, targetPath)
 	var flags uintptr
 	if readonly {
 		flags |= syscall.MS_RDONLY
 	}
 	// Request validation ensures that the fstype is in our list of
-	// supported filesystems.
-	log.Printf("Requested filesystem type is '%v'", fstype)
-	if fstype == "" {
-		// If the fstype was not specified, pick the default.
-		fstype = s.supportedFilesystems[""]
-		log.Printf("No specific filesystem type requested, defaulting to %v", fstype)
-	}
+	// supported filesystems and the profile defaults it if it was not
+	// specified.
+	fstype := profile.FsType
+	log.Printf("Filesystem type is '%v'", fstype)
 	// Check whether something is already mounted at targetPath.
 	log.Printf("Determining mount info at %v", targetPath)
 	mp, err := getMountAt(targetPath)
@@ -1244,22 +1693,31 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 			err)
 	}
 	log.Printf("Existing filesystem type is '%v'", existingFstype)
+	formatted := false
 	if existingFstype == "" {
 		// There is no existing filesystem on the
 		// device, format it with the requested
 		// filesystem.
 		log.Printf("The device %v has no existing filesystem, formatting with %v", sourcePath, fstype)
-		if err := formatDevice(sourcePath, fstype); err != nil {
+		if err := formatDevice(sourcePath, fstype, profile.MkfsArgs...); err != nil {
 			return status.Errorf(
 				codes.Internal,
 				"formatDevice failed: err=%v",
 				err)
 		}
 		existingFstype = fstype
+		formatted = true
 	}
 	if fstype != existingFstype {
 		return ErrMismatchedFilesystemType
 	}
+	if !formatted && (profile.Fsck == FsckCheck || profile.Fsck == FsckRepair) {
+		// A volume published read-only is never modified.
+		repair := profile.Fsck == FsckRepair && !readonly
+		if err := s.checkVolumeFilesystem(ctx, lv, sourcePath, fstype, repair); err != nil {
+			return err
+		}
+	}
 	mountOptionsStr := strings.Join(mountOptions, ",")
 	// Try to mount the volume by assuming it is correctly formatted.
 	log.Printf("Mounting %v at %v fstype=%v, flags=%v mountOptions=%v", sourcePath, targetPath, fstype, flags, mountOptionsStr)
@@ -1271,57 +1729,86 @@ func (s *Server) nodePublishVolume_Mount(sourcePath, targetPath string, readonly
 				"Failed to perform mount: err=%v",
 				err)
 		}
-		return status.Errorf(
-			codes.FailedPrecondition,
-			"Failed to perform mount: err=%v",
-			err)
+		msg := fmt.Sprintf("Failed to perform mount: err=%v", err)
+		if lines := kernelMessages(sourcePath); len(lines) > 0 {
+			msg += "\n" + strings.Join(lines, "\n")
+		}
+		return status.Error(codes.FailedPrecondition, msg)
+	}
+	if !readonly {
+		s.growMountedFilesystem(fstype, sourcePath, targetPath)
+	}
+	if mountGroup >= 0 && !readonly && !gidMountOptionFilesystems[fstype] {
+		if err := applyMountGroup(targetPath, mountGroup); err != nil {
+			// Unmount so that a retry does not find the volume
+			// published without the group.
+			if uerr := syscall.Unmount(targetPath, 0); uerr != nil {
+				log.Printf("Cannot unmount %v: err=%v", targetPath, uerr)
+			}
+			return status.Errorf(
+				codes.Internal,
+				"Cannot give group %d access to the filesystem: err=%v",
+				mountGroup, err)
+		}
 	}
 	return nil
 }
 
-func determineFilesystemType(devicePath string) (string, error) {
-	// We use `file -bsL` to determine whether any filesystem type is detected.
-	// If a filesystem is detected (ie., the output is not "data", we use
-	// `blkid` to determine what the filesystem is. We use `blkid` as `file`
-	// has inconvenient output.
-	// We do *not* use `lsblk` as that requires udev to be up-to-date which
-	// is often not the case when a device is erased using `dd`.
-	output, err := exec.Command("file", "-bsL", devicePath).CombinedOutput()
+// checkVolumeFilesystem checks, or with repair also repairs, the filesystem
+// on the device of the volume and records the result in a tag on the
+// volume. It fails if the filesystem has errors that were not repaired. A
+// filesystem that is already mounted is not checked.
+func (s *Server) checkVolumeFilesystem(ctx context.Context, lv lvm.LogicalVolumeReport, devicePath, fstype string, repair bool) error {
+	mounts, err := listMounts()
 	if err != nil {
-		return "", err
+		return status.Errorf(
+			codes.Internal,
+			"Cannot list mounts: err=%v",
+			err)
 	}
-	if strings.TrimSpace(string(output)) == "data" {
-		// No filesystem detected.
-		return "", nil
+	if isDeviceMounted(devicePath, mounts) {
+		// The volume is already published elsewhere and a mounted
+		// filesystem cannot be checked.
+		log.Printf("Not checking %v filesystem on %v as it is mounted", fstype, devicePath)
+		return nil
+	}
+	log.Printf("Checking %v filesystem on %v, repair=%v", fstype, devicePath, repair)
+	result, output, err := checkFilesystem(devicePath, fstype, repair)
+	if err == errFsckUnsupported {
+		log.Printf("Not checking %v filesystem on %v: %v", fstype, devicePath, err)
+		return nil
 	}
-	// Some filesystem was detected, we use blkid to figure out what it is.
-	output, err = exec.Command("blkid", "-c", "/dev/null", "-o", "export", devicePath).CombinedOutput()
 	if err != nil {
-		return "", err
+		return status.Errorf(
+			codes.Internal,
+			"Cannot check filesystem: err=%v: %s",
+			err, lastLines(output, 10))
 	}
-	parseErr := errors.New("Cannot parse output of blkid.")
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		fields := strings.Split(strings.TrimSpace(line), "=")
-		if len(fields) != 2 {
-			return "", parseErr
-		}
-		if fields[0] == "TYPE" {
-			return fields[1], nil
-		}
+	log.Printf("Filesystem check of %v: %v", devicePath, result)
+	add, del := fsckTagChanges(lv, result)
+	if err := s.volumeGroup.LogicalVolume(lv).ChangeTags(ctx, add, del); err != nil {
+		log.Printf("Cannot record the result of the filesystem check: err=%v", err)
 	}
-	return "", parseErr
+	if result == fsckErrors {
+		return status.Errorf(
+			codes.FailedPrecondition,
+			"The filesystem has errors that were not repaired:\n%s",
+			lastLines(output, 10))
+	}
+	return nil
 }
 
-func formatDevice(devicePath, fstype string) error {
+func formatDevice(devicePath, fstype string, mkfsArgs ...string) error {
 	// scrub the first 256k of the device to head off any mkfs probe misfires.
+	// lvcreate does not wipe volumes created with wipeSignatures=false.
 	output, err := exec.Command(
 		"dd", "if=/dev/zero", "of="+devicePath, "bs=512", "count=512", "conv=notrunc",
 	).CombinedOutput()
 	if err != nil {
 		return errors.New("csilvm: formatDevice: dd failed: err=" + err.Error() + ": " + string(output))
 	}
-	output, err = exec.Command("mkfs", "-t", fstype, devicePath).CombinedOutput()
+	args := append([]string{"-t", fstype}, mkfsArgs...)
+	output, err = exec.Command("mkfs", append(args, devicePath)...).CombinedOutput()
 	if err != nil {
 		return errors.New("csilvm: formatDevice: mkfs failed: err=" + err.Error() + ": " + string(output))
 	}
@@ -1333,10 +1820,14 @@ func (s *Server) NodeUnpublishVolume(
 	request *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	id := request.GetVolumeId()
 	log.Printf("Looking up volume with id=%v", id)
-	lv, err := s.volumeGroup.LookupLogicalVolume(id)
+	lvr, err := s.lookupPublishedVolume(ctx, id)
+	if lookupFailed(err) {
+		return nil, status.Errorf(errorCode(err), "Cannot lookup volume: err=%v", err)
+	}
 	if err != nil {
 		return nil, ErrVolumeNotFound
 	}
+	lv := s.volumeGroup.LogicalVolume(lvr)
 	targetPath := request.GetTargetPath()
 	log.Printf("Determining mount info at %v", targetPath)
 	mp, err := getMountAt(targetPath)
@@ -1350,7 +1841,19 @@ func (s *Server) NodeUnpublishVolume(
 	if mp == nil {
 		log.Printf("Nothing mounted at %v", targetPath)
 		// There is nothing mounted at targetPath, to support
-		// idempotency we return success.
+		// idempotency we return success. An ephemeral volume is
+		// removed in case an earlier attempt failed to do so.
+		if isEphemeral(lvr) {
+			if err := lv.Deactivate(ctx); err != nil {
+				return nil, status.Errorf(
+					errorCode(err),
+					"Failed to de-activate volume: err=%v",
+					err)
+			}
+			if err := s.removeEphemeralVolume(ctx, lvr); err != nil {
+				return nil, err
+			}
+		}
 		response := &csi.NodeUnpublishVolumeResponse{}
 		return response, nil
 	}
@@ -1369,12 +1872,33 @@ func (s *Server) NodeUnpublishVolume(
 			"Failed to perform unmount: err=%v",
 			err)
 	}
-	if err := lv.Deactivate(); err != nil {
+	if mp.root != "/" {
+		// The volume was published as a block device, which may
+		// have been made read-only.
+		if err := restoreDeviceReadWrite("/dev" + mp.root); err != nil {
+			return nil, status.Errorf(
+				codes.Internal,
+				"Failed to clear the read-only flag of the device: err=%v",
+				err)
+		}
+	}
+	if err := closeLUKSVolume(id); err != nil {
+		return nil, status.Errorf(
+			codes.FailedPrecondition,
+			"Failed to close encrypted volume: err=%v",
+			err)
+	}
+	if err := lv.Deactivate(ctx); err != nil {
 		return nil, status.Errorf(
-			codes.Internal,
+			errorCode(err),
 			"Failed to de-activate volume: err=%v",
 			err)
 	}
+	if isEphemeral(lvr) {
+		if err := s.removeEphemeralVolume(ctx, lvr); err != nil {
+			return nil, err
+		}
+	}
 	response := &csi.NodeUnpublishVolumeResponse{}
 	return response, nil
 }
@@ -1411,6 +1935,52 @@ func statDevice(devicePath string) error {
 	return err
 }
 
+// lookupFailed returns true if looking up a volume or volume group failed
+// for a reason other than it not existing, e.g., because the lookup timed
+// out or an lvm lock was held. Such failures must be reported to the CO
+// rather than treated as a missing volume.
+func lookupFailed(err error) bool {
+	switch errorCode(err) {
+	case codes.Internal, codes.NotFound:
+		return false
+	}
+	return true
+}
+
+// errorCode returns the gRPC status code with which to report a failed lvm
+// operation. Timeouts are reported as DeadlineExceeded so that the CO
+// retries the request.
+func errorCode(err error) codes.Code {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		return codes.Canceled
+	}
+	if st, ok := status.FromError(err); ok && err != nil {
+		// The error already carries a code, e.g., as it is the
+		// context error of withVolumeGroupLock.
+		return st.Code()
+	}
+	switch lvm.KindOf(err) {
+	case lvm.KindNotFound:
+		return codes.NotFound
+	case lvm.KindNoSpace:
+		return codes.ResourceExhausted
+	case lvm.KindLockConflict:
+		// Another lvm2 command holds the lock; the CO should retry.
+		return codes.Aborted
+	case lvm.KindDeviceBusy:
+		return codes.FailedPrecondition
+	case lvm.KindLockedByOtherHost:
+		// The other host is expected to release the lock eventually.
+		return codes.Unavailable
+	case lvm.KindMetadataError:
+		return codes.DataLoss
+	}
+	return codes.Internal
+}
+
 func calculatePVDiff(existing, pvnames []string) (missing, unexpected []string) {
 	for _, epvname := range existing {
 		had := false
@@ -1461,10 +2031,125 @@ func (s *Server) checkVolumeGroupTags(tags []string) error {
 func (s *Server) NodeGetCapabilities(
 	ctx context.Context,
 	request *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
-	response := &csi.NodeGetCapabilitiesResponse{}
+	capabilities := []*csi.NodeServiceCapability{
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		},
+	}
+	response := &csi.NodeGetCapabilitiesResponse{Capabilities: capabilities}
 	return response, nil
 }
 
+// takeFsProfileTagFromParameters removes the 'fsProfile' parameter from
+// the input and returns the tag that records it. The profile must be known
+// and match the filesystem type of any mount capability.
+func (s *Server) takeFsProfileTagFromParameters(params map[string]string, caps []*csi.VolumeCapability) (string, error) {
+	name, ok := params[paramFsProfile]
+	if !ok {
+		return "", nil
+	}
+	delete(params, paramFsProfile)
+	profile, ok := s.filesystemProfiles[name]
+	if !ok {
+		return "", fmt.Errorf("Unknown filesystem profile %q", name)
+	}
+	for _, volumeCapability := range caps {
+		if fstype := volumeCapability.GetMount().GetFsType(); fstype != "" && fstype != profile.FsType {
+			return "", fmt.Errorf("Filesystem profile %q is for %v but %v was requested", name, profile.FsType, fstype)
+		}
+	}
+	return encodeTag(tagFsProfilePlainPrefix, tagFsProfileEncodedPrefix, name), nil
+}
+
+// volumeFsProfile returns the name of the filesystem profile selected
+// when the volume with the given tags was created, if any.
+func volumeFsProfile(tags []string) (string, bool) {
+	for _, tag := range tags {
+		if name, ok := decodeTag(tagFsProfilePlainPrefix, tagFsProfileEncodedPrefix, tag); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// filesystemProfile returns the profile with which to format and mount the
+// volume with the given tags. If the volume has no profile the profile
+// named after fstype, if any, is returned. An empty fstype stands for the
+// default filesystem.
+func (s *Server) filesystemProfile(tags []string, fstype string) (FilesystemProfile, error) {
+	if name, ok := volumeFsProfile(tags); ok {
+		profile, ok := s.filesystemProfiles[name]
+		if !ok {
+			return profile, status.Errorf(codes.FailedPrecondition, "Unknown filesystem profile %q", name)
+		}
+		if fstype != "" && fstype != profile.FsType {
+			return profile, ErrMismatchedFilesystemType
+		}
+		return profile, nil
+	}
+	if fstype == "" {
+		fstype = s.supportedFilesystems[""]
+	}
+	if profile, ok := s.filesystemProfiles[fstype]; ok && profile.FsType == fstype {
+		return profile, nil
+	}
+	return FilesystemProfile{Name: fstype, FsType: fstype}, nil
+}
+
+// takeUserTagsFromParameters removes the 'tags' parameter, a comma-separated
+// list of tags, from the input and returns the corresponding LV tags.
+func takeUserTagsFromParameters(params map[string]string) (tags []string, err error) {
+	list, ok := params["tags"]
+	if !ok {
+		return nil, nil
+	}
+	delete(params, "tags")
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			return nil, errors.New("The 'tags' parameter must not contain empty tags")
+		}
+		lvtag := userTagToTag(tag)
+		if err := lvm.ValidateTag(lvtag); err != nil {
+			return nil, fmt.Errorf("The 'tags' parameter contains an invalid tag %q: err=%v", tag, err)
+		}
+		tags = append(tags, lvtag)
+	}
+	return tags, nil
+}
+
+// userTagToTag returns the user tag as is if it is a valid LV tag that
+// cannot be mistaken for one set by the plugin. Otherwise it is encoded.
+func userTagToTag(tag string) string {
+	reserved := false
+	for _, prefix := range []string{tagStatePrefix, tagVolumeNamePlainPrefix, tagVolumeNameEncodedPrefix, tagUserEncodedPrefix} {
+		if strings.HasPrefix(tag, prefix) {
+			reserved = true
+		}
+	}
+	if !reserved && lvm.ValidateTag(tag) == nil {
+		return tag
+	}
+	return tagUserEncodedPrefix + base64.RawURLEncoding.EncodeToString([]byte(tag))
+}
+
+// tagToUserTag reverses userTagToTag. Tags that were not encoded are
+// returned as is.
+func tagToUserTag(tag string) string {
+	if !strings.HasPrefix(tag, tagUserEncodedPrefix) {
+		return tag
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tag, tagUserEncodedPrefix))
+	if err != nil {
+		return tag
+	}
+	return string(buf)
+}
+
 // takeVolumeLayoutFromParameters removes and returns RAID-related parameters from the input.
 func takeVolumeLayoutFromParameters(params map[string]string) (layout lvm.VolumeLayout, err error) {
 	voltype, ok := params["type"]
@@ -1529,6 +2214,30 @@ func dupParams(in map[string]string) map[string]string {
 	return params
 }
 
+const (
+	// paramWipeSignatures determines whether lvcreate wipes signatures
+	// left on the extents of a new volume. The default is "true".
+	paramWipeSignatures = "wipeSignatures"
+	// paramZero determines whether lvcreate zeroes the start of a new
+	// volume. The default is "true".
+	paramZero = "zero"
+)
+
+// takeBoolFromParameters removes the named boolean parameter from the input
+// and returns its value, or def if it is not present.
+func takeBoolFromParameters(params map[string]string, name string, def bool) (bool, error) {
+	value, ok := params[name]
+	if !ok {
+		return def, nil
+	}
+	delete(params, name)
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("The '%s' parameter must be 'true' or 'false'", name)
+	}
+	return b, nil
+}
+
 // volumeOptsFromParameters parses volume create parameters into
 // lvm.CreateLogicalVolumeOpt funcs.  If returns an error if there are
 // unconsumed parameters or if validation fails.
@@ -1541,6 +2250,17 @@ func volumeOptsFromParameters(in map[string]string) (opts []lvm.CreateLogicalVol
 		return nil, err
 	}
 	opts = append(opts, lvm.VolumeLayoutOpt(layout))
+	// New volumes are blank unless the parameters say otherwise.
+	wipeSignatures, err := takeBoolFromParameters(params, paramWipeSignatures, true)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, lvm.WipeSignaturesOpt(wipeSignatures))
+	zero, err := takeBoolFromParameters(params, paramZero, true)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, lvm.ZeroOpt(zero))
 
 	if len(params) > 0 {
 		var keys []string
@@ -1556,6 +2276,10 @@ func volumeOptsFromParameters(in map[string]string) (opts []lvm.CreateLogicalVol
 // volumes in parallel where calls to `lvs` appear to hang.
 //
 // See https://jira.mesosphere.com/browse/DCOS_OSS-4642
+//
+// Deprecated: Use VolumeLockingInterceptor instead, which allows more
+// concurrency while lvm2 invocations remain serialized. Do not chain both as
+// every RPC would then be serialized again.
 func SerializingInterceptor() grpc.UnaryServerInterceptor {
 	// Instead of a mutex, use a weighted semaphore because it's sensitive to context cancellation and/or deadline
 	// expiration, which is important for maintaining a healthy request queue, and also helps prevent execution of
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Seagate/csiclvm/pkg/lvm"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
@@ -245,3 +246,44 @@ func TestRequestQueuingWithInterceptors(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestErrorCode(t *testing.T) {
+	testCases := []struct {
+		err  error
+		code codes.Code
+	}{
+		{errors.New("boom"), codes.Internal},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{lvm.ErrLogicalVolumeNotFound, codes.NotFound},
+		{lvm.ErrNoSpace, codes.ResourceExhausted},
+		{&lvm.CommandError{Stderr: "Can't get lock for vg"}, codes.Aborted},
+		{&lvm.CommandError{Stderr: "Logical volume vg/lv in use."}, codes.FailedPrecondition},
+		{&lvm.CommandError{Stderr: "VG vg lock failed: held by other host."}, codes.Unavailable},
+		{&lvm.CommandError{Stderr: "Checksum error at offset 4608"}, codes.DataLoss},
+		{status.FromContextError(context.DeadlineExceeded).Err(), codes.DeadlineExceeded},
+	}
+	for _, tc := range testCases {
+		if code := errorCode(tc.err); code != tc.code {
+			t.Errorf("Expected %v to map to %v but got %v", tc.err, tc.code, code)
+		}
+	}
+}
+
+func TestUserTagToTag(t *testing.T) {
+	for tag, exp := range map[string]string{
+		"backup.nightly":  "backup.nightly",
+		"tier=gold":       tagUserEncodedPrefix + "dGllcj1nb2xk",
+		"-dash":           tagUserEncodedPrefix + "LWRhc2g",
+		"VN.other-volume": tagUserEncodedPrefix + "Vk4ub3RoZXItdm9sdW1l",
+		"csilvm.created":  tagUserEncodedPrefix + "Y3NpbHZtLmNyZWF0ZWQ",
+	} {
+		got := userTagToTag(tag)
+		if got != exp {
+			t.Fatalf("Expected %q to be encoded as %q but got %q", tag, exp, got)
+		}
+		if back := tagToUserTag(got); back != tag {
+			t.Fatalf("Expected %q to decode to %q but got %q", got, tag, back)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package csilvm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/Seagate/csiclvm/pkg/cleanup"
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+// ext2/3/4 superblock fields that determine the size of the filesystem,
+// see linux/fs/ext4/ext4.h.
+const (
+	extBlocksCountLo   = extSuperblockOffset + 0x4
+	extLogBlockSize    = extSuperblockOffset + 0x18
+	extBlocksCountHi   = extSuperblockOffset + 0x150
+	extIncompat64Bit   = 0x80
+	extSuperblockBytes = 1024
+)
+
+// extFilesystemSize returns the size of the ext2/3/4 filesystem at the
+// start of the device as recorded in its superblock.
+func extFilesystemSize(r io.ReaderAt) (uint64, error) {
+	buf := make([]byte, extSuperblockOffset+extSuperblockBytes)
+	if _, err := r.ReadAt(buf, 0); err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint16(buf[extMagicOffset:]) != extMagic {
+		return 0, errors.New("csilvm: no ext2/3/4 superblock found")
+	}
+	blocks := uint64(binary.LittleEndian.Uint32(buf[extBlocksCountLo:]))
+	if binary.LittleEndian.Uint32(buf[extFeatureIncompat:])&extIncompat64Bit != 0 {
+		blocks |= uint64(binary.LittleEndian.Uint32(buf[extBlocksCountHi:])) << 32
+	}
+	blockSize := uint64(1024) << binary.LittleEndian.Uint32(buf[extLogBlockSize:])
+	return blocks * blockSize, nil
+}
+
+// ShrinkVolume shrinks the ext2/3/4 filesystem of the inactive volume and
+// then the volume itself to size, rounded up to a multiple of the extent
+// size, and returns the new size. The filesystem is checked with `e2fsck
+// -f` and shrunk with resize2fs before the volume is reduced. Any failure
+// aborts the operation before data can be lost. Volumes with xfs, which
+// cannot shrink, or without a filesystem, as well as encrypted volumes are
+// refused.
+//
+// The volume is locked like an RPC for it would be for the duration, so
+// the plugin does not publish it meanwhile. Pass the same lvm lock file as
+// the plugin to lvm.SetLockFilePath for this to work across processes.
+func ShrinkVolume(ctx context.Context, vgname, volumeID string, size uint64) (_ uint64, err error) {
+	unlock, err := lockVolume(volumeID)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	vg, err := lvm.LookupVolumeGroup(ctx, vgname)
+	if err != nil {
+		return 0, err
+	}
+	report, err := vg.Report(ctx)
+	if err != nil {
+		return 0, err
+	}
+	lvr, err := report.LogicalVolume(vgname, volumeID)
+	if err != nil {
+		return 0, err
+	}
+	if isEncrypted(lvr) {
+		return 0, fmt.Errorf("csilvm: shrinking encrypted volume %s is not supported", volumeID)
+	}
+	if lvr.Active() {
+		return 0, fmt.Errorf("csilvm: volume %s is active, it must be unpublished first", volumeID)
+	}
+	extentSize, err := vg.ExtentSize(ctx)
+	if err != nil {
+		return 0, err
+	}
+	size = (size + extentSize - 1) / extentSize * extentSize
+	if size == 0 || size >= lvr.Size {
+		return 0, fmt.Errorf("csilvm: the new size %d of volume %s must be positive and smaller than its size %d", size, volumeID, lvr.Size)
+	}
+	lv := vg.LogicalVolume(lvr)
+	log.Printf("Activating volume %s exclusively", volumeID)
+	if err := lv.ActivateExclusive(ctx); err != nil {
+		return 0, err
+	}
+	var deactivate cleanup.Rollback
+	deactivate.Add("activate volume "+volumeID, lv.Deactivate)
+	defer func() {
+		if derr := deactivate.Run(ctx); derr != nil && err == nil {
+			err = derr
+		}
+	}()
+	fstype, err := determineFilesystemType(lvr.Path)
+	if err != nil {
+		return 0, err
+	}
+	switch fstype {
+	case "ext2", "ext3", "ext4":
+	case "xfs":
+		return 0, fmt.Errorf("csilvm: volume %s has an xfs filesystem, which cannot be shrunk", volumeID)
+	case "":
+		return 0, fmt.Errorf("csilvm: volume %s has no filesystem", volumeID)
+	default:
+		return 0, fmt.Errorf("csilvm: shrinking a %s filesystem is not supported", fstype)
+	}
+	log.Printf("Checking the %s filesystem of volume %s", fstype, volumeID)
+	if output, err := exec.Command("e2fsck", "-f", "-p", lvr.Path).CombinedOutput(); err != nil {
+		// e2fsck exits with 1 if it corrected errors.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || exitErr.ExitCode() != 1 {
+			return 0, fmt.Errorf("e2fsck failed: err=%v: %s", err, lastLines(output, 10))
+		}
+	}
+	log.Printf("Shrinking the filesystem of volume %s to %d bytes", volumeID, size)
+	output, err := exec.Command("resize2fs", lvr.Path, fmt.Sprintf("%dK", size>>10)).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("resize2fs failed: err=%v: %s", err, lastLines(output, 10))
+	}
+	f, err := os.Open(lvr.Path)
+	if err != nil {
+		return 0, err
+	}
+	fsSize, err := extFilesystemSize(f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Reducing volume %s to %d bytes, its filesystem is %d bytes", volumeID, size, fsSize)
+	if err := lv.Reduce(ctx, size, fsSize); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
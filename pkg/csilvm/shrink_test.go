@@ -0,0 +1,36 @@
+package csilvm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestExtFilesystemSize(t *testing.T) {
+	// 4KiB blocks.
+	buf := extImage(0x3c, 0x2c2, 0x73)
+	binary.LittleEndian.PutUint32(buf[extLogBlockSize:], 2)
+	binary.LittleEndian.PutUint32(buf[extBlocksCountLo:], 0x40000)
+	binary.LittleEndian.PutUint32(buf[extBlocksCountHi:], 1)
+	size, err := extFilesystemSize(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := uint64(0x100040000) * 4096; size != exp {
+		t.Fatalf("Expected %d but got %d", exp, size)
+	}
+	// Without the 64bit feature the high bits are ignored.
+	buf = extImage(0x3c, 0x6, 0x3)
+	binary.LittleEndian.PutUint32(buf[extBlocksCountLo:], 1024)
+	binary.LittleEndian.PutUint32(buf[extBlocksCountHi:], 1)
+	size, err = extFilesystemSize(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 1<<20 {
+		t.Fatalf("Expected 1MiB but got %d", size)
+	}
+	if _, err := extFilesystemSize(bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Fatal("Expected an error without a superblock")
+	}
+}
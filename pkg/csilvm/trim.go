@@ -0,0 +1,202 @@
+package csilvm
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/Seagate/csiclvm/pkg/lvm"
+)
+
+const (
+	// paramDiscard selects how the blocks freed by the filesystem of a
+	// volume are discarded: "periodic", the default, by the trimmer
+	// started with TrimPeriodically or "online" by mounting the
+	// filesystem with the discard option.
+	paramDiscard    = "discard"
+	discardPeriodic = "periodic"
+	discardOnline   = "online"
+	// tagDiscardOnline marks a volume created with discard=online.
+	tagDiscardOnline   = tagStatePrefix + "discard-online"
+	mountOptionDiscard = "discard"
+)
+
+// takeDiscardTagFromParameters removes the 'discard' parameter from the
+// input and returns the tag that records online discard, or the empty
+// string.
+func takeDiscardTagFromParameters(params map[string]string) (string, error) {
+	value, ok := params[paramDiscard]
+	if !ok {
+		return "", nil
+	}
+	delete(params, paramDiscard)
+	switch value {
+	case discardPeriodic:
+		return "", nil
+	case discardOnline:
+		return tagDiscardOnline, nil
+	}
+	return "", fmt.Errorf("The 'discard' parameter must be '%s' or '%s'", discardPeriodic, discardOnline)
+}
+
+// isDiscardOnline returns true if the filesystem of the volume is to be
+// mounted with the discard option.
+func isDiscardOnline(lv lvm.LogicalVolumeReport) bool {
+	return lv.HasTag(tagDiscardOnline)
+}
+
+// fitrim is the FITRIM ioctl, _IOWR('X', 121, struct fstrim_range), see
+// linux/fs.h.
+const fitrim = 0xc0185879
+
+// trimFilesystem discards the unused blocks of the filesystem mounted at
+// path and returns the number of bytes trimmed.
+func trimFilesystem(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	// struct fstrim_range { start, len, minlen }. On return len holds the
+	// number of bytes trimmed.
+	rng := [3]uint64{0, math.MaxUint64, 0}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fitrim, uintptr(unsafe.Pointer(&rng)))
+	if errno != 0 {
+		return 0, fmt.Errorf("FITRIM failed: %v", errno)
+	}
+	return rng[1], nil
+}
+
+// volumeIDFromDevice returns the ID of the volume in the volume group whose
+// device, or the device of whose open LUKS mapping, is at devicePath.
+func (s *Server) volumeIDFromDevice(devicePath string) (string, bool) {
+	var name string
+	switch dir, base := filepath.Split(devicePath); {
+	case dir == "/dev/"+s.vgname+"/":
+		name = base
+	case dir == mapperDir+"/" && strings.HasPrefix(base, luksMapping("")):
+		name = strings.TrimPrefix(base, luksMapping(""))
+	case dir == mapperDir+"/":
+		// device-mapper doubles the hyphens in volume group and logical
+		// volume names and joins them with a single hyphen.
+		prefix := strings.Replace(s.vgname, "-", "--", -1) + "-"
+		if !strings.HasPrefix(base, prefix) {
+			return "", false
+		}
+		name = strings.TrimPrefix(base, prefix)
+	default:
+		return "", false
+	}
+	if !strings.HasPrefix(name, lvPrefix) || strings.Contains(name, "-") {
+		return "", false
+	}
+	return name, true
+}
+
+// trimTargets returns a writable mount point of each filesystem volume of
+// the volume group that is mounted without the discard option, by volume
+// ID.
+func (s *Server) trimTargets(mounts []mountpoint) map[string]string {
+	targets := make(map[string]string)
+	for _, mp := range mounts {
+		id, ok := s.volumeIDFromDevice(mp.mountsource)
+		if !ok || mp.isReadonly() || mp.hasSuperOption(mountOptionDiscard) {
+			continue
+		}
+		if _, ok := targets[id]; !ok {
+			targets[id] = mp.path
+		}
+	}
+	return targets
+}
+
+// trimVolumes issues FITRIM on each filesystem volume mounted on this node.
+func (s *Server) trimVolumes() {
+	mounts, err := listMounts()
+	if err != nil {
+		log.Printf("Cannot trim volumes: cannot list mounts: err=%v", err)
+		return
+	}
+	counter := s.metrics.Counter("bytes-trimmed")
+	for id, path := range s.trimTargets(mounts) {
+		trimmed, err := s.trimVolume(id, path)
+		if err == errVolumeBusy {
+			log.Printf("Not trimming volume %v as an operation on it is in progress", id)
+			continue
+		}
+		if err != nil {
+			log.Printf("Cannot trim volume %v at %v: err=%v", id, path, err)
+			continue
+		}
+		log.Printf("Trimmed %d bytes of volume %v at %v", trimmed, id, path)
+		counter.Inc(int64(trimmed))
+	}
+}
+
+// trimVolume trims the filesystem of the volume mounted at path. It locks
+// the volume like an RPC for it so that the volume is not, e.g., unpublished
+// or shrunk meanwhile, and fails with errVolumeBusy if an RPC holds the lock.
+func (s *Server) trimVolume(id, path string) (uint64, error) {
+	key := "id:" + id
+	if err := inflightVolumes.tryLock(key); err != nil {
+		return 0, err
+	}
+	defer inflightVolumes.unlock(key)
+	// The volume may have been unpublished since the mounts were listed.
+	mp, err := getMountAt(path)
+	if err != nil {
+		return 0, err
+	}
+	if mp == nil {
+		return 0, fmt.Errorf("csilvm: %v is no longer mounted", path)
+	}
+	if mounted, ok := s.volumeIDFromDevice(mp.mountsource); !ok || mounted != id {
+		return 0, fmt.Errorf("csilvm: volume %v is no longer mounted at %v", id, path)
+	}
+	return trimFilesystem(path)
+}
+
+// trimDelay returns the interval plus a random jitter of up to a tenth of
+// it, so that the nodes sharing a volume group do not trim at once.
+func trimDelay(interval time.Duration) time.Duration {
+	jitter := int64(interval) / 10
+	if jitter <= 0 {
+		return interval
+	}
+	return interval + time.Duration(rand.Int63n(jitter))
+}
+
+// TrimPeriodically trims the filesystems of the volumes mounted on this node
+// about every interval until the returned function is called. Volumes
+// created with discard=online are mounted with the discard option instead
+// and are skipped, as are read-only mounts.
+func (s *Server) TrimPeriodically(interval time.Duration) func() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+	go func() {
+		defer wg.Done()
+		timer := time.NewTimer(trimDelay(interval))
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				s.trimVolumes()
+				timer.Reset(trimDelay(interval))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,66 @@
+package csilvm
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTakeDiscardTagFromParameters(t *testing.T) {
+	for value, exp := range map[string]string{discardPeriodic: "", discardOnline: tagDiscardOnline} {
+		params := map[string]string{paramDiscard: value}
+		tag, err := takeDiscardTagFromParameters(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag != exp {
+			t.Fatalf("Expected %q for %q but got %q", exp, value, tag)
+		}
+		if len(params) != 0 {
+			t.Fatalf("Expected the parameter to be consumed but got %v", params)
+		}
+	}
+	if _, err := takeDiscardTagFromParameters(map[string]string{paramDiscard: "always"}); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestTrimTargets(t *testing.T) {
+	s := &Server{vgname: "my-vg"}
+	mounts := []mountpoint{
+		{path: "/a", mountsource: "/dev/mapper/my--vg-csilva", mountopts: []string{"rw"}},
+		// The same filesystem is only trimmed once.
+		{path: "/a2", mountsource: "/dev/mapper/my--vg-csilva", mountopts: []string{"rw"}},
+		{path: "/b", mountsource: "/dev/my-vg/csilvb", mountopts: []string{"rw"}},
+		{path: "/c", mountsource: "/dev/mapper/csilvm-csilvc", mountopts: []string{"rw"}},
+		{path: "/ro", mountsource: "/dev/my-vg/csilvro", mountopts: []string{"ro"}},
+		{path: "/online", mountsource: "/dev/my-vg/csilvonline", mountopts: []string{"rw"}, superopts: []string{"rw", "discard"}},
+		{path: "/other-vg", mountsource: "/dev/mapper/other-csilvd", mountopts: []string{"rw"}},
+		{path: "/other-lv", mountsource: "/dev/my-vg/root", mountopts: []string{"rw"}},
+		{path: "/snapshot", mountsource: "/dev/mapper/my--vg-csilve-cow", mountopts: []string{"rw"}},
+	}
+	exp := map[string]string{"csilva": "/a", "csilvb": "/b", "csilvc": "/c"}
+	if targets := s.trimTargets(mounts); !reflect.DeepEqual(targets, exp) {
+		t.Fatalf("Expected %v but got %v", exp, targets)
+	}
+}
+
+func TestTrimVolumeBusy(t *testing.T) {
+	s := &Server{vgname: "my-vg"}
+	unlock, err := lockVolume("csilva")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+	if _, err := s.trimVolume("csilva", "/a"); err != errVolumeBusy {
+		t.Fatalf("Expected %v but got %v", errVolumeBusy, err)
+	}
+}
+
+func TestTrimDelay(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		if d := trimDelay(time.Hour); d < time.Hour || d >= time.Hour+6*time.Minute {
+			t.Fatalf("Unexpected delay %v", d)
+		}
+	}
+}
@@ -207,7 +207,7 @@ func validateVolumeCapabilities(volumeCapabilities []*csi.VolumeCapability, supp
 	}
 	for _, volumeCapability := range volumeCapabilities {
 		const treatUnsupportedFsAsError = false
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError, false); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError); err != nil {
 			return err
 		}
 	}
@@ -229,11 +229,8 @@ var ErrInvalidAccessMode = status.Error(
 var ErrUnsupportedAccessMode = status.Error(
 	codes.InvalidArgument,
 	"The volume_capability.access_mode.mode is unsupported.")
-var ErrBlockVolNoRO = status.Error(
-	codes.InvalidArgument,
-	"Cannot publish block volume as readonly.")
 
-func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedFilesystems map[string]string, unsupportedFsOK, readonly bool) error {
+func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedFilesystems map[string]string, unsupportedFsOK bool) error {
 	accessType := volumeCapability.GetAccessType()
 	if accessType == nil {
 		return ErrMissingAccessType
@@ -247,13 +244,6 @@ func validateVolumeCapability(volumeCapability *csi.VolumeCapability, supportedF
 			return ErrUnsupportedFilesystem
 		}
 	}
-	if block := volumeCapability.GetBlock(); block != nil {
-		readonly = readonly || volumeCapability.GetAccessMode().GetMode() == csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
-		if readonly {
-			// A block device cannot be bind mounted readonly.
-			return ErrBlockVolNoRO
-		}
-	}
 	accessMode := volumeCapability.GetAccessMode()
 	if accessMode == nil {
 		return ErrMissingAccessMode
@@ -305,7 +295,7 @@ func validateGetCapacityRequest(request *csi.GetCapacityRequest, supportedFilesy
 		// We don't treat "unsupported fs type" as an error for
 		// GetCapacity. We'll just return 0 capacity.
 		const ignoreUnsupportedFs = true
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, ignoreUnsupportedFs, false); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, ignoreUnsupportedFs); err != nil {
 			return err
 		}
 	}
@@ -349,6 +339,12 @@ func (v *controllerServerValidator) ControllerExpandVolume(
 	return v.inner.ControllerExpandVolume(ctx, request)
 }
 
+func (v *controllerServerValidator) ControllerGetVolume(
+	ctx context.Context,
+	request *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	return v.inner.ControllerGetVolume(ctx, request)
+}
+
 // NodeService RPCs
 
 type nodeServerValidator struct {
@@ -373,6 +369,7 @@ func (v *nodeServerValidator) NodePublishVolume(
 var ErrMissingTargetPath = status.Error(codes.InvalidArgument, "The target_path field must be specified.")
 var ErrMissingVolumeCapability = status.Error(codes.InvalidArgument, "The volume_capability field must be specified.")
 var ErrSpecifiedPublishContext = status.Error(codes.InvalidArgument, "The publish_volume_context field must not be specified.")
+var ErrEphemeralBlock = status.Error(codes.InvalidArgument, "Ephemeral volumes must be published with the mount access type.")
 
 func validateNodePublishVolumeRequest(request *csi.NodePublishVolumeRequest, removingVolumeGroup bool, supportedFilesystems map[string]string) error {
 	if err := validateRemoving(removingVolumeGroup); err != nil {
@@ -395,10 +392,12 @@ func validateNodePublishVolumeRequest(request *csi.NodePublishVolumeRequest, rem
 		return ErrMissingVolumeCapability
 	} else {
 		const treatUnsupportedFsAsError = false
-		readonly := request.GetReadonly()
-		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError, readonly); err != nil {
+		if err := validateVolumeCapability(volumeCapability, supportedFilesystems, treatUnsupportedFsAsError); err != nil {
 			return err
 		}
+		if isEphemeralRequest(request.GetVolumeContext()) && volumeCapability.GetMount() == nil {
+			return ErrEphemeralBlock
+		}
 	}
 	return nil
 }
@@ -105,17 +105,6 @@ func TestCreateVolumeVolumeCapabilitiesAccessModeInvalid(t *testing.T) {
 	}
 }
 
-func TestCreateVolumeVolumeCapabilitiesReadonlyBlock(t *testing.T) {
-	client, cleanup := startTestValidate()
-	defer cleanup()
-	req := testCreateVolumeRequest()
-	req.VolumeCapabilities[0].AccessMode.Mode = csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY
-	_, err := client.CreateVolume(context.Background(), req)
-	if !grpcErrorEqual(err, ErrBlockVolNoRO) {
-		t.Fatal(err)
-	}
-}
-
 func TestCreateVolumeVolumeCapabilitiesCapacityRangeRequiredLessThanLimit(t *testing.T) {
 	client, cleanup := startTestValidate()
 	defer cleanup()
@@ -0,0 +1,230 @@
+package lvm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// CommandError is returned when an lvm2 command-line utility exits with a
+// non-zero status.
+type CommandError struct {
+	// Cmd is the name of the utility that was invoked, e.g., "lvcreate".
+	Cmd string
+	// Args are the command-line arguments the utility was invoked with.
+	Args []string
+	// ExitStatus is the exit status of the utility or -1 if it is not
+	// known.
+	ExitStatus int
+	// Stderr is what the utility wrote to stderr, with warnings removed.
+	Stderr string
+}
+
+// Error returns the stderr output of the failed command as that is what
+// lvm2 uses to describe the problem.
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("lvm: %s: exit status %d", e.Cmd, e.ExitStatus)
+	}
+	return e.Stderr
+}
+
+// Kind classifies the failure based on the lvm2 error messages found on
+// stderr.
+func (e *CommandError) Kind() ErrorKind {
+	for _, c := range errorCatalogue {
+		if c.match(e.Stderr) {
+			return c.kind
+		}
+	}
+	return KindUnknown
+}
+
+// ErrorKind is a class of lvm2 failure that callers may want to handle
+// differently from others.
+type ErrorKind int
+
+const (
+	// KindUnknown is any failure not recognised as one of the others.
+	KindUnknown ErrorKind = iota
+	// KindNotFound indicates that a physical volume, volume group or
+	// logical volume does not exist.
+	KindNotFound
+	// KindNoSpace indicates that there are not enough free extents or
+	// not enough devices to satisfy an allocation.
+	KindNoSpace
+	// KindLockConflict indicates that lvm2 could not acquire one of its
+	// own locks, typically as another lvm2 command holds it.
+	KindLockConflict
+	// KindDeviceBusy indicates that a device is open or mounted.
+	KindDeviceBusy
+	// KindLockedByOtherHost indicates that the volume group or logical
+	// volume is locked by, or belongs to, another host.
+	KindLockedByOtherHost
+	// KindMetadataError indicates that the lvm2 metadata on a device could
+	// not be read or is corrupt.
+	KindMetadataError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not found"
+	case KindNoSpace:
+		return "no space"
+	case KindLockConflict:
+		return "lock conflict"
+	case KindDeviceBusy:
+		return "device busy"
+	case KindLockedByOtherHost:
+		return "locked by other host"
+	case KindMetadataError:
+		return "metadata error"
+	default:
+		return "unknown"
+	}
+}
+
+// KindOf returns the ErrorKind of err. Besides a *CommandError it
+// recognises the errors defined by this package. It returns KindUnknown for
+// any other error, including nil.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return KindUnknown
+	}
+	switch err {
+	case ErrLogicalVolumeNotFound, ErrVolumeGroupNotFound, ErrPhysicalVolumeNotFound:
+		return KindNotFound
+	case ErrNoSpace, ErrTooFewDisks:
+		return KindNoSpace
+	}
+	var cerr *CommandError
+	if errors.As(err, &cerr) {
+		return cerr.Kind()
+	}
+	return KindUnknown
+}
+
+type errorClass struct {
+	kind  ErrorKind
+	match func(stderr string) bool
+}
+
+// errorCatalogue lists the lvm2 error messages we know how to classify.
+// The order matters: a busy or locked device can also cause a lookup to
+// fail, so the more specific conditions are checked first.
+var errorCatalogue = []errorClass{
+	{KindLockedByOtherHost, hasLineContaining(
+		"held by other host",
+		"locked by other host",
+		"Cannot access VG",
+		"Skipping foreign volume group",
+	)},
+	{KindLockConflict, hasLineContaining(
+		"Can't get lock for",
+		"Giving up waiting for lock",
+		"lock already held",
+	)},
+	{KindDeviceBusy, hasLineContaining(
+		"Device or resource busy",
+		"Can't remove open logical volume",
+		"contains a filesystem in use",
+		"Mounted filesystem?",
+		" in use.",
+	)},
+	{KindMetadataError, hasLineContaining(
+		"Failed to read metadata",
+		"Failed to read mda header",
+		"Checksum error",
+		"bad metadata header",
+		"Couldn't read volume group metadata",
+	)},
+	{KindNotFound, func(stderr string) bool {
+		return isLogicalVolumeNotFound(stderr) ||
+			isVolumeGroupNotFound(stderr) ||
+			isPhysicalVolumeNotFound(stderr) ||
+			isNoPhysicalVolumeLabel(stderr)
+	}},
+	{KindNoSpace, func(stderr string) bool {
+		return isInsufficientSpace(stderr) || isInsufficientDevices(stderr)
+	}},
+}
+
+// stderrOf returns the lvm2 error output carried by err. Errors that did
+// not originate from a command are returned verbatim.
+func stderrOf(err error) string {
+	var cerr *CommandError
+	if errors.As(err, &cerr) {
+		return cerr.Stderr
+	}
+	return err.Error()
+}
+
+func hasLineContaining(substrs ...string) func(string) bool {
+	return func(stderr string) bool {
+		for _, line := range strings.Split(stderr, "\n") {
+			for _, s := range substrs {
+				if strings.Contains(line, s) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+func hasLinePrefix(stderr, prefix string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isInsufficientSpace returns true if the error is due to insufficient space
+func isInsufficientSpace(stderr string) bool {
+	return strings.Contains(strings.ToLower(stderr), "insufficient free space")
+}
+
+// isInsufficientDevices returns true if the error is due to insufficient underlying devices
+func isInsufficientDevices(stderr string) bool {
+	return strings.Contains(stderr, "Insufficient suitable allocatable extents for logical volume")
+}
+
+func isLogicalVolumeNotFound(stderr string) bool {
+	return hasLinePrefix(stderr, "Failed to find logical volume")
+}
+
+func isPhysicalVolumeNotFound(stderr string) bool {
+	return hasLinePrefix(stderr, "Failed to find device")
+}
+
+func isNoPhysicalVolumeLabel(stderr string) bool {
+	return hasLinePrefix(stderr, "No physical volume label read from")
+}
+
+func isVolumeGroupNotFound(stderr string) bool {
+	const prefix = "Volume group"
+	const suffix = "not found"
+	for _, line := range strings.Split(stderr, "\n") {
+		if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func IsLogicalVolumeNotFound(err error) bool {
+	return isLogicalVolumeNotFound(stderrOf(err))
+}
+
+func IsPhysicalVolumeNotFound(err error) bool {
+	stderr := stderrOf(err)
+	return isPhysicalVolumeNotFound(stderr) ||
+		isNoPhysicalVolumeLabel(stderr)
+}
+
+func IsVolumeGroupNotFound(err error) bool {
+	return isVolumeGroupNotFound(stderrOf(err))
+}
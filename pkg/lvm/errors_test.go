@@ -0,0 +1,83 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCommandErrorKind(t *testing.T) {
+	testCases := []struct {
+		stderr string
+		kind   ErrorKind
+	}{
+		{`Failed to find logical volume "vg/lv"`, KindNotFound},
+		{"Volume group \"vg\" not found\n  Cannot process volume group vg", KindNotFound},
+		{`Failed to find device for physical volume "/dev/sdz".`, KindNotFound},
+		{"No physical volume label read from /dev/sdz", KindNotFound},
+		{`Volume group "vg" has insufficient free space (10 extents): 20 required.`, KindNoSpace},
+		{"Insufficient suitable allocatable extents for logical volume lv: 20 more required", KindNoSpace},
+		{"Can't get lock for vg", KindLockConflict},
+		{"Giving up waiting for lock.", KindLockConflict},
+		{"Logical volume vg/lv contains a filesystem in use.", KindDeviceBusy},
+		{"Can't open /dev/sdz exclusively.  Mounted filesystem?", KindDeviceBusy},
+		{"Logical volume vg/lv in use.", KindDeviceBusy},
+		{"VG vg lock failed: held by other host.", KindLockedByOtherHost},
+		{"Cannot access VG vg with system ID other-host with local system ID this-host.", KindLockedByOtherHost},
+		{"Failed to read metadata area header on /dev/sdz at 4096", KindMetadataError},
+		{"Checksum error at offset 4608", KindMetadataError},
+		{"Something unexpected happened", KindUnknown},
+	}
+	for _, tc := range testCases {
+		err := &CommandError{Cmd: "lvs", ExitStatus: 5, Stderr: tc.stderr}
+		if kind := KindOf(err); kind != tc.kind {
+			t.Errorf("Expected %q to be classified as %v but got %v", tc.stderr, tc.kind, kind)
+		}
+		// Wrapping the error must not hide its kind.
+		wrapped := fmt.Errorf("lvm: CreatePhysicalVolume: %w", err)
+		if kind := KindOf(wrapped); kind != tc.kind {
+			t.Errorf("Expected wrapped %q to be classified as %v but got %v", tc.stderr, tc.kind, kind)
+		}
+	}
+}
+
+func TestKindOfPackageErrors(t *testing.T) {
+	testCases := []struct {
+		err  error
+		kind ErrorKind
+	}{
+		{nil, KindUnknown},
+		{errors.New("boom"), KindUnknown},
+		{ErrLogicalVolumeNotFound, KindNotFound},
+		{ErrVolumeGroupNotFound, KindNotFound},
+		{ErrPhysicalVolumeNotFound, KindNotFound},
+		{ErrNoSpace, KindNoSpace},
+		{ErrTooFewDisks, KindNoSpace},
+	}
+	for _, tc := range testCases {
+		if kind := KindOf(tc.err); kind != tc.kind {
+			t.Errorf("Expected %v to be classified as %v but got %v", tc.err, tc.kind, kind)
+		}
+	}
+}
+
+func TestFakeCommandError(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	lv := &LogicalVolume{"missing", 0, vg}
+	err := lv.Remove(context.Background())
+	var cerr *CommandError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("Expected a *CommandError but got %T: %v", err, err)
+	}
+	if cerr.Cmd != "lvremove" {
+		t.Fatalf("Expected command lvremove but got %v", cerr.Cmd)
+	}
+	if cerr.ExitStatus != 5 {
+		t.Fatalf("Expected exit status 5 but got %d", cerr.ExitStatus)
+	}
+	if !IsLogicalVolumeNotFound(err) || cerr.Kind() != KindNotFound {
+		t.Fatalf("Expected a not found error but got %v", err)
+	}
+}
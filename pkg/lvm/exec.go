@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// DefaultCommandTimeout is the maximum amount of time a single LVM2
+// command-line utility invocation may take unless configured otherwise
+// using SetCommandTimeout.
+const DefaultCommandTimeout = 2 * time.Minute
+
+// killGracePeriod is how long we wait for a killed command to be reaped
+// before we stop waiting for it. A process stuck in uninterruptible sleep
+// cannot be reaped, and we must not hold the lvm lock on its behalf forever.
+const killGracePeriod = 5 * time.Second
+
+// lockRetryDelay is the interval at which we poll for the lvm lock.
+const lockRetryDelay = 10 * time.Millisecond
+
+var commandTimeout = DefaultCommandTimeout
+
+// SetCommandTimeout sets the maximum duration of a single LVM2 command-line
+// utility invocation. The context passed to the functions in this package
+// may impose a shorter deadline. A non-positive value restores the default.
+func SetCommandTimeout(d time.Duration) {
+	if d <= 0 {
+		d = DefaultCommandTimeout
+	}
+	commandTimeout = d
+}
+
+// Executor runs a single LVM2 command-line utility invocation and returns
+// what it wrote to stdout and stderr. A non-nil error indicates that the
+// command failed, typically by exiting with a non-zero status.
+//
+// Implementations must abandon the command and return promptly once ctx is
+// done.
+//
+// The default Executor forks the named utility using os/exec. Tests and
+// alternative backends may replace it using SetExecutor.
+type Executor interface {
+	Execute(ctx context.Context, cmd string, args ...string) (stdout, stderr []byte, err error)
+}
+
+// execExecutor is the default Executor. It runs every command as a
+// separate process.
+type execExecutor struct{}
+
+func (execExecutor) Execute(ctx context.Context, cmd string, args ...string) ([]byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	c := exec.Command(cmd, args...)
+	// The command is placed in its own process group so that any
+	// children it spawns are killed along with it.
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	log.Printf("Executing: %v", c)
+	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	if err := c.Start(); err != nil {
+		return nil, nil, err
+	}
+	waitc := make(chan error, 1)
+	go func() { waitc <- c.Wait() }()
+	select {
+	case err := <-waitc:
+		return stdout.Bytes(), stderr.Bytes(), err
+	case <-ctx.Done():
+	}
+	log.Printf("Killing %v: %v", c, ctx.Err())
+	if err := syscall.Kill(-c.Process.Pid, syscall.SIGKILL); err != nil {
+		log.Printf("Failed to kill process group %d: %v", c.Process.Pid, err)
+	}
+	select {
+	case <-waitc:
+	case <-time.After(killGracePeriod):
+		log.Printf("Gave up waiting for %v to exit", c)
+	}
+	return nil, nil, ctx.Err()
+}
+
+// exitStatusError mimics the *exec.ExitError returned when a command exits
+// with a non-zero status. It is used by Executors that do not run every
+// command as a separate process.
+type exitStatusError int
+
+func (e exitStatusError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitStatusError) ExitCode() int { return int(e) }
+
+var executor Executor = execExecutor{}
+
+// SetExecutor configures the Executor used to invoke LVM2 command-line
+// utilities. Passing nil restores the default, process-spawning Executor.
+func SetExecutor(e Executor) {
+	if e == nil {
+		e = execExecutor{}
+	}
+	executor = e
+}
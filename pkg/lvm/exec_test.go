@@ -0,0 +1,22 @@
+package lvm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecExecutorKillsProcessGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	// The shell forks a child that would outlive the shell itself if only
+	// the shell were killed, keeping stdout open.
+	_, _, err := execExecutor{}.Execute(ctx, "sh", "-c", "sleep 10 & sleep 10")
+	if err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > killGracePeriod {
+		t.Fatalf("Expected command to be killed promptly but it took %v", elapsed)
+	}
+}
@@ -0,0 +1,867 @@
+package lvm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file provides an in-memory emulation of the LVM2 command-line
+// utilities used by this package. It allows code built on this package to be
+// tested without root privileges, loop devices or an lvm2 installation.
+
+// DefaultFakeExtentSize is the extent size of volume groups created by
+// FakeExecutor. It matches the lvm2 default of 4MiB.
+const DefaultFakeExtentSize = 4 << 20
+
+// errFakeCommandFailed is returned by FakeExecutor along with a message on
+// stderr. lvm2 exits with status 5 (ECMD_FAILED) on most failures.
+var errFakeCommandFailed error = exitStatusError(5)
+
+type fakePV struct {
+	name string
+	size uint64
+	vg   string
+	// used is the number of extents on this physical volume that are
+	// allocated to logical volumes.
+	used uint64
+}
+
+type fakeVG struct {
+	name       string
+	uuid       string
+	extentSize uint64
+	tags       []string
+	pvs        []string
+	lvs        []*fakeLV
+}
+
+type fakeLV struct {
+	name    string
+	extents uint64
+	tags    []string
+	active  bool
+	layout  string
+	// alloc maps physical volume names to the number of extents this
+	// logical volume (including RAID metadata subvolumes) occupies on it.
+	alloc map[string]uint64
+}
+
+// FakeExecutor is an Executor that keeps physical volumes, volume groups and
+// logical volumes in memory. It understands the subset of `pvs`, `vgs`,
+// `lvs`, `pvcreate`, `vgcreate`, `lvcreate`, `lvchange`, `lvreduce`, `lvremove`,
+// `vgremove` and `pvremove` behaviour that this package relies on, including
+// extent allocation, tags and the number of devices required by RAID
+// layouts.
+//
+// The zero value is not usable; create instances with NewFakeExecutor.
+type FakeExecutor struct {
+	mu       sync.Mutex
+	devices  map[string]uint64
+	pvs      map[string]*fakePV
+	vgs      map[string]*fakeVG
+	nextUUID int
+	commands [][]string
+	latency  time.Duration
+	failures map[string]string
+}
+
+// NewFakeExecutor returns an empty FakeExecutor. Block devices must be
+// registered using AddDevice before they can be used as physical volumes.
+func NewFakeExecutor() *FakeExecutor {
+	return &FakeExecutor{
+		devices: make(map[string]uint64),
+		pvs:     make(map[string]*fakePV),
+		vgs:     make(map[string]*fakeVG),
+	}
+}
+
+// AddDevice registers a block device of the given size in bytes.
+func (f *FakeExecutor) AddDevice(path string, size uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devices[path] = size
+}
+
+// RemoveDevice unregisters a block device, as if it had been unplugged. Any
+// physical volume on it disappears along with it.
+func (f *FakeExecutor) RemoveDevice(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.devices, path)
+	if pv, ok := f.pvs[path]; ok {
+		if vg, ok := f.vgs[pv.vg]; ok {
+			vg.pvs = removeString(vg.pvs, path)
+		}
+		delete(f.pvs, path)
+	}
+}
+
+// Commands returns every command executed so far, each given as the
+// command name followed by its arguments.
+func (f *FakeExecutor) Commands() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cmds := make([][]string, len(f.commands))
+	copy(cmds, f.commands)
+	return cmds
+}
+
+// SetLatency makes every subsequent command take at least d to complete,
+// which allows tests to exercise timeouts and cancellation.
+func (f *FakeExecutor) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.latency = d
+}
+
+// FailNext makes the next command whose command line contains match, e.g.,
+// "lvchange -an", fail with the given message on stderr without changing
+// any state.
+func (f *FakeExecutor) FailNext(match, stderr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures == nil {
+		f.failures = make(map[string]string)
+	}
+	f.failures[match] = stderr
+}
+
+// Execute implements Executor.
+func (f *FakeExecutor) Execute(ctx context.Context, cmd string, args ...string) ([]byte, []byte, error) {
+	f.mu.Lock()
+	latency := f.latency
+	f.mu.Unlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, append([]string{cmd}, args...))
+	cmdline := strings.Join(append([]string{cmd}, args...), " ")
+	for match, stderr := range f.failures {
+		if strings.Contains(cmdline, match) {
+			delete(f.failures, match)
+			return nil, []byte(stderr + "\n"), errFakeCommandFailed
+		}
+	}
+	fa := parseFakeArgs(args)
+	var (
+		out interface{}
+		err error
+	)
+	switch cmd {
+	case "pvs":
+		out, err = f.pvsCmd(fa)
+	case "vgs":
+		out, err = f.vgsCmd(fa)
+	case "lvs":
+		out, err = f.lvsCmd(fa)
+	case "pvcreate":
+		err = f.pvcreate(fa)
+	case "pvremove":
+		err = f.pvremove(fa)
+	case "pvck":
+		err = f.pvck(fa)
+	case "vgcreate":
+		err = f.vgcreate(fa)
+	case "vgremove":
+		err = f.vgremove(fa)
+	case "vgck":
+		err = f.vgck(fa)
+	case "lvcreate":
+		err = f.lvcreate(fa)
+	case "lvremove":
+		err = f.lvremove(fa)
+	case "lvchange":
+		err = f.lvchange(fa)
+	case "lvreduce":
+		err = f.lvreduce(fa)
+	case "lvm":
+		out, err = f.lvmCmd(fa)
+	case "pvscan", "vgscan", "partprobe":
+		// Nothing is cached so there is nothing to rescan.
+	default:
+		err = fmt.Errorf("  No such command '%s'.  Try 'help'.", cmd)
+	}
+	if err != nil {
+		return nil, []byte(err.Error() + "\n"), errFakeCommandFailed
+	}
+	if out == nil {
+		return nil, nil, nil
+	}
+	buf, merr := json.Marshal(out)
+	if merr != nil {
+		return nil, []byte(merr.Error()), errFakeCommandFailed
+	}
+	return buf, nil, nil
+}
+
+type fakeArgs struct {
+	flags map[string][]string
+	pos   []string
+}
+
+func (a fakeArgs) has(name string) bool {
+	_, ok := a.flags[name]
+	return ok
+}
+
+func (a fakeArgs) get(name string) string {
+	if v := a.flags[name]; len(v) > 0 {
+		return v[len(v)-1]
+	}
+	return ""
+}
+
+func parseFakeArgs(args []string) fakeArgs {
+	fa := fakeArgs{flags: make(map[string][]string)}
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			fa.pos = append(fa.pos, arg)
+			continue
+		}
+		name, value := arg, ""
+		if i := strings.Index(arg, "="); i >= 0 {
+			name, value = arg[:i], arg[i+1:]
+		}
+		fa.flags[name] = append(fa.flags[name], value)
+	}
+	return fa
+}
+
+func (f *FakeExecutor) pvsCmd(fa fakeArgs) (interface{}, error) {
+	var names []string
+	if len(fa.pos) > 0 {
+		for _, name := range fa.pos {
+			if _, ok := f.pvs[name]; !ok {
+				return nil, fmt.Errorf("  Failed to find device for physical volume \"%s\".", name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range f.pvs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var items []map[string]string
+	for _, name := range names {
+		items = append(items, f.pvItem(f.pvs[name]))
+	}
+	return fakeSelectedReport(fa, "pv", items)
+}
+
+func (f *FakeExecutor) pvItem(pv *fakePV) map[string]string {
+	var free uint64
+	if vg, ok := f.vgs[pv.vg]; ok {
+		free = (f.pvExtents(vg, pv) - pv.used) * vg.extentSize
+	}
+	return map[string]string{
+		"pv_name": pv.name,
+		"vg_name": pv.vg,
+		"pv_size": strconv.FormatUint(pv.size, 10),
+		"pv_free": strconv.FormatUint(free, 10),
+	}
+}
+
+func (f *FakeExecutor) vgsCmd(fa fakeArgs) (interface{}, error) {
+	var names []string
+	if len(fa.pos) > 0 {
+		for _, name := range fa.pos {
+			if _, ok := f.vgs[name]; !ok {
+				return nil, fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", name, name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range f.vgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var items []map[string]string
+	for _, name := range names {
+		items = append(items, f.vgItem(f.vgs[name]))
+	}
+	return fakeSelectedReport(fa, "vg", items)
+}
+
+func (f *FakeExecutor) vgItem(vg *fakeVG) map[string]string {
+	total, free := f.vgExtents(vg)
+	return map[string]string{
+		"vg_name":         vg.name,
+		"vg_uuid":         vg.uuid,
+		"vg_size":         strconv.FormatUint(total*vg.extentSize, 10),
+		"vg_free":         strconv.FormatUint(free*vg.extentSize, 10),
+		"vg_extent_size":  strconv.FormatUint(vg.extentSize, 10),
+		"vg_extent_count": strconv.FormatUint(total, 10),
+		"vg_free_count":   strconv.FormatUint(free, 10),
+		"vg_tags":         strings.Join(vg.tags, ","),
+	}
+}
+
+// lvmCmd emulates the `lvm` binary. Only the fullreport command is
+// supported.
+func (f *FakeExecutor) lvmCmd(fa fakeArgs) (interface{}, error) {
+	if len(fa.pos) == 0 || fa.pos[0] != "fullreport" {
+		return nil, errors.New("  Unsupported lvm command.")
+	}
+	var names []string
+	if len(fa.pos) > 1 {
+		for _, name := range fa.pos[1:] {
+			if _, ok := f.vgs[name]; !ok {
+				return nil, fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", name, name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		for name := range f.vgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	// Like lvm2, every volume group is reported separately.
+	reports := []map[string]interface{}{}
+	for _, name := range names {
+		vg := f.vgs[name]
+		pvs, lvs, segs := []map[string]string{}, []map[string]string{}, []map[string]string{}
+		for _, dev := range vg.pvs {
+			pvs = append(pvs, f.pvItem(f.pvs[dev]))
+		}
+		for _, lv := range vg.lvs {
+			item := f.lvItem(vg, lv)
+			lvs = append(lvs, item)
+			segs = append(segs, map[string]string{
+				"lv_name":   lv.name,
+				"vg_name":   vg.name,
+				"segtype":   lv.layout,
+				"seg_start": "0",
+				"seg_size":  item["lv_size"],
+				"devices":   item["devices"],
+			})
+		}
+		reports = append(reports, map[string]interface{}{
+			"vg":    []map[string]string{f.vgItem(vg)},
+			"pv":    pvs,
+			"lv":    lvs,
+			"pvseg": []map[string]string{},
+			"seg":   segs,
+		})
+	}
+	return map[string]interface{}{"report": reports}, nil
+}
+
+func (f *FakeExecutor) lvsCmd(fa fakeArgs) (interface{}, error) {
+	var items []map[string]string
+	for _, arg := range fa.pos {
+		vgname, lvname := splitLVPath(arg)
+		vg, ok := f.vgs[vgname]
+		if !ok {
+			return nil, fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", vgname, vgname)
+		}
+		if lvname == "" {
+			for _, lv := range vg.lvs {
+				items = append(items, f.lvItem(vg, lv))
+			}
+			continue
+		}
+		lv := vg.findLV(lvname)
+		if lv == nil {
+			return nil, fmt.Errorf("  Failed to find logical volume \"%s/%s\"", vgname, lvname)
+		}
+		items = append(items, f.lvItem(vg, lv))
+	}
+	if len(fa.pos) == 0 {
+		var names []string
+		for name := range f.vgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			vg := f.vgs[name]
+			for _, lv := range vg.lvs {
+				items = append(items, f.lvItem(vg, lv))
+			}
+		}
+	}
+	return fakeSelectedReport(fa, "lv", items)
+}
+
+func (f *FakeExecutor) lvItem(vg *fakeVG, lv *fakeLV) map[string]string {
+	attr := []byte("-wi-a-----")
+	if lv.layout != "linear" {
+		attr[0] = 'r'
+	}
+	if !lv.active {
+		attr[4] = '-'
+	}
+	var devs []string
+	for dev := range lv.alloc {
+		devs = append(devs, dev+"(0)")
+	}
+	sort.Strings(devs)
+	return map[string]string{
+		"lv_name": lv.name,
+		"vg_name": vg.name,
+		"lv_path": "/dev/" + vg.name + "/" + lv.name,
+		"lv_size": strconv.FormatUint(lv.extents*vg.extentSize, 10),
+		"lv_tags": strings.Join(lv.tags, ","),
+		"lv_attr": string(attr),
+		"segtype": lv.layout,
+		"devices": strings.Join(devs, ","),
+	}
+}
+
+func (f *FakeExecutor) pvcreate(fa fakeArgs) error {
+	if len(fa.pos) == 0 {
+		return errors.New("  Please enter a physical volume path.")
+	}
+	for _, dev := range fa.pos {
+		size, ok := f.devices[dev]
+		if !ok {
+			return fmt.Errorf("  Device %s not found.", dev)
+		}
+		if pv, ok := f.pvs[dev]; ok && pv.vg != "" {
+			return fmt.Errorf("  Can't initialize physical volume \"%s\" of volume group \"%s\" without -ff", dev, pv.vg)
+		}
+		f.pvs[dev] = &fakePV{name: dev, size: size}
+	}
+	return nil
+}
+
+func (f *FakeExecutor) pvremove(fa fakeArgs) error {
+	for _, dev := range fa.pos {
+		pv, ok := f.pvs[dev]
+		if !ok {
+			return fmt.Errorf("  No PV found on device %s.", dev)
+		}
+		if pv.vg != "" {
+			return fmt.Errorf("  PV %s is used by VG %s so please use vgreduce first.", dev, pv.vg)
+		}
+		delete(f.pvs, dev)
+	}
+	return nil
+}
+
+func (f *FakeExecutor) pvck(fa fakeArgs) error {
+	for _, dev := range fa.pos {
+		if _, ok := f.pvs[dev]; !ok {
+			return fmt.Errorf("  No PV label found on %s.", dev)
+		}
+	}
+	return nil
+}
+
+func (f *FakeExecutor) vgcreate(fa fakeArgs) error {
+	if len(fa.pos) < 2 {
+		return errors.New("  Please provide volume group name and physical volumes")
+	}
+	name, devs := fa.pos[0], fa.pos[1:]
+	if _, ok := f.vgs[name]; ok {
+		return fmt.Errorf("  A volume group called %s already exists.", name)
+	}
+	for _, dev := range devs {
+		pv, ok := f.pvs[dev]
+		if !ok {
+			return fmt.Errorf("  Device %s not found.", dev)
+		}
+		if pv.vg != "" {
+			return fmt.Errorf("  Physical volume '%s' is already in volume group '%s'", dev, pv.vg)
+		}
+	}
+	f.nextUUID++
+	vg := &fakeVG{
+		name:       name,
+		uuid:       fmt.Sprintf("fake-vg-uuid-%d", f.nextUUID),
+		extentSize: DefaultFakeExtentSize,
+		tags:       fa.flags["--add-tag"],
+	}
+	for _, dev := range devs {
+		f.pvs[dev].vg = name
+		vg.pvs = append(vg.pvs, dev)
+	}
+	f.vgs[name] = vg
+	return nil
+}
+
+func (f *FakeExecutor) vgremove(fa fakeArgs) error {
+	for _, name := range fa.pos {
+		vg, ok := f.vgs[name]
+		if !ok {
+			return fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", name, name)
+		}
+		for _, dev := range vg.pvs {
+			if pv, ok := f.pvs[dev]; ok {
+				pv.vg = ""
+				pv.used = 0
+			}
+		}
+		delete(f.vgs, name)
+	}
+	return nil
+}
+
+func (f *FakeExecutor) vgck(fa fakeArgs) error {
+	for _, name := range fa.pos {
+		if _, ok := f.vgs[name]; !ok {
+			return fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", name, name)
+		}
+	}
+	return nil
+}
+
+func (f *FakeExecutor) lvcreate(fa fakeArgs) error {
+	if len(fa.pos) != 1 {
+		return errors.New("  Please specify a volume group name.")
+	}
+	vg, ok := f.vgs[fa.pos[0]]
+	if !ok {
+		return fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", fa.pos[0], fa.pos[0])
+	}
+	name := fa.get("--name")
+	if vg.findLV(name) != nil {
+		return fmt.Errorf("  Logical Volume \"%s\" already exists in volume group \"%s\"", name, vg.name)
+	}
+	size, err := strconv.ParseUint(strings.TrimSuffix(fa.get("--size"), "b"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("  Invalid argument for --size: %s", fa.get("--size"))
+	}
+	extents := (size + vg.extentSize - 1) / vg.extentSize
+	if extents == 0 {
+		return errors.New("  Unable to create new logical volume with no extents.")
+	}
+	layout := fa.get("--type")
+	if layout == "" {
+		layout = "linear"
+	}
+	var (
+		// images is the number of data subvolumes.
+		images uint64 = 1
+		// perImage is the number of extents needed on each device
+		// holding a data subvolume.
+		perImage = extents
+	)
+	switch layout {
+	case "linear":
+	case "raid1", "raid10":
+		mirrors := uint64(1)
+		if m := fa.get("--mirrors"); m != "" {
+			if mirrors, err = strconv.ParseUint(m, 10, 64); err != nil {
+				return fmt.Errorf("  Invalid argument for --mirrors: %s", m)
+			}
+		}
+		stripes := uint64(1)
+		if layout == "raid10" {
+			stripes = 2
+		}
+		if s := fa.get("--stripes"); s != "" {
+			if stripes, err = strconv.ParseUint(s, 10, 64); err != nil {
+				return fmt.Errorf("  Invalid argument for --stripes: %s", s)
+			}
+		}
+		images = (mirrors + 1) * stripes
+		// Every data subvolume is accompanied by a metadata subvolume
+		// that is one extent in size.
+		perImage = (extents+stripes-1)/stripes + 1
+	default:
+		return fmt.Errorf("  Unknown segment type %s", layout)
+	}
+	_, free := f.vgExtents(vg)
+	if perImage*images > free {
+		return fmt.Errorf("  Volume group \"%s\" has insufficient free space (%d extents): %d required.", vg.name, free, perImage*images)
+	}
+	alloc := make(map[string]uint64)
+	if images == 1 {
+		// Linear volumes may be spread across any number of devices.
+		need := perImage
+		for _, dev := range vg.pvs {
+			pv := f.pvs[dev]
+			avail := f.pvExtents(vg, pv) - pv.used
+			if avail == 0 {
+				continue
+			}
+			if avail > need {
+				avail = need
+			}
+			alloc[dev] = avail
+			need -= avail
+			if need == 0 {
+				break
+			}
+		}
+	} else {
+		// Each RAID image must be placed on a separate device.
+		for _, dev := range vg.pvs {
+			if uint64(len(alloc)) == images {
+				break
+			}
+			pv := f.pvs[dev]
+			if f.pvExtents(vg, pv)-pv.used >= perImage {
+				alloc[dev] = perImage
+			}
+		}
+		if uint64(len(alloc)) < images {
+			return fmt.Errorf("  Insufficient suitable allocatable extents for logical volume %s: %d more required", name, (images-uint64(len(alloc)))*perImage)
+		}
+	}
+	for dev, n := range alloc {
+		f.pvs[dev].used += n
+	}
+	vg.lvs = append(vg.lvs, &fakeLV{
+		name:    name,
+		extents: extents,
+		tags:    fa.flags["--add-tag"],
+		active:  true,
+		layout:  layout,
+		alloc:   alloc,
+	})
+	return nil
+}
+
+func (f *FakeExecutor) lvremove(fa fakeArgs) error {
+	for _, arg := range fa.pos {
+		vg, lv, err := f.lookupLV(arg)
+		if err != nil {
+			return err
+		}
+		for dev, n := range lv.alloc {
+			if pv, ok := f.pvs[dev]; ok {
+				pv.used -= n
+			}
+		}
+		for i, other := range vg.lvs {
+			if other == lv {
+				vg.lvs = append(vg.lvs[:i], vg.lvs[i+1:]...)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FakeExecutor) lvreduce(fa fakeArgs) error {
+	if len(fa.pos) != 1 {
+		return errors.New("  Please provide a logical volume path.")
+	}
+	vg, lv, err := f.lookupLV(fa.pos[0])
+	if err != nil {
+		return err
+	}
+	if lv.layout != "linear" {
+		return fmt.Errorf("  Cannot reduce %s logical volume %s in the fake.", lv.layout, lv.name)
+	}
+	size, err := strconv.ParseUint(strings.TrimSuffix(fa.get("--size"), "b"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("  Invalid argument for --size: %s", fa.get("--size"))
+	}
+	extents := (size + vg.extentSize - 1) / vg.extentSize
+	if extents == 0 || extents >= lv.extents {
+		return fmt.Errorf("  New size (%d extents) must be smaller than the current size (%d extents).", extents, lv.extents)
+	}
+	// Free extents from the last devices first, as lvm2 does.
+	release := lv.extents - extents
+	for i := len(vg.pvs) - 1; i >= 0 && release > 0; i-- {
+		dev := vg.pvs[i]
+		n := lv.alloc[dev]
+		if n > release {
+			n = release
+		}
+		lv.alloc[dev] -= n
+		f.pvs[dev].used -= n
+		release -= n
+	}
+	lv.extents = extents
+	return nil
+}
+
+func (f *FakeExecutor) lvchange(fa fakeArgs) error {
+	for _, arg := range fa.pos {
+		_, lv, err := f.lookupLV(arg)
+		if err != nil {
+			return err
+		}
+		switch {
+		case fa.has("-ay"), fa.has("-aey"):
+			lv.active = true
+		case fa.has("-an"):
+			lv.active = false
+		}
+		for _, tag := range fa.flags["--addtag"] {
+			lv.tags = append(removeString(lv.tags, tag), tag)
+		}
+		for _, tag := range fa.flags["--deltag"] {
+			lv.tags = removeString(lv.tags, tag)
+		}
+	}
+	return nil
+}
+
+func (f *FakeExecutor) lookupLV(path string) (*fakeVG, *fakeLV, error) {
+	vgname, lvname := splitLVPath(path)
+	vg, ok := f.vgs[vgname]
+	if !ok {
+		return nil, nil, fmt.Errorf("  Volume group \"%s\" not found\n  Cannot process volume group %s", vgname, vgname)
+	}
+	lv := vg.findLV(lvname)
+	if lv == nil {
+		return nil, nil, fmt.Errorf("  Failed to find logical volume \"%s/%s\"", vgname, lvname)
+	}
+	return vg, lv, nil
+}
+
+// pvExtents returns the number of extents a physical volume contributes
+// to the volume group. As with lvm2, the first extent is reserved for
+// metadata.
+func (f *FakeExecutor) pvExtents(vg *fakeVG, pv *fakePV) uint64 {
+	n := pv.size / vg.extentSize
+	if n == 0 {
+		return 0
+	}
+	return n - 1
+}
+
+func (f *FakeExecutor) vgExtents(vg *fakeVG) (total, free uint64) {
+	for _, dev := range vg.pvs {
+		pv := f.pvs[dev]
+		n := f.pvExtents(vg, pv)
+		total += n
+		free += n - pv.used
+	}
+	return total, free
+}
+
+func (vg *fakeVG) findLV(name string) *fakeLV {
+	for _, lv := range vg.lvs {
+		if lv.name == name {
+			return lv
+		}
+	}
+	return nil
+}
+
+func splitLVPath(path string) (vgname, lvname string) {
+	path = strings.TrimPrefix(path, "/dev/")
+	if i := strings.Index(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return path, ""
+}
+
+func removeString(list []string, s string) []string {
+	var result []string
+	for _, x := range list {
+		if x != s {
+			result = append(result, x)
+		}
+	}
+	return result
+}
+
+// fakeSelectedReport returns a report of the items that satisfy the
+// selection criteria given using --select, if any.
+func fakeSelectedReport(fa fakeArgs, kind string, items []map[string]string) (interface{}, error) {
+	if !fa.has("--select") {
+		return fakeReport(kind, items), nil
+	}
+	match, err := parseFakeSelection(fa.get("--select"))
+	if err != nil {
+		return nil, err
+	}
+	var selected []map[string]string
+	for _, item := range items {
+		if match(item) {
+			selected = append(selected, item)
+		}
+	}
+	return fakeReport(kind, selected), nil
+}
+
+// parseFakeSelection parses the subset of the lvm2 selection criteria
+// syntax produced by Selection: terms of the form field=value,
+// field=~regexp or field={item} joined by &&.
+func parseFakeSelection(expr string) (func(map[string]string) bool, error) {
+	var matchers []func(map[string]string) bool
+	for expr != "" {
+		i := strings.IndexByte(expr, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("  Selection syntax error at '%s'.", expr)
+		}
+		field, rest := expr[:i], expr[i+1:]
+		op := "="
+		if strings.HasPrefix(rest, "~") {
+			op, rest = "=~", rest[1:]
+		}
+		list := strings.HasPrefix(rest, "{")
+		if list {
+			rest = rest[1:]
+		}
+		if rest == "" || (rest[0] != '"' && rest[0] != '\'') {
+			return nil, fmt.Errorf("  Selection syntax error at '%s'.", rest)
+		}
+		end := strings.IndexByte(rest[1:], rest[0])
+		if end < 0 {
+			return nil, fmt.Errorf("  Selection syntax error at '%s'.", rest)
+		}
+		value, rest := rest[1:end+1], rest[end+2:]
+		if list {
+			if !strings.HasPrefix(rest, "}") {
+				return nil, fmt.Errorf("  Selection syntax error at '%s'.", rest)
+			}
+			rest = rest[1:]
+		}
+		switch {
+		case list:
+			matchers = append(matchers, func(item map[string]string) bool {
+				for _, x := range strings.Split(item[field], ",") {
+					if x == value {
+						return true
+					}
+				}
+				return false
+			})
+		case op == "=~":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("  Failed to parse regex %s.", value)
+			}
+			matchers = append(matchers, func(item map[string]string) bool { return re.MatchString(item[field]) })
+		default:
+			matchers = append(matchers, func(item map[string]string) bool { return item[field] == value })
+		}
+		if rest != "" && !strings.HasPrefix(rest, "&&") {
+			return nil, fmt.Errorf("  Selection syntax error at '%s'.", rest)
+		}
+		expr = strings.TrimPrefix(rest, "&&")
+	}
+	return func(item map[string]string) bool {
+		for _, m := range matchers {
+			if !m(item) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func fakeReport(kind string, items []map[string]string) interface{} {
+	if items == nil {
+		items = []map[string]string{}
+	}
+	return map[string]interface{}{
+		"report": []map[string]interface{}{
+			{kind: items},
+		},
+	}
+}
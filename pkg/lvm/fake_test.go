@@ -0,0 +1,255 @@
+package lvm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The size of the devices registered with the FakeExecutor in our tests.
+const fakeDeviceSize = 100 << 20 // 100MiB
+
+// fakeVolumeGroup configures a FakeExecutor with the given number of 100MiB
+// devices and creates a volume group spanning all of them.
+func fakeVolumeGroup(t *testing.T, ndevs int, tags []string) (*FakeExecutor, *VolumeGroup) {
+	t.Helper()
+	fake := NewFakeExecutor()
+	SetExecutor(fake)
+	var pvs []*PhysicalVolume
+	for i := 0; i < ndevs; i++ {
+		dev := "/dev/fake" + string(rune('a'+i))
+		fake.AddDevice(dev, fakeDeviceSize)
+		pv, err := CreatePhysicalVolume(context.Background(), dev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pvs = append(pvs, pv)
+	}
+	vg, err := CreateVolumeGroup(context.Background(), "test-vg", pvs, tags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fake, vg
+}
+
+func TestFakeVolumeGroupBytes(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	total, err := vg.BytesTotal(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Metadata claims a single extent.
+	if exp := uint64(fakeDeviceSize - DefaultFakeExtentSize); total != exp {
+		t.Fatalf("Expected size %d but got %d", exp, total)
+	}
+	free, err := vg.BytesFree(context.Background(), VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != total {
+		t.Fatalf("Expected %d bytes free but got %d", total, free)
+	}
+	lv, err := vg.CreateLogicalVolume(context.Background(), "test-lv", 10<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The requested size is rounded up to a multiple of the extent size.
+	free, err = vg.BytesFree(context.Background(), VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := total - 12<<20; free != exp {
+		t.Fatalf("Expected %d bytes free but got %d", exp, free)
+	}
+	if err := lv.Remove(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	free, err = vg.BytesFree(context.Background(), VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != total {
+		t.Fatalf("Expected %d bytes free but got %d", total, free)
+	}
+}
+
+func TestFakeCreateLogicalVolume_Tagged(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, []string{"vgtag"})
+	tags, err := vg.Tags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"vgtag"}, tags) {
+		t.Fatalf("Expected tags %v but got %v", []string{"vgtag"}, tags)
+	}
+	lv, err := vg.CreateLogicalVolume(context.Background(), "test-lv", 8<<20, []string{"dcos-tag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags, err = lv.Tags(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"dcos-tag"}, tags) {
+		t.Fatalf("Expected tags %v but got %v", []string{"dcos-tag"}, tags)
+	}
+	found, err := vg.FindLogicalVolume(context.Background(), LVMatchTag("dcos-tag"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Name() != "test-lv" {
+		t.Fatalf("Expected to find test-lv but found %v", found.Name())
+	}
+	path, err := lv.Path(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/dev/test-vg/test-lv" {
+		t.Fatalf("Unexpected path %v", path)
+	}
+}
+
+func TestFakeCreateLogicalVolumeTooLarge(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	_, err := vg.CreateLogicalVolume(context.Background(), "test-lv", fakeDeviceSize, nil)
+	if err != ErrNoSpace {
+		t.Fatalf("Expected ErrNoSpace but got %v", err)
+	}
+}
+
+func TestFakeCreateLogicalVolume_VolumeLayout_RAID1(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 2, nil)
+	layout := VolumeLayout{Type: VolumeTypeRAID1}
+	free, err := vg.BytesFree(context.Background(), layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two devices of 24 extents each: one extent per device is used for
+	// RAID metadata.
+	if exp := uint64(23 * DefaultFakeExtentSize); free != exp {
+		t.Fatalf("Expected %d bytes free but got %d", exp, free)
+	}
+	if _, err := vg.CreateLogicalVolume(context.Background(), "test-lv", free, nil, VolumeLayoutOpt(layout)); err != nil {
+		t.Fatal(err)
+	}
+	free, err = vg.BytesFree(context.Background(), VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free != 0 {
+		t.Fatalf("Expected no bytes free but got %d", free)
+	}
+}
+
+func TestFakeCreateLogicalVolume_VolumeLayout_RAID1_TooFewDisks(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	layout := VolumeLayout{Type: VolumeTypeRAID1}
+	_, err := vg.CreateLogicalVolume(context.Background(), "test-lv", 8<<20, nil, VolumeLayoutOpt(layout))
+	if err != ErrTooFewDisks {
+		t.Fatalf("Expected ErrTooFewDisks but got %v", err)
+	}
+}
+
+func TestFakeLookupNonExistent(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	if _, err := vg.LookupLogicalVolume(context.Background(), "missing"); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got %v", err)
+	}
+	if _, err := LookupVolumeGroup(context.Background(), "missing"); err != ErrVolumeGroupNotFound {
+		t.Fatalf("Expected ErrVolumeGroupNotFound but got %v", err)
+	}
+	if _, err := LookupPhysicalVolume(context.Background(), "/dev/missing"); err != ErrPhysicalVolumeNotFound {
+		t.Fatalf("Expected ErrPhysicalVolumeNotFound but got %v", err)
+	}
+}
+
+func TestFakeRemoveVolumeGroup(t *testing.T) {
+	defer SetExecutor(nil)
+	fake, vg := fakeVolumeGroup(t, 2, nil)
+	if _, err := vg.CreateLogicalVolume(context.Background(), "test-lv", 8<<20, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := vg.Remove(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ListVolumeGroupNames(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("Expected no volume groups but got %v", names)
+	}
+	pvs, err := ListPhysicalVolumes(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvs) != 2 {
+		t.Fatalf("Expected 2 physical volumes but got %d", len(pvs))
+	}
+	if len(fake.Commands()) == 0 {
+		t.Fatal("Expected commands to be recorded")
+	}
+}
+
+func TestFakeCommandTimeout(t *testing.T) {
+	defer SetExecutor(nil)
+	defer SetCommandTimeout(0)
+	fake, vg := fakeVolumeGroup(t, 1, nil)
+	fake.SetLatency(time.Second)
+	SetCommandTimeout(10 * time.Millisecond)
+	_, err := vg.BytesTotal(context.Background())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+}
+
+func TestFakeCancelled(t *testing.T) {
+	defer SetExecutor(nil)
+	fake, vg := fakeVolumeGroup(t, 1, nil)
+	fake.SetLatency(time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := vg.CreateLogicalVolume(ctx, "test-lv", 8<<20, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled but got %v", err)
+	}
+}
+
+func TestFakeReduceLogicalVolume(t *testing.T) {
+	defer SetExecutor(nil)
+	_, vg := fakeVolumeGroup(t, 1, nil)
+	ctx := context.Background()
+	lv, err := vg.CreateLogicalVolume(ctx, "test-lv", 40<<20, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := vg.BytesFree(ctx, VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lv.Reduce(ctx, 10<<20, 13<<20); err != ErrReduceBelowFilesystem {
+		t.Fatalf("Expected ErrReduceBelowFilesystem but got %v", err)
+	}
+	// The size is rounded up to a multiple of the extent size.
+	if err := lv.Reduce(ctx, 10<<20, 10<<20); err != nil {
+		t.Fatal(err)
+	}
+	if lv.SizeInBytes() != 12<<20 {
+		t.Fatalf("Expected a size of 12MiB but got %d", lv.SizeInBytes())
+	}
+	after, err := vg.BytesFree(ctx, VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after-before != 28<<20 {
+		t.Fatalf("Expected 28MiB to be freed but got %d", after-before)
+	}
+}
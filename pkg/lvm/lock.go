@@ -4,10 +4,16 @@ import (
 	"fmt"
 
 	"github.com/gofrs/flock"
+	"golang.org/x/sync/semaphore"
 )
 
 var lvmlock *flock.Flock
 
+// processLock serializes LVM command-line utility invocations within this
+// process. A flock.Flock that is already locked by this process does not
+// exclude other goroutines.
+var processLock = semaphore.NewWeighted(1)
+
 // SetLockFilePath sets the path to the LOCK file to use for preventing
 // concurrent invocations of LVM command-line utilities.
 //
@@ -28,3 +34,12 @@ func SetLockFilePath(filepath string) {
 	}
 	log.Printf("configured lock file")
 }
+
+// LockFilePath returns the path of the lock file set with SetLockFilePath,
+// or the empty string if none is used.
+func LockFilePath() string {
+	if lvmlock == nil {
+		return ""
+	}
+	return lvmlock.Path()
+}
@@ -1,6 +1,8 @@
 package lvm
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"os"
 
@@ -21,12 +23,15 @@ type LoopDevice struct {
 // responsible for calling `Close()` on the `*LoopDevice` when done
 // with it.
 //
-// CreateLoopDevice may panic if an error occurs during error recovery.
+// If CreateLoopDevice fails and cannot undo what it did so far, the
+// returned error describes both failures.
 func CreateLoopDevice(size uint64) (device *LoopDevice, err error) {
-	var cleanup cleanup.Steps
+	var rollback cleanup.Rollback
 	defer func() {
 		if err != nil {
-			cleanup.Unwind()
+			if rerr := rollback.Run(context.Background()); rerr != nil {
+				err = fmt.Errorf("%v: %v", err, rerr)
+			}
 		}
 	}()
 
@@ -36,7 +41,7 @@ func CreateLoopDevice(size uint64) (device *LoopDevice, err error) {
 		return nil, err
 	}
 	// If anything goes wrong, remove the tempfile.
-	cleanup.Add(func() error { return os.Remove(file.Name()) })
+	rollback.Add("create "+file.Name(), func(context.Context) error { return os.Remove(file.Name()) })
 	// Close the file as we're not going to manipulate its
 	// contents manually.
 	if err = file.Close(); err != nil {
@@ -56,7 +61,7 @@ func CreateLoopDevice(size uint64) (device *LoopDevice, err error) {
 	if err != nil {
 		return nil, err
 	}
-	cleanup.Add(func() error { return lodev.Detach() })
+	rollback.Add("attach "+lodev.Path(), func(context.Context) error { return lodev.Detach() })
 	// https://www.howtogeek.com/howto/40702/how-to-manage-and-use-lvm-logical-volume-management-in-ubuntu/
 	return &LoopDevice{lodev, file.Name()}, nil
 }
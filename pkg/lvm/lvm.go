@@ -1,11 +1,10 @@
 package lvm
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"os/exec"
 	"regexp"
 	"strings"
 )
@@ -13,16 +12,6 @@ import (
 // Control verbose output of all LVM CLI commands
 var Verbose bool
 
-// isInsufficientSpace returns true if the error is due to insufficient space
-func isInsufficientSpace(err error) bool {
-	return strings.Contains(strings.ToLower(err.Error()), "insufficient free space")
-}
-
-// isInsufficientDevices returns true if the error is due to insufficient underlying devices
-func isInsufficientDevices(err error) bool {
-	return strings.Contains(err.Error(), "Insufficient suitable allocatable extents for logical volume")
-}
-
 // MaxSize states that all available space should be used by the
 // create operation.
 const MaxSize uint64 = 0
@@ -35,6 +24,7 @@ const ErrNoSpace = simpleError("lvm: not enough free space")
 const ErrTooFewDisks = simpleError("lvm: not enough underlying devices")
 const ErrPhysicalVolumeNotFound = simpleError("lvm: physical volume not found")
 const ErrVolumeGroupNotFound = simpleError("lvm: volume group not found")
+const ErrReduceBelowFilesystem = simpleError("lvm: cannot reduce logical volume below the size of its filesystem")
 
 var vgnameRegexp = regexp.MustCompile("^[A-Za-z0-9_+.][A-Za-z0-9_+.-]*$")
 
@@ -54,16 +44,16 @@ type PhysicalVolume struct {
 }
 
 // Remove removes the physical volume.
-func (pv *PhysicalVolume) Remove() error {
-	if err := run("pvremove", nil, pv.dev); err != nil {
+func (pv *PhysicalVolume) Remove(ctx context.Context) error {
+	if err := run(ctx, "pvremove", nil, pv.dev); err != nil {
 		return err
 	}
 	return nil
 }
 
 // Check runs the pvck command on the physical volume.
-func (pv *PhysicalVolume) Check() error {
-	if err := run("pvck", nil, pv.dev); err != nil {
+func (pv *PhysicalVolume) Check(ctx context.Context) error {
+	if err := run(ctx, "pvck", nil, pv.dev); err != nil {
 		return err
 	}
 	return nil
@@ -78,17 +68,17 @@ func (vg *VolumeGroup) Name() string {
 }
 
 // Check runs the vgck command on the volume group.
-func (vg *VolumeGroup) Check() error {
-	if err := run("vgck", nil, vg.name); err != nil {
+func (vg *VolumeGroup) Check(ctx context.Context) error {
+	if err := run(ctx, "vgck", nil, vg.name); err != nil {
 		return err
 	}
 	return nil
 }
 
 // BytesTotal returns the current size in bytes of the volume group.
-func (vg *VolumeGroup) BytesTotal() (uint64, error) {
+func (vg *VolumeGroup) BytesTotal(ctx context.Context) (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_size", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -103,8 +93,8 @@ func (vg *VolumeGroup) BytesTotal() (uint64, error) {
 }
 
 // BytesFree returns the unallocated space in bytes of the volume group.
-func (vg *VolumeGroup) BytesFree(raid VolumeLayout) (uint64, error) {
-	pvnames, err := vg.ListPhysicalVolumeNames()
+func (vg *VolumeGroup) BytesFree(ctx context.Context, raid VolumeLayout) (uint64, error) {
+	pvnames, err := vg.ListPhysicalVolumeNames(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -115,7 +105,7 @@ func (vg *VolumeGroup) BytesFree(raid VolumeLayout) (uint64, error) {
 		return 0, nil
 	}
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_free,vg_free_count,vg_extent_size", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_free,vg_free_count,vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -164,9 +154,9 @@ func (r VolumeLayout) extentsFree(count uint64) uint64 {
 }
 
 // ExtentSize returns the size in bytes of a single extent.
-func (vg *VolumeGroup) ExtentSize() (uint64, error) {
+func (vg *VolumeGroup) ExtentSize(ctx context.Context) (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_extent_size", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -181,9 +171,9 @@ func (vg *VolumeGroup) ExtentSize() (uint64, error) {
 }
 
 // ExtentCount returns the number of extents.
-func (vg *VolumeGroup) ExtentCount() (uint64, error) {
+func (vg *VolumeGroup) ExtentCount(ctx context.Context) (uint64, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_extent_count", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_extent_count", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -198,8 +188,8 @@ func (vg *VolumeGroup) ExtentCount() (uint64, error) {
 }
 
 // ExtentFreeCount returns the number of free extents.
-func (vg *VolumeGroup) ExtentFreeCount(raid VolumeLayout) (uint64, error) {
-	pvnames, err := vg.ListPhysicalVolumeNames()
+func (vg *VolumeGroup) ExtentFreeCount(ctx context.Context, raid VolumeLayout) (uint64, error) {
+	pvnames, err := vg.ListPhysicalVolumeNames(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -210,7 +200,7 @@ func (vg *VolumeGroup) ExtentFreeCount(raid VolumeLayout) (uint64, error) {
 		return 0, nil
 	}
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_free_count,vg_extent_size", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_free_count,vg_extent_size", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return 0, ErrVolumeGroupNotFound
 		}
@@ -341,14 +331,52 @@ func VolumeLayoutOpt(r VolumeLayout) CreateLogicalVolumeOpt {
 	}
 }
 
+// WipeSignaturesOpt determines whether lvcreate wipes filesystem and other
+// signatures found at the start of the new logical volume, e.g., left
+// behind by a removed logical volume that used the same extents.
+func WipeSignaturesOpt(wipe bool) CreateLogicalVolumeOpt {
+	return func(o *LVOpts) {
+		o.wipeSignatures = yesNo(wipe)
+	}
+}
+
+// ZeroOpt determines whether lvcreate zeroes the first 4KiB of the new
+// logical volume.
+func ZeroOpt(zero bool) CreateLogicalVolumeOpt {
+	return func(o *LVOpts) {
+		o.zero = yesNo(zero)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "y"
+	}
+	return "n"
+}
+
 type CreateLogicalVolumeOpt func(opts *LVOpts)
 
 type LVOpts struct {
 	volumeLayout VolumeLayout
+	// wipeSignatures and zero are "y", "n" or empty for lvcreate's
+	// default.
+	wipeSignatures string
+	zero           string
 }
 
 func (o LVOpts) Flags() (opts []string) {
 	opts = append(opts, o.volumeLayout.Flags()...)
+	if o.wipeSignatures != "" {
+		opts = append(opts, "--wipesignatures="+o.wipeSignatures)
+		if o.wipeSignatures == "y" {
+			// Wipe without prompting for confirmation.
+			opts = append(opts, "--yes")
+		}
+	}
+	if o.zero != "" {
+		opts = append(opts, "--zero="+o.zero)
+	}
 	return opts
 }
 
@@ -361,7 +389,7 @@ func (o LVOpts) Flags() (opts []string) {
 // If sizeInBytes is zero the entire available space is allocated.
 //
 // Additional optional config items can be specified using CreateLogicalVolumeOpt
-func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags []string, optFns ...CreateLogicalVolumeOpt) (*LogicalVolume, error) {
+func (vg *VolumeGroup) CreateLogicalVolume(ctx context.Context, name string, sizeInBytes uint64, tags []string, optFns ...CreateLogicalVolumeOpt) (*LogicalVolume, error) {
 	if err := ValidateLogicalVolumeName(name); err != nil {
 		return nil, err
 	}
@@ -385,11 +413,11 @@ func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags
 		}
 	}
 	args = append(args, opts.Flags()...)
-	if err := run("lvcreate", nil, args...); err != nil {
-		if isInsufficientSpace(err) {
+	if err := run(ctx, "lvcreate", nil, args...); err != nil {
+		if isInsufficientSpace(stderrOf(err)) {
 			return nil, ErrNoSpace
 		}
-		if isInsufficientDevices(err) {
+		if isInsufficientDevices(stderrOf(err)) {
 			return nil, ErrTooFewDisks
 		}
 		return nil, err
@@ -397,7 +425,14 @@ func (vg *VolumeGroup) CreateLogicalVolume(name string, sizeInBytes uint64, tags
 	// Deactivate so another node in the cluster can activate
 	// If new LV is not activated the --nosyn will be ignored
 	newlv := &LogicalVolume{name, sizeInBytes, vg}
-	newlv.Deactivate()
+	if err := newlv.Deactivate(ctx); err != nil {
+		// The logical volume exists but is unusable by other
+		// nodes. Remove it rather than leave it behind.
+		if rerr := newlv.Remove(ctx); rerr != nil {
+			log.Printf("Cannot remove logical volume %s after failing to deactivate it: %v", name, rerr)
+		}
+		return nil, err
+	}
 	return newlv, nil
 }
 
@@ -431,16 +466,6 @@ func (lv lvsItem) tagList() (tags []string) {
 	return
 }
 
-func (lv lvsItem) tagSet() (tags map[string]struct{}) {
-	tags = make(map[string]struct{})
-	for _, tag := range strings.Split(lv.LvTags, ",") {
-		tag = strings.TrimSpace(tag)
-		if tag != "" {
-			tags[tag] = struct{}{}
-		}
-	}
-	return
-}
 
 type lvsOutput struct {
 	Report []struct {
@@ -448,42 +473,26 @@ type lvsOutput struct {
 	} `json:"report"`
 }
 
-func IsLogicalVolumeNotFound(err error) bool {
-	const prefix = "Failed to find logical volume"
-	lines := strings.Split(err.Error(), "\n")
-	if len(lines) == 0 {
-		return false
-	}
-	for _, line := range lines {
-		if strings.HasPrefix(line, prefix) {
-			return true
-		}
-	}
-	return false
-}
-
 // LookupLogicalVolume looks up the logical volume in the volume group
 // with the given name.
-func (vg *VolumeGroup) LookupLogicalVolume(name string) (*LogicalVolume, error) {
-	return vg.FindLogicalVolume(func(lv lvsItem) bool { return lv.Name == name })
+func (vg *VolumeGroup) LookupLogicalVolume(ctx context.Context, name string) (*LogicalVolume, error) {
+	return vg.FindLogicalVolume(ctx, LVName(name))
 }
 
-func LVMatchTag(tag string) func(lvsItem) bool {
-	return func(lv lvsItem) (matches bool) {
-		tags := lv.tagSet()
-		_, matches = tags[tag]
-		return
+// FindLogicalVolume returns the first logical volume in the volume group
+// that satisfies the selection.
+func (vg *VolumeGroup) FindLogicalVolume(ctx context.Context, sel Selection) (*LogicalVolume, error) {
+	selargs, err := sel.args()
+	if err != nil {
+		return nil, err
 	}
-}
-
-// FindLogicalVolume looks up the logical volume in the volume group
-// with the given name.
-func (vg *VolumeGroup) FindLogicalVolume(matchFirst func(lvsItem) bool) (*LogicalVolume, error) {
+	args := append([]string{"--options=lv_name,lv_size,vg_name,lv_tags"}, selargs...)
+	args = append(args, vg.Name())
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_name,lv_size,vg_name,lv_tags", vg.Name()); err != nil {
+	if err := run(ctx, "lvs", result, args...); err != nil {
 		if IsLogicalVolumeNotFound(err) {
-			RefreshMetaData()
-			if err := run("lvs", result, "--options=lv_name,lv_size,vg_name,lv_tags", vg.Name()); err != nil {
+			RefreshMetaData(ctx)
+			if err := run(ctx, "lvs", result, args...); err != nil {
 				if IsLogicalVolumeNotFound(err) {
 					return nil, ErrLogicalVolumeNotFound
 				}
@@ -493,37 +502,29 @@ func (vg *VolumeGroup) FindLogicalVolume(matchFirst func(lvsItem) bool) (*Logica
 	}
 	for _, report := range result.Report {
 		for _, lv := range report.Lv {
-			if lv.VgName != vg.Name() {
-				continue
-			}
-			if matchFirst != nil && !matchFirst(lv) {
-				continue
-			}
 			return &LogicalVolume{lv.Name, lv.LvSize, vg}, nil
 		}
 	}
 	return nil, ErrLogicalVolumeNotFound
 }
 
-func RefreshMetaData(){
-	c := exec.Command("partprobe")
-	log.Printf("Executing: partprobe")
-	c.Run()
+func RefreshMetaData(ctx context.Context) {
+	executor.Execute(ctx, "partprobe")
 	//FIXME: Do we need to handle missing/failing partprobe?
-	if err := PVScan(""); err != nil {
+	if err := PVScan(ctx, ""); err != nil {
 		log.Printf("error during pvscan: %v", err)
 	}
-	if err := VGScan(""); err != nil {
+	if err := VGScan(ctx, ""); err != nil {
 		log.Printf("error during vgscan: %v", err)
 	}
 
 }
 
 // ListLogicalVolumes returns the names of the logical volumes in this volume group.
-func (vg *VolumeGroup) ListLogicalVolumeNames() ([]string, error) {
+func (vg *VolumeGroup) ListLogicalVolumeNames(ctx context.Context) ([]string, error) {
 	var names []string
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_name,vg_name", vg.name); err != nil {
+	if err := run(ctx, "lvs", result, "--options=lv_name,vg_name", vg.name); err != nil {
 		return nil, err
 	}
 	for _, report := range result.Report {
@@ -536,75 +537,30 @@ func (vg *VolumeGroup) ListLogicalVolumeNames() ([]string, error) {
 	return names, nil
 }
 
-func IsPhysicalVolumeNotFound(err error) bool {
-	return isPhysicalVolumeNotFound(err) ||
-		isNoPhysicalVolumeLabel(err)
-}
-
-func isPhysicalVolumeNotFound(err error) bool {
-	const prefix = "Failed to find device"
-	lines := strings.Split(err.Error(), "\n")
-	if len(lines) == 0 {
-		return false
-	}
-	for _, line := range lines {
-		if strings.HasPrefix(line, prefix) {
-			return true
-		}
-	}
-	return false
-}
-
-func isNoPhysicalVolumeLabel(err error) bool {
-	const prefix = "No physical volume label read from"
-	lines := strings.Split(err.Error(), "\n")
-	if len(lines) == 0 {
-		return false
-	}
-	for _, line := range lines {
-		if strings.HasPrefix(line, prefix) {
-			return true
-		}
-	}
-	return false
-}
-
-func IsVolumeGroupNotFound(err error) bool {
-	const prefix = "Volume group"
-	const suffix = "not found"
-	lines := strings.Split(err.Error(), "\n")
-	if len(lines) == 0 {
-		return false
-	}
-	for _, line := range lines {
-		if strings.HasPrefix(line, prefix) && strings.HasSuffix(line, suffix) {
-			return true
-		}
-	}
-	return false
-}
-
 // ListPhysicalVolumeNames returns the names of the physical volumes in this volume group.
-func (vg *VolumeGroup) ListPhysicalVolumeNames() ([]string, error) {
+func (vg *VolumeGroup) ListPhysicalVolumeNames(ctx context.Context) ([]string, error) {
 	var names []string
 	result := new(pvsOutput)
-	if err := run("pvs", result, "--options=pv_name,vg_name"); err != nil {
+	selargs, err := VGName(vg.name).args()
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"--options=pv_name,vg_name"}, selargs...)
+	if err := run(ctx, "pvs", result, args...); err != nil {
 		return nil, err
 	}
 	for _, report := range result.Report {
 		for _, pv := range report.Pv {
-			if pv.VgName == vg.name {
-				names = append(names, pv.Name)
-			}
+			names = append(names, pv.Name)
 		}
 	}
 	return names, nil
 }
 
 // Tags returns the volume group tags.
-func (vg *VolumeGroup) Tags() ([]string, error) {
+func (vg *VolumeGroup) Tags(ctx context.Context) ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_tags", vg.name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_tags", vg.name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return nil, ErrVolumeGroupNotFound
 		}
@@ -627,8 +583,8 @@ func (vg *VolumeGroup) Tags() ([]string, error) {
 }
 
 // Remove removes the volume group from disk.
-func (vg *VolumeGroup) Remove() error {
-	if err := run("vgremove", nil, "-f", vg.name); err != nil {
+func (vg *VolumeGroup) Remove(ctx context.Context) error {
+	if err := run(ctx, "vgremove", nil, "-f", vg.name); err != nil {
 		return err
 	}
 	return nil
@@ -649,9 +605,9 @@ func (lv *LogicalVolume) SizeInBytes() uint64 {
 }
 
 // Path returns the device path for the logical volume.
-func (lv *LogicalVolume) Path() (string, error) {
+func (lv *LogicalVolume) Path(ctx context.Context) (string, error) {
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_path", lv.vg.name+"/"+lv.name); err != nil {
+	if err := run(ctx, "lvs", result, "--options=lv_path", lv.vg.name+"/"+lv.name); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return "", ErrLogicalVolumeNotFound
 		}
@@ -666,9 +622,9 @@ func (lv *LogicalVolume) Path() (string, error) {
 }
 
 // Tags returns the volume group tags.
-func (lv *LogicalVolume) Tags() ([]string, error) {
+func (lv *LogicalVolume) Tags(ctx context.Context) ([]string, error) {
 	result := new(lvsOutput)
-	if err := run("lvs", result, "--options=lv_tags", lv.vg.name+"/"+lv.name); err != nil {
+	if err := run(ctx, "lvs", result, "--options=lv_tags", lv.vg.name+"/"+lv.name); err != nil {
 		if IsLogicalVolumeNotFound(err) {
 			return nil, ErrLogicalVolumeNotFound
 		}
@@ -682,23 +638,85 @@ func (lv *LogicalVolume) Tags() ([]string, error) {
 	return nil, ErrLogicalVolumeNotFound
 }
 
-func (lv *LogicalVolume) Remove() error {
-	if err := run("lvremove", nil, "-f", lv.vg.name+"/"+lv.name); err != nil {
+func (lv *LogicalVolume) Remove(ctx context.Context) error {
+	if err := run(ctx, "lvremove", nil, "-f", lv.vg.name+"/"+lv.name); err != nil {
 		return err
 	}
 	return nil
 }
 
 
-func (lv *LogicalVolume) Activate() error {
-	if err := run("lvchange", nil, "-ay", lv.vg.name+"/"+lv.name); err != nil {
+func (lv *LogicalVolume) Activate(ctx context.Context) error {
+	if err := run(ctx, "lvchange", nil, "-ay", lv.vg.name+"/"+lv.name); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (lv *LogicalVolume) Deactivate() error {
-	if err := run("lvchange", nil, "-an", lv.vg.name+"/"+lv.name); err != nil {
+// Reduce shrinks the logical volume to sizeInBytes, rounded up to a
+// multiple of the extent size. The filesystem on the logical volume must
+// already have been shrunk to filesystemSizeInBytes; Reduce returns
+// ErrReduceBelowFilesystem rather than cut any of it off.
+func (lv *LogicalVolume) Reduce(ctx context.Context, sizeInBytes, filesystemSizeInBytes uint64) error {
+	extentSize, err := lv.vg.ExtentSize(ctx)
+	if err != nil {
+		return err
+	}
+	size := (sizeInBytes + extentSize - 1) / extentSize * extentSize
+	if size == 0 || filesystemSizeInBytes > size {
+		return ErrReduceBelowFilesystem
+	}
+	if err := run(ctx, "lvreduce", nil, "--force", fmt.Sprintf("--size=%db", size), lv.vg.name+"/"+lv.name); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return ErrLogicalVolumeNotFound
+		}
+		return err
+	}
+	lv.sizeInBytes = size
+	return nil
+}
+
+// ActivateExclusive activates the logical volume on this host only. In a
+// shared volume group it fails if the logical volume is active on another
+// host.
+func (lv *LogicalVolume) ActivateExclusive(ctx context.Context) error {
+	if err := run(ctx, "lvchange", nil, "-aey", lv.vg.name+"/"+lv.name); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (lv *LogicalVolume) Deactivate(ctx context.Context) error {
+	if err := run(ctx, "lvchange", nil, "-an", lv.vg.name+"/"+lv.name); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ChangeTags adds and deletes logical volume tags in a single lvchange
+// invocation so that no other command observes only one of the changes.
+func (lv *LogicalVolume) ChangeTags(ctx context.Context, add, del []string) error {
+	var args []string
+	for _, tag := range add {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+		args = append(args, "--addtag="+tag)
+	}
+	for _, tag := range del {
+		if err := ValidateTag(tag); err != nil {
+			return err
+		}
+		args = append(args, "--deltag="+tag)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	args = append(args, lv.vg.name+"/"+lv.name)
+	if err := run(ctx, "lvchange", nil, args...); err != nil {
+		if IsLogicalVolumeNotFound(err) {
+			return ErrLogicalVolumeNotFound
+		}
 		return err
 	}
 	return nil
@@ -707,27 +725,28 @@ func (lv *LogicalVolume) Deactivate() error {
 // PVScan runs the `pvscan --cache <dev>` command. It scans for the
 // device at `dev` and adds it to the LVM metadata cache if `lvmetad`
 // is running. If `dev` is an empty string, it scans all devices.
-func PVScan(dev string) error {
+func PVScan(ctx context.Context, dev string) error {
 	args := []string{"--cache"}
 	if dev != "" {
 		args = append(args, dev)
 	}
-	return run("pvscan", nil, args...)
+	return run(ctx, "pvscan", nil, args...)
 }
 
 // VGScan runs the `vgscan --cache <name>` command. It scans for the
 // volume group and adds it to the LVM metadata cache if `lvmetad`
 // is running. If `name` is an empty string, it scans all volume groups.
-func VGScan(name string) error {
+func VGScan(ctx context.Context, name string) error {
 	args := []string{"--cache"}
 	if name != "" {
 		args = append(args, name)
 	}
-	return run("vgscan", nil, args...)
+	return run(ctx, "vgscan", nil, args...)
 }
 
 // CreateVolumeGroup creates a new volume group.
 func CreateVolumeGroup(
+	ctx context.Context,
 	name string,
 	pvs []*PhysicalVolume,
 	tags []string) (*VolumeGroup, error) {
@@ -747,17 +766,17 @@ func CreateVolumeGroup(
 	for _, pv := range pvs {
 		args = append(args, pv.dev)
 	}
-	if err := run("vgcreate", nil, args...); err != nil {
+	if err := run(ctx, "vgcreate", nil, args...); err != nil {
 		return nil, err
 	}
 	// Perform a best-effort scan to trigger a lvmetad cache refresh.
 	// We ignore errors as for better or worse, the volume group now exists.
 	// Without this lvmetad can fail to pickup newly created volume groups.
 	// See https://bugzilla.redhat.com/show_bug.cgi?id=837599
-	if err := PVScan(""); err != nil {
+	if err := PVScan(ctx, ""); err != nil {
 		log.Printf("error during pvscan: %v", err)
 	}
-	if err := VGScan(""); err != nil {
+	if err := VGScan(ctx, ""); err != nil {
 		log.Printf("error during vgscan: %v", err)
 	}
 	return &VolumeGroup{name}, nil
@@ -806,9 +825,9 @@ type vgsOutput struct {
 }
 
 // LookupVolumeGroup returns the volume group with the given name.
-func LookupVolumeGroup(name string) (*VolumeGroup, error) {
+func LookupVolumeGroup(ctx context.Context, name string) (*VolumeGroup, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_name", name); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_name", name); err != nil {
 		if IsVolumeGroupNotFound(err) {
 			return nil, ErrVolumeGroupNotFound
 		}
@@ -825,9 +844,9 @@ func LookupVolumeGroup(name string) (*VolumeGroup, error) {
 // ListVolumeGroupNames returns the names of the list of volume groups. This
 // does not normally scan for devices. To scan for devices, use the `Scan()`
 // function.
-func ListVolumeGroupNames() ([]string, error) {
+func ListVolumeGroupNames(ctx context.Context) ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result); err != nil {
+	if err := run(ctx, "vgs", result); err != nil {
 		return nil, err
 	}
 	var names []string
@@ -842,9 +861,9 @@ func ListVolumeGroupNames() ([]string, error) {
 // ListVolumeGroupUUIDs returns the UUIDs of the list of volume groups. This
 // does not normally scan for devices. To scan for devices, use the `Scan()`
 // function.
-func ListVolumeGroupUUIDs() ([]string, error) {
+func ListVolumeGroupUUIDs(ctx context.Context) ([]string, error) {
 	result := new(vgsOutput)
-	if err := run("vgs", result, "--options=vg_uuid"); err != nil {
+	if err := run(ctx, "vgs", result, "--options=vg_uuid"); err != nil {
 		return nil, err
 	}
 	var uuids []string
@@ -857,9 +876,9 @@ func ListVolumeGroupUUIDs() ([]string, error) {
 }
 
 // CreatePhysicalVolume creates a physical volume of the given device.
-func CreatePhysicalVolume(dev string) (*PhysicalVolume, error) {
-	if err := run("pvcreate", nil, dev); err != nil {
-		return nil, fmt.Errorf("lvm: CreatePhysicalVolume: %v", err)
+func CreatePhysicalVolume(ctx context.Context, dev string) (*PhysicalVolume, error) {
+	if err := run(ctx, "pvcreate", nil, dev); err != nil {
+		return nil, fmt.Errorf("lvm: CreatePhysicalVolume: %w", err)
 	}
 	return &PhysicalVolume{dev}, nil
 }
@@ -874,9 +893,9 @@ type pvsOutput struct {
 }
 
 // ListPhysicalVolumes lists all physical volumes.
-func ListPhysicalVolumes() ([]*PhysicalVolume, error) {
+func ListPhysicalVolumes(ctx context.Context) ([]*PhysicalVolume, error) {
 	result := new(pvsOutput)
-	if err := run("pvs", result); err != nil {
+	if err := run(ctx, "pvs", result); err != nil {
 		return nil, err
 	}
 	var pvs []*PhysicalVolume
@@ -889,9 +908,9 @@ func ListPhysicalVolumes() ([]*PhysicalVolume, error) {
 }
 
 // LookupPhysicalVolume returns a physical volume with the given name.
-func LookupPhysicalVolume(name string) (*PhysicalVolume, error) {
+func LookupPhysicalVolume(ctx context.Context, name string) (*PhysicalVolume, error) {
 	result := new(pvsOutput)
-	if err := run("pvs", result, "--options=pv_name", name); err != nil {
+	if err := run(ctx, "pvs", result, "--options=pv_name", name); err != nil {
 		if IsPhysicalVolumeNotFound(err) {
 			return nil, ErrPhysicalVolumeNotFound
 		}
@@ -908,18 +927,27 @@ func LookupPhysicalVolume(name string) (*PhysicalVolume, error) {
 // Extent sizing for linear logical volumes:
 // https://github.com/Jajcus/lvm2/blob/266d6564d7a72fcff5b25367b7a95424ccf8089e/lib/metadata/metadata.c#L983
 
-func run(cmd string, v interface{}, extraArgs ...string) error {
+func run(ctx context.Context, cmd string, v interface{}, extraArgs ...string) error {
+	// Every invocation is bounded by the command timeout, even if the
+	// caller's context carries no deadline of its own. A wedged lvm2 utility
+	// would otherwise hold the lock below and stall all other callers.
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+	// The lock file below only excludes other processes.
+	if err := processLock.Acquire(ctx, 1); err != nil {
+		return fmt.Errorf("lvm: acquire lock failed: %w", err)
+	}
+	defer processLock.Release(1)
 	// lvmlock can be nil, as it is a global variable that is intended to be
 	// initialized from calling code outside this package. We have no way of
 	// knowing whether the caller performed that initialization and must
 	// defensively check. In the future, we may decide to simply panic with a
 	// nil pointer dereference.
 	if lvmlock != nil {
-		// We use Lock instead of TryLock as we have no alternative way of
-		// making progress. We expect lvm2 command-line utilities invoked by
-		// this package to return within a reasonable amount of time.
-		if lerr := lvmlock.Lock(); lerr != nil {
-			return fmt.Errorf("lvm: acquire lock failed: %v", lerr)
+		// We poll for the lock rather than block on it so that we give
+		// up as soon as the context is done.
+		if _, lerr := lvmlock.TryLockContext(ctx, lockRetryDelay); lerr != nil {
+			return fmt.Errorf("lvm: acquire lock failed: %w", lerr)
 		}
 		defer func() {
 			if lerr := lvmlock.Unlock(); lerr != nil {
@@ -928,28 +956,40 @@ func run(cmd string, v interface{}, extraArgs ...string) error {
 		}()
 	}
 	var args []string
+	if cmd == "lvm" && len(extraArgs) > 0 {
+		// The lvm binary expects the name of the command first.
+		args = append(args, extraArgs[0])
+		extraArgs = extraArgs[1:]
+	}
 	if v != nil {
 		args = append(args, "--reportformat=json")
 		args = append(args, "--units=b")
 		args = append(args, "--nosuffix")
 	}
 	args = append(args, extraArgs...)
-	c := exec.Command(cmd, args...)
-	log.Printf("Executing: %v", c)
-	stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
-	c.Stdout = stdout
-	c.Stderr = stderr
-	if err := c.Run(); err != nil {
-		errstr := ignoreWarnings(stderr.String())
-		log.Print("stdout: " + stdout.String())
+	stdoutbuf, stderrbuf, err := executor.Execute(ctx, cmd, args...)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("lvm: %s %v: %w", cmd, args, ctxErr)
+		}
+		errstr := ignoreWarnings(string(stderrbuf))
+		log.Print("stdout: " + string(stdoutbuf))
 		log.Print("stderr: " + errstr)
-		return errors.New(errstr)
+		exitStatus := -1
+		var exitErr interface{ ExitCode() int }
+		if errors.As(err, &exitErr) {
+			exitStatus = exitErr.ExitCode()
+		}
+		return &CommandError{
+			Cmd:        cmd,
+			Args:       args,
+			ExitStatus: exitStatus,
+			Stderr:     errstr,
+		}
 	}
-	stdoutbuf := stdout.Bytes()
-	stderrbuf := stderr.Bytes()
 	errstr := ignoreWarnings(string(stderrbuf))
-	log.Printf("stdout: " + string(stdoutbuf))
-	log.Printf("stderr: " + errstr)
+	log.Print("stdout: " + string(stdoutbuf))
+	log.Print("stderr: " + errstr)
 	if v != nil {
 		if err := json.Unmarshal(stdoutbuf, v); err != nil {
 			return fmt.Errorf("%v: [%v]", err, string(stdoutbuf))
@@ -964,7 +1004,7 @@ func ignoreWarnings(str string) string {
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if strings.HasPrefix(line, "WARNING") {
-			log.Printf(line)
+			log.Print(line)
 			continue
 		}
 		// Ignore warnings of the kind:
@@ -973,7 +1013,7 @@ func ignoreWarnings(str string) string {
 		// that it didn't create when it exits. This doesn't play nice with the fact
 		// that csilvm gets launched by e.g., mesos-agent.
 		if strings.HasPrefix(line, "File descriptor") {
-			log.Printf(line)
+			log.Print(line)
 			continue
 		}
 		result = append(result, line)
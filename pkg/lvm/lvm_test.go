@@ -3,6 +3,7 @@
 package lvm
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"reflect"
@@ -29,8 +30,8 @@ func init() {
 	SetLockFilePath(file.Name())
 }
 
-func check(fn func() error) {
-	if err := fn(); err != nil {
+func check(fn func(context.Context) error) {
+	if err := fn(context.Background()); err != nil {
 		panic(err)
 	}
 }
@@ -41,11 +42,11 @@ func TestCreatePhysicalDevice(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop.Close()
-	if err = PVScan(loop.Path()); err != nil {
+	if err = PVScan(context.Background(), loop.Path()); err != nil {
 		t.Fatal(err)
 	}
 	// Create a physical volume using the loop device.
-	pv, err := CreatePhysicalVolume(loop.Path())
+	pv, err := CreatePhysicalVolume(context.Background(), loop.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -58,16 +59,16 @@ func TestListPhysicalVolumes(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop.Close()
-	if err = PVScan(loop.Path()); err != nil {
+	if err = PVScan(context.Background(), loop.Path()); err != nil {
 		t.Fatal(err)
 	}
 	// Create a physical volume using the loop device.
-	pv, err := CreatePhysicalVolume(loop.Path())
+	pv, err := CreatePhysicalVolume(context.Background(), loop.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(pv.Remove)
-	pvs, err := ListPhysicalVolumes()
+	pvs, err := ListPhysicalVolumes(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -85,16 +86,16 @@ func TestLookupPhysicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop.Close()
-	if err = PVScan(loop.Path()); err != nil {
+	if err = PVScan(context.Background(), loop.Path()); err != nil {
 		t.Fatal(err)
 	}
 	// Create a physical volume using the loop device.
-	pv, err := CreatePhysicalVolume(loop.Path())
+	pv, err := CreatePhysicalVolume(context.Background(), loop.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(pv.Remove)
-	pv2, err := LookupPhysicalVolume(pv.dev)
+	pv2, err := LookupPhysicalVolume(context.Background(), pv.dev)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -110,15 +111,15 @@ func TestLookupPhysicalVolumeNonExistent(t *testing.T) {
 	}
 	defer loop.Close()
 	// Create a physical volume using the loop device.
-	if err = PVScan(loop.Path()); err != nil {
+	if err = PVScan(context.Background(), loop.Path()); err != nil {
 		t.Fatal(err)
 	}
-	pv, err := CreatePhysicalVolume(loop.Path())
+	pv, err := CreatePhysicalVolume(context.Background(), loop.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(pv.Remove)
-	pv2, err := LookupPhysicalVolume(pv.dev + "a")
+	pv2, err := LookupPhysicalVolume(context.Background(), pv.dev+"a")
 	if err != ErrPhysicalVolumeNotFound {
 		t.Fatal("Expected 'not found' error.")
 	}
@@ -133,16 +134,16 @@ func TestPhysicalVolumeCheck(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer loop.Close()
-	if err = PVScan(loop.Path()); err != nil {
+	if err = PVScan(context.Background(), loop.Path()); err != nil {
 		t.Fatal(err)
 	}
 	// Create a physical volume using the loop device.
-	pv, err := CreatePhysicalVolume(loop.Path())
+	pv, err := CreatePhysicalVolume(context.Background(), loop.Path())
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(pv.Remove)
-	if err := pv.Check(); err != nil {
+	if err := pv.Check(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -186,7 +187,7 @@ func TestListVolumeGroupNames(t *testing.T) {
 	}
 	defer cleanup2()
 	// Scan for new devices and volume groups so the new ones show up.
-	names, err := ListVolumeGroupNames()
+	names, err := ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -217,7 +218,7 @@ func TestCreateVolumeGroup(t *testing.T) {
 	}
 	defer cleanup()
 	// Confirm that the volume group exists.
-	names, err := ListVolumeGroupNames()
+	names, err := ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -246,7 +247,7 @@ func TestCreateVolumeGroup_Tagged(t *testing.T) {
 	}
 	defer cleanup()
 	// Confirm that the volume group exists.
-	names, err := ListVolumeGroupNames()
+	names, err := ListVolumeGroupNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -259,7 +260,7 @@ func TestCreateVolumeGroup_Tagged(t *testing.T) {
 	if !had {
 		t.Fatalf("Expected volume group '%s'", vg.name)
 	}
-	tags, err := vg.Tags()
+	tags, err := vg.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -286,7 +287,7 @@ func TestCreateVolumeGroup_BadTag(t *testing.T) {
 
 func TestCreateVolumeGroupInvalidName(t *testing.T) {
 	// Try to create the volume group with a bad name.
-	vg, err := CreateVolumeGroup("bad name :)", nil, nil)
+	vg, err := CreateVolumeGroup(context.Background(), "bad name :)", nil, nil)
 	if err != ErrInvalidVGName {
 		check(vg.Remove)
 		t.Fatalf("Expected invalidNameError got %#v.", err)
@@ -308,7 +309,7 @@ func TestLookupVolumeGroup(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	vg2, err := LookupVolumeGroup(vg.name)
+	vg2, err := LookupVolumeGroup(context.Background(), vg.name)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -328,7 +329,7 @@ func TestLookupVolumeGroupNonExistent(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	vg2, err := LookupVolumeGroup(vg.name + "a")
+	vg2, err := LookupVolumeGroup(context.Background(), vg.name+"a")
 	if err != ErrVolumeGroupNotFound {
 		t.Fatal("Expected 'not found' error.")
 	}
@@ -348,7 +349,7 @@ func TestVolumeGroupCheck(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	if err := vg.Check(); err != nil {
+	if err := vg.Check(context.Background()); err != nil {
 		t.Fatal(err)
 	}
 }
@@ -365,11 +366,11 @@ func TestVolumeGroupBytesTotal(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesTotal()
+	size, err := vg.BytesTotal(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
-	extentSize, err := vg.ExtentSize()
+	extentSize, err := vg.ExtentSize(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -392,11 +393,11 @@ func TestVolumeGroupBytesFree(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	extentSize, err := vg.ExtentSize()
+	extentSize, err := vg.ExtentSize(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -418,12 +419,12 @@ func TestCreateLogicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -441,18 +442,18 @@ func TestCreateLogicalVolume_Tagged(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size, []string{tag})
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, []string{tag})
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	tags, err := lv.Tags()
+	tags, err := lv.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -472,12 +473,12 @@ func TestCreateLogicalVolume_BadTag(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, []string{"{\"some\": \"json\"}"})
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, []string{"{\"some\": \"json\"}"})
 	if err != ErrTagHasInvalidChars {
 		t.Fatalf("Expected invalid tag error, got %v", err)
 	}
@@ -502,12 +503,12 @@ func TestCreateLogicalVolumeDuplicateNameIsAllowed(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg1.BytesFree(VolumeLayout{})
+	size, err := vg1.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv1, err := vg1.CreateLogicalVolume(name, size, nil)
+	lv1, err := vg1.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -517,11 +518,11 @@ func TestCreateLogicalVolumeDuplicateNameIsAllowed(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err = vg2.BytesFree(VolumeLayout{})
+	size, err = vg2.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	lv2, err := vg2.CreateLogicalVolume(name, size, nil)
+	lv2, err := vg2.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -539,11 +540,11 @@ func TestCreateLogicalVolumeInvalidName(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	lv, err := vg.CreateLogicalVolume("bad name :)", size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), "bad name :)", size, nil)
 	if err != ErrInvalidLVName {
 		check(lv.Remove)
 		t.Fatalf("Expected an invalidNameError but got %#v.", err)
@@ -565,11 +566,11 @@ func TestCreateLogicalVolumeTooLarge(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
-	lv, err := vg.CreateLogicalVolume("testvol", size*2, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), "testvol", size*2, nil)
 	if err != ErrNoSpace {
 		check(lv.Remove)
 		t.Fatal("Expected ErrNoSpace.")
@@ -592,18 +593,18 @@ func TestCreateLogicalVolume_VolumeLayout_Empty(t *testing.T) {
 	}
 	defer cleanup()
 	raid := VolumeLayout{}
-	size, err := vg.BytesFree(raid)
+	size, err := vg.BytesFree(context.Background(), raid)
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, []string{tag}, VolumeLayoutOpt(raid))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	tags, err := lv.Tags()
+	tags, err := lv.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -624,18 +625,18 @@ func TestCreateLogicalVolume_VolumeLayout_Linear(t *testing.T) {
 	}
 	defer cleanup()
 	raid := VolumeLayout{Type: VolumeTypeLinear}
-	size, err := vg.BytesFree(raid)
+	size, err := vg.BytesFree(context.Background(), raid)
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, []string{tag}, VolumeLayoutOpt(raid))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	tags, err := lv.Tags()
+	tags, err := lv.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -661,18 +662,18 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1(t *testing.T) {
 	}
 	defer cleanup()
 	raid := VolumeLayout{Type: VolumeTypeRAID1}
-	size, err := vg.BytesFree(raid)
+	size, err := vg.BytesFree(context.Background(), raid)
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size/2, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size/2, []string{tag}, VolumeLayoutOpt(raid))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	tags, err := lv.Tags()
+	tags, err := lv.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -708,18 +709,18 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1_Mirrors2(t *testing.T) {
 	}
 	defer cleanup()
 	raid := VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 2}
-	size, err := vg.BytesFree(raid)
+	size, err := vg.BytesFree(context.Background(), raid)
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size/4, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size/4, []string{tag}, VolumeLayoutOpt(raid))
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	tags, err := lv.Tags()
+	tags, err := lv.Tags(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -745,13 +746,13 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1_NotEnoughSpace(t *testing.T) {
 	}
 	defer cleanup()
 	raid := VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1}
-	size, err := vg.BytesFree(raid)
+	size, err := vg.BytesFree(context.Background(), raid)
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
-	lv, err := vg.CreateLogicalVolume(name, size*2, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size*2, []string{tag}, VolumeLayoutOpt(raid))
 	if err == nil {
 		defer check(lv.Remove)
 		t.Fatalf("Expected error due to too few disks")
@@ -778,7 +779,7 @@ func TestCreateLogicalVolume_VolumeLayout_RAID1_TooFewDisks(t *testing.T) {
 	name := "test-lv-" + uuid.New().String()
 	tag := "dcos-tag"
 	raid := VolumeLayout{Type: VolumeTypeRAID1, Mirrors: 1}
-	lv, err := vg.CreateLogicalVolume(name, size, []string{tag}, VolumeLayoutOpt(raid))
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, []string{tag}, VolumeLayoutOpt(raid))
 	if err == nil {
 		defer check(lv.Remove)
 		t.Fatalf("Expected error due to too few disks")
@@ -799,17 +800,17 @@ func TestLookupLogicalVolume(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	lv2, err := vg.LookupLogicalVolume(lv.name)
+	lv2, err := vg.LookupLogicalVolume(context.Background(), lv.name)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -829,17 +830,17 @@ func TestLookupLogicalVolumeNonExistent(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	lv2, err := vg.LookupLogicalVolume(lv.name + "a")
+	lv2, err := vg.LookupLogicalVolume(context.Background(), lv.name+"a")
 	if err != ErrLogicalVolumeNotFound {
 		t.Fatalf("Expected 'not found' error got %s", err)
 	}
@@ -859,12 +860,12 @@ func TestLogicalVolumeName(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -885,12 +886,12 @@ func TestLogicalVolumeSizeInBytes(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -898,7 +899,7 @@ func TestLogicalVolumeSizeInBytes(t *testing.T) {
 	if lv.SizeInBytes() != size {
 		t.Fatalf("Expected size %v but got %v.", size, lv.SizeInBytes())
 	}
-	lv2, err := vg.LookupLogicalVolume(lv.Name())
+	lv2, err := vg.LookupLogicalVolume(context.Background(), lv.Name())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -918,17 +919,17 @@ func TestLogicalVolumePath(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name := "test-lv-" + uuid.New().String()
-	lv, err := vg.CreateLogicalVolume(name, size, nil)
+	lv, err := vg.CreateLogicalVolume(context.Background(), name, size, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv.Remove)
-	path, err := lv.Path()
+	path, err := lv.Path(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -949,23 +950,23 @@ func TestVolumeGroupListLogicalVolumeNames(t *testing.T) {
 		t.Fatal(err)
 	}
 	defer cleanup()
-	size, err := vg.BytesFree(VolumeLayout{})
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	name1 := "test-lv-" + uuid.New().String()
-	lv1, err := vg.CreateLogicalVolume(name1, size/2, nil)
+	lv1, err := vg.CreateLogicalVolume(context.Background(), name1, size/2, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv1.Remove)
 	name2 := "test-lv-" + uuid.New().String()
-	lv2, err := vg.CreateLogicalVolume(name2, size/2, nil)
+	lv2, err := vg.CreateLogicalVolume(context.Background(), name2, size/2, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	defer check(lv2.Remove)
-	lvnames, err := vg.ListLogicalVolumeNames()
+	lvnames, err := vg.ListLogicalVolumeNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1003,7 +1004,7 @@ func TestVolumeGroupListPhysicalVolumeNames(t *testing.T) {
 	defer cleanup()
 	exp := []string{loop1.Path(), loop2.Path()}
 	sort.Strings(exp)
-	pvnames, err := vg.ListPhysicalVolumeNames()
+	pvnames, err := vg.ListPhysicalVolumeNames(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1024,23 +1025,23 @@ func createVolumeGroup(loopdevs []*LoopDevice, tags []string) (*VolumeGroup, fun
 	// Create a physical volume using the loop device.
 	var pvs []*PhysicalVolume
 	for _, loop := range loopdevs {
-		if err = PVScan(loop.Path()); err != nil {
+		if err = PVScan(context.Background(), loop.Path()); err != nil {
 			return nil, nil, err
 		}
 		var pv *PhysicalVolume
-		pv, err = CreatePhysicalVolume(loop.Path())
+		pv, err = CreatePhysicalVolume(context.Background(), loop.Path())
 		if err != nil {
 			return nil, nil, err
 		}
-		cleanup.Add(func() error { return pv.Remove() })
+		cleanup.Add(func() error { return pv.Remove(context.Background()) })
 		pvs = append(pvs, pv)
 	}
 	// Create a volume group containing the physical volume.
 	vgname := "test-vg-" + uuid.New().String()
-	vg, err := CreateVolumeGroup(vgname, pvs, tags)
+	vg, err := CreateVolumeGroup(context.Background(), vgname, pvs, tags)
 	if err != nil {
 		return nil, nil, err
 	}
-	cleanup.Add(vg.Remove)
+	cleanup.Add(func() error { return vg.Remove(context.Background()) })
 	return vg, cleanup.Unwind, nil
 }
@@ -0,0 +1,245 @@
+package lvm
+
+import (
+	"context"
+	"strings"
+)
+
+// Report is a point-in-time view of physical volumes, volume groups,
+// logical volumes and logical volume segments. It is produced by a single
+// `lvm fullreport` invocation and is much cheaper to obtain than the
+// equivalent series of `pvs`, `vgs` and `lvs` commands.
+//
+// The contents of a Report are not updated when the system changes.
+type Report struct {
+	PhysicalVolumes []PhysicalVolumeReport
+	VolumeGroups    []VolumeGroupReport
+	LogicalVolumes  []LogicalVolumeReport
+	Segments        []SegmentReport
+}
+
+// PhysicalVolumeReport describes a physical volume.
+type PhysicalVolumeReport struct {
+	Name   string `json:"pv_name"`
+	VgName string `json:"vg_name"`
+	Size   uint64 `json:"pv_size,string"`
+	Free   uint64 `json:"pv_free,string"`
+}
+
+// VolumeGroupReport describes a volume group.
+type VolumeGroupReport struct {
+	Name            string `json:"vg_name"`
+	UUID            string `json:"vg_uuid"`
+	Size            uint64 `json:"vg_size,string"`
+	Free            uint64 `json:"vg_free,string"`
+	ExtentSize      uint64 `json:"vg_extent_size,string"`
+	ExtentCount     uint64 `json:"vg_extent_count,string"`
+	FreeExtentCount uint64 `json:"vg_free_count,string"`
+	RawTags         string `json:"vg_tags"`
+}
+
+// Tags returns the volume group tags.
+func (vg VolumeGroupReport) Tags() []string {
+	return splitTags(vg.RawTags)
+}
+
+// LogicalVolumeReport describes a logical volume.
+type LogicalVolumeReport struct {
+	Name    string `json:"lv_name"`
+	VgName  string `json:"vg_name"`
+	Path    string `json:"lv_path"`
+	Size    uint64 `json:"lv_size,string"`
+	RawTags string `json:"lv_tags"`
+	// Attr is the lv_attr field. See lvs(8) for its interpretation.
+	Attr string `json:"lv_attr"`
+}
+
+// Tags returns the logical volume tags.
+func (lv LogicalVolumeReport) Tags() []string {
+	return splitTags(lv.RawTags)
+}
+
+// HasTag returns true if the logical volume is tagged with tag.
+func (lv LogicalVolumeReport) HasTag(tag string) bool {
+	for _, t := range lv.Tags() {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Active returns true if the logical volume is active on this host.
+func (lv LogicalVolumeReport) Active() bool {
+	return len(lv.Attr) > 4 && lv.Attr[4] == 'a'
+}
+
+// DeviceOpen returns true if the device of the logical volume is open on
+// this host, e.g., as it is mounted.
+func (lv LogicalVolumeReport) DeviceOpen() bool {
+	return len(lv.Attr) > 5 && lv.Attr[5] == 'o'
+}
+
+// SegmentReport describes a logical volume segment.
+type SegmentReport struct {
+	LvName  string `json:"lv_name"`
+	VgName  string `json:"vg_name"`
+	Type    string `json:"segtype"`
+	Start   uint64 `json:"seg_start,string"`
+	Size    uint64 `json:"seg_size,string"`
+	Devices string `json:"devices"`
+}
+
+type fullreportOutput struct {
+	Report []struct {
+		Vg  []VolumeGroupReport    `json:"vg"`
+		Pv  []PhysicalVolumeReport `json:"pv"`
+		Lv  []LogicalVolumeReport  `json:"lv"`
+		Seg []SegmentReport        `json:"seg"`
+	} `json:"report"`
+}
+
+// FullReport returns a Report of the named volume groups or of all volume
+// groups if none are named. It returns ErrVolumeGroupNotFound if any of the
+// named volume groups does not exist.
+func FullReport(ctx context.Context, vgnames ...string) (*Report, error) {
+	args := []string{
+		"fullreport",
+		"--configreport=vg",
+		"--options=vg_name,vg_uuid,vg_size,vg_free,vg_extent_size,vg_extent_count,vg_free_count,vg_tags",
+		"--configreport=pv",
+		"--options=pv_name,vg_name,pv_size,pv_free",
+		"--configreport=lv",
+		"--options=lv_name,vg_name,lv_path,lv_size,lv_tags,lv_attr",
+		"--configreport=seg",
+		"--options=lv_name,vg_name,segtype,seg_start,seg_size,devices",
+	}
+	args = append(args, vgnames...)
+	result := new(fullreportOutput)
+	if err := run(ctx, "lvm", result, args...); err != nil {
+		if IsVolumeGroupNotFound(err) {
+			return nil, ErrVolumeGroupNotFound
+		}
+		return nil, err
+	}
+	report := new(Report)
+	for _, r := range result.Report {
+		// Each volume group is reported separately. Fill in the name of
+		// the volume group should a subreport omit it.
+		var vgname string
+		for _, vg := range r.Vg {
+			vgname = vg.Name
+		}
+		report.VolumeGroups = append(report.VolumeGroups, r.Vg...)
+		report.PhysicalVolumes = append(report.PhysicalVolumes, r.Pv...)
+		for _, lv := range r.Lv {
+			if lv.VgName == "" {
+				lv.VgName = vgname
+			}
+			report.LogicalVolumes = append(report.LogicalVolumes, lv)
+		}
+		for _, seg := range r.Seg {
+			if seg.VgName == "" {
+				seg.VgName = vgname
+			}
+			report.Segments = append(report.Segments, seg)
+		}
+	}
+	return report, nil
+}
+
+// Report returns a Report of this volume group.
+func (vg *VolumeGroup) Report(ctx context.Context) (*Report, error) {
+	return FullReport(ctx, vg.name)
+}
+
+// LogicalVolume returns a handle for the logical volume described by lv
+// without invoking any lvm2 commands.
+func (vg *VolumeGroup) LogicalVolume(lv LogicalVolumeReport) *LogicalVolume {
+	return &LogicalVolume{lv.Name, lv.Size, vg}
+}
+
+// VolumeGroup returns the volume group with the given name.
+func (r *Report) VolumeGroup(name string) (VolumeGroupReport, error) {
+	for _, vg := range r.VolumeGroups {
+		if vg.Name == name {
+			return vg, nil
+		}
+	}
+	return VolumeGroupReport{}, ErrVolumeGroupNotFound
+}
+
+// PhysicalVolumeNames returns the names of the physical volumes in the
+// named volume group.
+func (r *Report) PhysicalVolumeNames(vgname string) []string {
+	var names []string
+	for _, pv := range r.PhysicalVolumes {
+		if pv.VgName == vgname {
+			names = append(names, pv.Name)
+		}
+	}
+	return names
+}
+
+// LogicalVolumesIn returns the logical volumes in the named volume group.
+func (r *Report) LogicalVolumesIn(vgname string) []LogicalVolumeReport {
+	var lvs []LogicalVolumeReport
+	for _, lv := range r.LogicalVolumes {
+		if lv.VgName == vgname {
+			lvs = append(lvs, lv)
+		}
+	}
+	return lvs
+}
+
+// LogicalVolume returns the logical volume with the given name in the named
+// volume group.
+func (r *Report) LogicalVolume(vgname, name string) (LogicalVolumeReport, error) {
+	return r.FindLogicalVolume(vgname, func(lv LogicalVolumeReport) bool { return lv.Name == name })
+}
+
+// FindLogicalVolume returns the first logical volume in the named volume
+// group for which match returns true.
+func (r *Report) FindLogicalVolume(vgname string, match func(LogicalVolumeReport) bool) (LogicalVolumeReport, error) {
+	for _, lv := range r.LogicalVolumes {
+		if lv.VgName == vgname && match(lv) {
+			return lv, nil
+		}
+	}
+	return LogicalVolumeReport{}, ErrLogicalVolumeNotFound
+}
+
+// SegmentsOf returns the segments of the given logical volume.
+func (r *Report) SegmentsOf(vgname, lvname string) []SegmentReport {
+	var segs []SegmentReport
+	for _, seg := range r.Segments {
+		if seg.VgName == vgname && seg.LvName == lvname {
+			segs = append(segs, seg)
+		}
+	}
+	return segs
+}
+
+// BytesFree returns the unallocated space in bytes of the named volume group
+// available to logical volumes with the given layout. It matches
+// VolumeGroup.BytesFree.
+func (r *Report) BytesFree(vgname string, layout VolumeLayout) (uint64, error) {
+	vg, err := r.VolumeGroup(vgname)
+	if err != nil {
+		return 0, err
+	}
+	if len(r.PhysicalVolumeNames(vgname)) < int(layout.MinNumberOfDevices()) {
+		return 0, nil
+	}
+	return layout.extentsFree(vg.FreeExtentCount) * vg.ExtentSize, nil
+}
+
+func splitTags(s string) (tags []string) {
+	for _, tag := range strings.Split(s, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
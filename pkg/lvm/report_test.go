@@ -0,0 +1,70 @@
+package lvm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestFakeFullReport(t *testing.T) {
+	defer SetExecutor(nil)
+	fake, vg := fakeVolumeGroup(t, 2, []string{"vgtag"})
+	ctx := context.Background()
+	if _, err := vg.CreateLogicalVolume(ctx, "test-lv", 8<<20, []string{"lvtag"}); err != nil {
+		t.Fatal(err)
+	}
+	before := len(fake.Commands())
+	report, err := vg.Report(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fake.Commands()) - before; n != 1 {
+		t.Fatalf("Expected a single command but got %d", n)
+	}
+	vgr, err := report.VolumeGroup("test-vg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(vgr.Tags(), []string{"vgtag"}) {
+		t.Fatalf("Unexpected volume group tags %v", vgr.Tags())
+	}
+	total, err := vg.BytesTotal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vgr.Size != total {
+		t.Fatalf("Expected size %d but got %d", total, vgr.Size)
+	}
+	if names := report.PhysicalVolumeNames("test-vg"); !reflect.DeepEqual(names, []string{"/dev/fakea", "/dev/fakeb"}) {
+		t.Fatalf("Unexpected physical volumes %v", names)
+	}
+	for _, layout := range []VolumeLayout{{}, {Type: VolumeTypeRAID1}} {
+		exp, err := vg.BytesFree(ctx, layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		free, err := report.BytesFree("test-vg", layout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if free != exp {
+			t.Fatalf("Expected %d bytes free for layout %v but got %d", exp, layout, free)
+		}
+	}
+	lv, err := report.LogicalVolume("test-vg", "test-lv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.Path != "/dev/test-vg/test-lv" || lv.Size != 8<<20 || !lv.HasTag("lvtag") || lv.Active() {
+		t.Fatalf("Unexpected logical volume %+v", lv)
+	}
+	if segs := report.SegmentsOf("test-vg", "test-lv"); len(segs) != 1 || segs[0].Size != 8<<20 {
+		t.Fatalf("Unexpected segments %+v", segs)
+	}
+	if _, err := report.LogicalVolume("test-vg", "missing"); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got %v", err)
+	}
+	if _, err := FullReport(ctx, "missing"); err != ErrVolumeGroupNotFound {
+		t.Fatalf("Expected ErrVolumeGroupNotFound but got %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package lvm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selection is an lvm2 selection criteria expression. Reporting commands
+// given a Selection only report the objects that satisfy it, so that
+// filtering happens in lvm2 rather than in this package. See lvmreport(7).
+//
+// The zero Selection selects every object.
+type Selection struct {
+	terms []string
+	err   error
+}
+
+// FieldEquals selects objects whose field has the given value.
+func FieldEquals(field, value string) Selection {
+	return selectionTerm(field, "=", value)
+}
+
+// FieldMatches selects objects whose field matches the regular expression
+// re, e.g., FieldMatches("lv_name", "^csilv").
+func FieldMatches(field, re string) Selection {
+	return selectionTerm(field, "=~", re)
+}
+
+// FieldContains selects objects whose string list field, such as lv_tags,
+// contains item.
+func FieldContains(field, item string) Selection {
+	quoted, err := quoteSelectionValue(item)
+	if err != nil {
+		return Selection{err: err}
+	}
+	return Selection{terms: []string{field + "={" + quoted + "}"}}
+}
+
+// VGName selects the volume group with the given name or the physical and
+// logical volumes in it.
+func VGName(name string) Selection {
+	return FieldEquals("vg_name", name)
+}
+
+// LVName selects the logical volume with the given name.
+func LVName(name string) Selection {
+	return FieldEquals("lv_name", name)
+}
+
+// LVMatchTag selects logical volumes tagged with tag.
+func LVMatchTag(tag string) Selection {
+	return FieldContains("lv_tags", tag)
+}
+
+// And returns a Selection of the objects selected by s and all of others.
+func (s Selection) And(others ...Selection) Selection {
+	result := Selection{terms: append([]string(nil), s.terms...), err: s.err}
+	for _, o := range others {
+		result.terms = append(result.terms, o.terms...)
+		if result.err == nil {
+			result.err = o.err
+		}
+	}
+	return result
+}
+
+// String returns the selection criteria as passed to lvm2.
+func (s Selection) String() string {
+	// The terms are joined without whitespace so that the expression
+	// remains a single word in the lvm shell.
+	return strings.Join(s.terms, "&&")
+}
+
+// args returns the command-line arguments that apply the selection.
+func (s Selection) args() ([]string, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(s.terms) == 0 {
+		return nil, nil
+	}
+	return []string{"--select=" + s.String()}, nil
+}
+
+func selectionTerm(field, op, value string) Selection {
+	quoted, err := quoteSelectionValue(value)
+	if err != nil {
+		return Selection{err: err}
+	}
+	return Selection{terms: []string{field + op + quoted}}
+}
+
+// quoteSelectionValue quotes value so that lvm2 does not interpret any of
+// its characters, such as '+', '.', '&' or '|', as part of the expression.
+// lvm2 has no escape character so a value cannot contain both kinds of
+// quotes.
+func quoteSelectionValue(value string) (string, error) {
+	switch {
+	case !strings.Contains(value, `"`):
+		return `"` + value + `"`, nil
+	case !strings.Contains(value, `'`):
+		return `'` + value + `'`, nil
+	}
+	return "", fmt.Errorf("lvm: cannot select value containing both ' and \": %s", value)
+}
@@ -0,0 +1,112 @@
+package lvm
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestSelectionString(t *testing.T) {
+	tests := []struct {
+		sel Selection
+		exp string
+	}{
+		{Selection{}, ""},
+		{VGName("x"), `vg_name="x"`},
+		{LVName("test-lv"), `lv_name="test-lv"`},
+		{FieldMatches("lv_name", "^csilv"), `lv_name=~"^csilv"`},
+		{LVMatchTag("VN.foo"), `lv_tags={"VN.foo"}`},
+		{LVMatchTag("VN.a+b"), `lv_tags={"VN.a+b"}`},
+		{FieldEquals("lv_name", `a"b`), `lv_name='a"b'`},
+		{VGName("x").And(LVMatchTag("VN.foo"), FieldMatches("lv_name", "^csilv")),
+			`vg_name="x"&&lv_tags={"VN.foo"}&&lv_name=~"^csilv"`},
+	}
+	for _, tt := range tests {
+		if got := tt.sel.String(); got != tt.exp {
+			t.Errorf("Expected selection %q but got %q", tt.exp, got)
+		}
+	}
+}
+
+func TestSelectionArgs(t *testing.T) {
+	args, err := Selection{}.args()
+	if err != nil || args != nil {
+		t.Fatalf("Expected no arguments but got %v, %v", args, err)
+	}
+	args, err = LVName("test-lv").args()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 1 || args[0] != `--select=lv_name="test-lv"` {
+		t.Fatalf("Unexpected arguments %v", args)
+	}
+	if _, err := VGName("x").And(LVName(`a'b"c`)).args(); err == nil {
+		t.Fatal("Expected an error for a value that cannot be quoted")
+	}
+}
+
+func TestFakeFindLogicalVolumeByTag(t *testing.T) {
+	fake, vg := fakeVolumeGroup(t, 1, nil)
+	defer SetExecutor(nil)
+	ctx := context.Background()
+	// The tags differ only in characters that are special in regular
+	// expressions and would match each other if not quoted.
+	for name, tag := range map[string]string{
+		"csilv-a": "VN.a+b",
+		"csilv-b": "VN.aab",
+		"other":   "VN.a.b",
+	} {
+		if _, err := vg.CreateLogicalVolume(ctx, name, fakeDeviceSize/10, []string{tag}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for tag, exp := range map[string]string{
+		"VN.a+b": "csilv-a",
+		"VN.aab": "csilv-b",
+		"VN.a.b": "other",
+	} {
+		lv, err := vg.FindLogicalVolume(ctx, LVMatchTag(tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lv.Name() != exp {
+			t.Fatalf("Expected tag %q to select %s but got %s", tag, exp, lv.Name())
+		}
+	}
+	if _, err := vg.FindLogicalVolume(ctx, LVMatchTag("VN.a")); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got %v", err)
+	}
+	if _, err := vg.FindLogicalVolume(ctx, FieldMatches("lv_name", "^csilv").And(LVMatchTag("VN.a.b"))); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got %v", err)
+	}
+	lv, err := vg.LookupLogicalVolume(ctx, "csilv-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lv.Name() != "csilv-b" {
+		t.Fatalf("Expected csilv-b but got %s", lv.Name())
+	}
+	// The filtering happens in lvm2.
+	cmds := fake.Commands()
+	last := strings.Join(cmds[len(cmds)-1], " ")
+	if !strings.Contains(last, `--select=lv_name="csilv-b"`) {
+		t.Fatalf("Expected the lookup to use --select but got %q", last)
+	}
+}
+
+func TestFakeListPhysicalVolumeNamesSelectsVolumeGroup(t *testing.T) {
+	fake, vg := fakeVolumeGroup(t, 2, nil)
+	defer SetExecutor(nil)
+	ctx := context.Background()
+	fake.AddDevice("/dev/other", fakeDeviceSize)
+	if _, err := CreatePhysicalVolume(ctx, "/dev/other"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := vg.ListPhysicalVolumeNames(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalStrings(names, []string{"/dev/fakea", "/dev/fakeb"}) {
+		t.Fatalf("Unexpected physical volumes %v", names)
+	}
+}
@@ -0,0 +1,367 @@
+package lvm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// shellPrompt is printed by `lvm` in interactive mode when it is ready to
+// read the next command.
+const shellPrompt = "lvm> "
+
+// shellReportFd is the file descriptor on which the lvm shell writes
+// reports and the command log. It is the first of exec.Cmd.ExtraFiles.
+const shellReportFd = 3
+
+// shellConfig enables the JSON command log from which we learn the result
+// of each command. Without it lvm prints nothing that tells us whether a
+// command run in the shell succeeded.
+const shellConfig = "--config=report/output_format=json log/report_command_log=1"
+
+// shellRetryInterval is how long ShellExecutor runs commands as separate
+// processes after it failed to start the lvm shell before trying again.
+const shellRetryInterval = time.Minute
+
+// ShellExecutor is an Executor that keeps a single `lvm` shell process
+// running and feeds LVM2 commands to it on stdin. This avoids spawning a
+// process and initialising lvm2 for every command.
+//
+// Commands that are not LVM2 commands are run as separate processes, as
+// are all commands while the lvm shell cannot be started. Should the shell
+// exit or stop responding it is killed and a new one is started for the
+// next command.
+//
+// Commands are sent to the shell one at a time. Callers of this package
+// additionally hold the lvm lock file (see SetLockFilePath) for the
+// duration of each command, as they do for the default Executor.
+type ShellExecutor struct {
+	// path is the lvm binary.
+	path     string
+	fallback Executor
+
+	mu      sync.Mutex
+	shell   *lvmShell
+	retryAt time.Time
+}
+
+// NewShellExecutor returns a ShellExecutor. The lvm shell is started when
+// the first command is executed.
+func NewShellExecutor() *ShellExecutor {
+	return &ShellExecutor{path: "lvm", fallback: execExecutor{}}
+}
+
+// Execute runs the command in the lvm shell. The returned stdout contains
+// the JSON report written by the command, if any, and stderr the errors it
+// logged.
+func (e *ShellExecutor) Execute(ctx context.Context, cmd string, args ...string) ([]byte, []byte, error) {
+	line, ok := shellCommandLine(cmd, args)
+	if !ok {
+		return e.fallback.Execute(ctx, cmd, args...)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shell == nil {
+		if time.Now().Before(e.retryAt) {
+			return e.fallback.Execute(ctx, cmd, args...)
+		}
+		shell, err := startLVMShell(ctx, e.path)
+		if err != nil {
+			log.Printf("Cannot start lvm shell, executing commands directly: %v", err)
+			e.retryAt = time.Now().Add(shellRetryInterval)
+			return e.fallback.Execute(ctx, cmd, args...)
+		}
+		e.shell = shell
+	}
+	log.Printf("Executing in lvm shell: %s", line)
+	stdout, stderr, err := e.shell.execute(ctx, line)
+	var serr *shellError
+	if errors.As(err, &serr) {
+		// The shell is unusable. The next command starts a new one.
+		e.shell.close()
+		e.shell = nil
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, nil, ctxErr
+		}
+		if !serr.sent {
+			// The command never reached the shell so it is safe to
+			// run it some other way.
+			log.Printf("Executing directly: %v", err)
+			return e.fallback.Execute(ctx, cmd, args...)
+		}
+	}
+	return stdout, stderr, err
+}
+
+// Close stops the lvm shell, if it is running.
+func (e *ShellExecutor) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.shell != nil {
+		e.shell.close()
+		e.shell = nil
+	}
+	return nil
+}
+
+// shellCommandLine returns the line that runs the command in the lvm shell
+// or false if the command is not an LVM2 command.
+func shellCommandLine(cmd string, args []string) (string, bool) {
+	if cmd == "lvm" {
+		if len(args) == 0 {
+			return "", false
+		}
+		cmd, args = args[0], args[1:]
+	}
+	if !isLVMCommand(cmd) {
+		return "", false
+	}
+	words := []string{cmd}
+	for _, arg := range args {
+		words = append(words, quoteShellArg(arg))
+	}
+	words = append(words, quoteShellArg(shellConfig))
+	return strings.Join(words, " "), true
+}
+
+func isLVMCommand(cmd string) bool {
+	for _, prefix := range []string{"pv", "vg", "lv"} {
+		if strings.HasPrefix(cmd, prefix) {
+			return true
+		}
+	}
+	return cmd == "fullreport"
+}
+
+// quoteShellArg quotes arg so that the lvm shell treats it as a single
+// argument. The lvm shell splits lines at whitespace and only treats quotes
+// at the start of an argument specially, so quotes elsewhere, e.g., in
+// selection criteria, are left alone.
+func quoteShellArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n") && !strings.ContainsAny(arg[:1], "\"'#") {
+		return arg
+	}
+	if strings.Contains(arg, `"`) {
+		return `'` + arg + `'`
+	}
+	return `"` + arg + `"`
+}
+
+// shellError is returned by lvmShell.execute when the shell itself failed,
+// as opposed to the command run in it.
+type shellError struct {
+	// sent is true if the command was written to the shell, in which case
+	// it may or may not have been run.
+	sent bool
+	err  error
+}
+
+func (e *shellError) Error() string { return fmt.Sprintf("lvm shell: %v", e.err) }
+func (e *shellError) Unwrap() error { return e.err }
+
+type lvmShell struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	report *json.Decoder
+	// files are our ends of the pipes connected to the shell.
+	files []*os.File
+}
+
+func startLVMShell(ctx context.Context, path string) (*lvmShell, error) {
+	var files, theirs []*os.File
+	pipe := func() (r, w *os.File, err error) {
+		r, w, err = os.Pipe()
+		if err == nil {
+			theirs = append(theirs, w)
+			files = append(files, r)
+		}
+		return r, w, err
+	}
+	closeAll := func(fs []*os.File) {
+		for _, f := range fs {
+			f.Close()
+		}
+	}
+	stdoutR, stdoutW, err := pipe()
+	if err != nil {
+		return nil, err
+	}
+	stderrR, stderrW, err := pipe()
+	if err != nil {
+		closeAll(files)
+		closeAll(theirs)
+		return nil, err
+	}
+	reportR, reportW, err := pipe()
+	if err != nil {
+		closeAll(files)
+		closeAll(theirs)
+		return nil, err
+	}
+	c := exec.Command(path)
+	c.Env = append(os.Environ(), "LC_ALL=C", fmt.Sprintf("LVM_REPORT_FD=%d", shellReportFd))
+	c.Stdout = stdoutW
+	c.Stderr = stderrW
+	c.ExtraFiles = []*os.File{reportW}
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := c.StdinPipe()
+	if err != nil {
+		closeAll(files)
+		closeAll(theirs)
+		return nil, err
+	}
+	log.Printf("Starting lvm shell: %v", c)
+	err = c.Start()
+	// The shell has its own copies of the write ends. Closing ours ensures
+	// that reads fail once the shell exits.
+	closeAll(theirs)
+	if err != nil {
+		closeAll(files)
+		return nil, err
+	}
+	go func() {
+		// Anything lvm writes to stderr is also in the command log. We
+		// log it all the same as it may explain a misbehaving shell.
+		s := bufio.NewScanner(stderrR)
+		for s.Scan() {
+			log.Printf("lvm shell: %s", s.Text())
+		}
+	}()
+	sh := &lvmShell{
+		cmd:    c,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdoutR),
+		report: json.NewDecoder(reportR),
+		files:  files,
+	}
+	if err := sh.wait(ctx, sh.readPrompt); err != nil {
+		sh.close()
+		return nil, err
+	}
+	return sh, nil
+}
+
+// shellCommandLog is the part of a report that describes the outcome of
+// the command.
+type shellCommandLog struct {
+	Log []struct {
+		Type    string      `json:"log_type"`
+		Message string      `json:"log_message"`
+		RetCode json.Number `json:"log_ret_code"`
+	} `json:"log"`
+}
+
+// execute runs a single line in the shell and returns the JSON report it
+// produced and the error messages it logged.
+func (sh *lvmShell) execute(ctx context.Context, line string) ([]byte, []byte, error) {
+	if _, err := io.WriteString(sh.stdin, line+"\n"); err != nil {
+		return nil, nil, &shellError{false, err}
+	}
+	var report json.RawMessage
+	err := sh.wait(ctx, func() error {
+		// The report is complete before the shell prompts for the
+		// next command.
+		if err := sh.report.Decode(&report); err != nil {
+			return err
+		}
+		return sh.readPrompt()
+	})
+	if err != nil {
+		return nil, nil, &shellError{true, err}
+	}
+	var cmdlog shellCommandLog
+	if err := json.Unmarshal(report, &cmdlog); err != nil {
+		return nil, nil, &shellError{true, err}
+	}
+	if len(cmdlog.Log) == 0 {
+		return nil, nil, &shellError{true, errors.New("no command log in report")}
+	}
+	stderr := new(bytes.Buffer)
+	for _, entry := range cmdlog.Log {
+		if entry.Type == "error" {
+			fmt.Fprintln(stderr, entry.Message)
+		}
+	}
+	last := cmdlog.Log[len(cmdlog.Log)-1]
+	retcode, err := strconv.Atoi(last.RetCode.String())
+	if err != nil {
+		return nil, nil, &shellError{true, fmt.Errorf("cannot parse log_ret_code: %v", err)}
+	}
+	// lvm2 commands return ECMD_PROCESSED (1) on success and exit with
+	// status 0 in that case. Any other value is the exit status.
+	if retcode != 1 {
+		return report, stderr.Bytes(), exitStatusError(retcode)
+	}
+	return report, stderr.Bytes(), nil
+}
+
+// readPrompt reads from stdout until the shell prompts for a command. Any
+// other output is discarded.
+func (sh *lvmShell) readPrompt() error {
+	var buf []byte
+	for {
+		b, err := sh.stdout.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return errors.New("shell exited")
+			}
+			return err
+		}
+		buf = append(buf, b)
+		if bytes.HasSuffix(buf, []byte(shellPrompt)) {
+			return nil
+		}
+	}
+}
+
+// wait calls fn, which reads from the shell, and waits for it to return.
+// If ctx is done first the shell is killed so that fn returns.
+func (sh *lvmShell) wait(ctx context.Context, fn func() error) error {
+	errc := make(chan error, 1)
+	go func() { errc <- fn() }()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	log.Printf("Killing lvm shell: %v", ctx.Err())
+	sh.kill()
+	select {
+	case <-errc:
+	case <-time.After(killGracePeriod):
+		log.Printf("Gave up waiting for lvm shell to exit")
+	}
+	return ctx.Err()
+}
+
+func (sh *lvmShell) kill() {
+	if err := syscall.Kill(-sh.cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		log.Printf("Failed to kill process group %d: %v", sh.cmd.Process.Pid, err)
+	}
+}
+
+// close stops the shell and releases its resources.
+func (sh *lvmShell) close() {
+	sh.stdin.Close()
+	sh.kill()
+	for _, f := range sh.files {
+		f.Close()
+	}
+	// Reap the process in the background; it may be stuck in the kernel.
+	go sh.cmd.Wait()
+}
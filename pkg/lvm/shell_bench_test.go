@@ -0,0 +1,76 @@
+// +build !unit
+
+package lvm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestShellExecutorLVM(t *testing.T) {
+	shell := NewShellExecutor()
+	defer shell.Close()
+	SetExecutor(shell)
+	defer SetExecutor(nil)
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	if shell.shell == nil {
+		t.Fatal("Expected the lvm shell to be running")
+	}
+	size, err := vg.BytesFree(context.Background(), VolumeLayout{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lv, err := vg.CreateLogicalVolume(context.Background(), "test-lv", size, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lv.Remove(context.Background())
+	if _, err := vg.LookupLogicalVolume(context.Background(), "test-lv"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vg.LookupLogicalVolume(context.Background(), "missing-lv"); err != ErrLogicalVolumeNotFound {
+		t.Fatalf("Expected ErrLogicalVolumeNotFound but got %v", err)
+	}
+}
+
+// BenchmarkRunExec and BenchmarkRunShell compare the cost of running lvm2
+// commands as separate processes and in the lvm shell.
+func BenchmarkRunExec(b *testing.B) {
+	benchmarkRun(b, execExecutor{})
+}
+
+func BenchmarkRunShell(b *testing.B) {
+	shell := NewShellExecutor()
+	defer shell.Close()
+	benchmarkRun(b, shell)
+}
+
+func benchmarkRun(b *testing.B, e Executor) {
+	loop, err := CreateLoopDevice(pvsize)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer loop.Close()
+	vg, cleanup, err := createVolumeGroup([]*LoopDevice{loop}, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer cleanup()
+	SetExecutor(e)
+	defer SetExecutor(nil)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := vg.Report(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
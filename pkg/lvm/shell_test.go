@@ -0,0 +1,160 @@
+package lvm
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeShellScript emulates `lvm` in interactive mode. It records every line
+// it reads in the file named by $FAKE_SHELL_LOG.
+const fakeShellScript = `#!/bin/sh
+printf 'lvm> '
+while IFS= read -r line; do
+	echo "$line" >>"$FAKE_SHELL_LOG"
+	case "$line" in
+	lvs*)
+		echo '{"report":[{"lv":[{"lv_name":"lv0"}]}],"log":[{"log_type":"status","log_message":"success","log_ret_code":"1"}]}' >&3
+		;;
+	pvs\ --exit*)
+		exit 0
+		;;
+	pvs\ --hang*)
+		sleep 10
+		;;
+	*)
+		echo '{"log":[{"log_type":"error","log_message":"Failed to find logical volume \"vg/x\"","log_ret_code":"0"},{"log_type":"status","log_message":"failure","log_ret_code":"5"}]}' >&3
+		;;
+	esac
+	printf 'lvm> '
+done
+`
+
+type recordingExecutor struct {
+	cmds []string
+}
+
+func (e *recordingExecutor) Execute(ctx context.Context, cmd string, args ...string) ([]byte, []byte, error) {
+	e.cmds = append(e.cmds, strings.Join(append([]string{cmd}, args...), " "))
+	return nil, nil, nil
+}
+
+func fakeShellExecutor(t *testing.T) (*ShellExecutor, *recordingExecutor, func() []string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "lvmshell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "lvm")
+	if err := ioutil.WriteFile(script, []byte(fakeShellScript), 0755); err != nil {
+		t.Fatal(err)
+	}
+	logfile := filepath.Join(dir, "log")
+	os.Setenv("FAKE_SHELL_LOG", logfile)
+	fallback := &recordingExecutor{}
+	e := &ShellExecutor{path: script, fallback: fallback}
+	t.Cleanup(func() {
+		e.Close()
+		os.RemoveAll(dir)
+	})
+	lines := func() []string {
+		b, _ := ioutil.ReadFile(logfile)
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+	return e, fallback, lines
+}
+
+func TestShellExecutor(t *testing.T) {
+	e, fallback, lines := fakeShellExecutor(t)
+	ctx := context.Background()
+	stdout, _, err := e.Execute(ctx, "lvs", "--select=lv_tags=a b", "vg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := new(lvsOutput)
+	if err := json.Unmarshal(stdout, result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Report) != 1 || len(result.Report[0].Lv) != 1 || result.Report[0].Lv[0].Name != "lv0" {
+		t.Fatalf("Unexpected report %s", stdout)
+	}
+	_, stderr, err := e.Execute(ctx, "lvremove", "vg/x")
+	if ee, ok := err.(interface{ ExitCode() int }); !ok || ee.ExitCode() != 5 {
+		t.Fatalf("Expected exit status 5 but got %v", err)
+	}
+	if !isLogicalVolumeNotFound(string(stderr)) {
+		t.Fatalf("Unexpected stderr %q", stderr)
+	}
+	// The lvm shell runs lvm2 commands only.
+	if _, _, err := e.Execute(ctx, "partprobe", "/dev/x"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fallback.cmds) != 1 || fallback.cmds[0] != "partprobe /dev/x" {
+		t.Fatalf("Expected partprobe to be executed directly but got %v", fallback.cmds)
+	}
+	exp := []string{
+		`lvs "--select=lv_tags=a b" vg "` + shellConfig + `"`,
+		`lvremove vg/x "` + shellConfig + `"`,
+	}
+	if got := lines(); !equalStrings(got, exp) {
+		t.Fatalf("Expected shell input %q but got %q", exp, got)
+	}
+}
+
+func TestShellExecutorRestart(t *testing.T) {
+	e, _, _ := fakeShellExecutor(t)
+	ctx := context.Background()
+	if _, _, err := e.Execute(ctx, "lvs"); err != nil {
+		t.Fatal(err)
+	}
+	// The shell exits while running the command.
+	if _, _, err := e.Execute(ctx, "pvs", "--exit"); err == nil {
+		t.Fatal("Expected an error when the shell exits")
+	}
+	if _, _, err := e.Execute(ctx, "lvs"); err != nil {
+		t.Fatalf("Expected the shell to be restarted but got %v", err)
+	}
+}
+
+func TestShellExecutorTimeout(t *testing.T) {
+	e, _, _ := fakeShellExecutor(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := e.Execute(ctx, "pvs", "--hang"); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded but got %v", err)
+	}
+	if _, _, err := e.Execute(context.Background(), "lvs"); err != nil {
+		t.Fatalf("Expected the shell to be restarted but got %v", err)
+	}
+}
+
+func TestShellExecutorFallback(t *testing.T) {
+	fallback := &recordingExecutor{}
+	e := &ShellExecutor{path: "/nonexistent/lvm", fallback: fallback}
+	defer e.Close()
+	for i := 0; i < 2; i++ {
+		if _, _, err := e.Execute(context.Background(), "lvs", "vg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fallback.cmds) != 2 {
+		t.Fatalf("Expected commands to be executed directly but got %v", fallback.cmds)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}